@@ -2,4 +2,9 @@ module metrics-api
 
 go 1.22
 
-require github.com/lib/pq v1.10.9
+require (
+	github.com/klauspost/compress v1.17.9
+	github.com/lib/pq v1.10.9
+	google.golang.org/protobuf v1.34.2
+	gopkg.in/yaml.v3 v3.0.1
+)
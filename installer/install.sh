@@ -5,10 +5,11 @@ API_URL="${API_URL:-https://scm-metrics-api.citypost.us/api/metrics}"
 CONF="${CONF:-/etc/telegraf/telegraf.conf}"
 CONF_DIR="${CONF_DIR:-/etc/telegraf/telegraf.d}"
 KIOSK_JSON="${KIOSK_JSON:-/opt/scmkiosk/db_data/data_files/kiosk.json}"
+INGEST_TOKEN="${INGEST_TOKEN:-}"
 HOSTNAME="$(hostname)"
 NET_IFACE="$(ip route | awk '/default/ {print $5; exit}')"
 NET_IFACE="${NET_IFACE:-enp1s0}"
-export API_URL CONF CONF_DIR KIOSK_JSON HOSTNAME NET_IFACE
+export API_URL CONF CONF_DIR KIOSK_JSON HOSTNAME NET_IFACE INGEST_TOKEN
 SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
 ASSET_ROOT="$SCRIPT_DIR"
 BIN_DIR="$ASSET_ROOT/bin"
@@ -268,6 +269,12 @@ sudo mkdir -p "$CONF_DIR"
 
 install_template_config "http-output.conf.tmpl" "$CONF_DIR/http-output.conf"
 
+# No token issued for this kiosk yet – drop the empty Authorization header
+if [[ -z "$INGEST_TOKEN" ]]; then
+  echo "⚠ INGEST_TOKEN not set – sending metrics without a bearer token"
+  sudo sed -i '/Authorization = /d' "$CONF_DIR/http-output.conf"
+fi
+
 # ------------------------------------------------------------
 # Sensors + Net inputs
 # ------------------------------------------------------------
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"net/url"
 	"os"
 	"strconv"
@@ -273,6 +274,9 @@ func ensureSchema(conn *sql.DB) error {
 	if _, err := conn.Exec("ALTER TABLE server_metrics ADD COLUMN IF NOT EXISTS region_name TEXT"); err != nil {
 		return err
 	}
+	if _, err := conn.Exec("ALTER TABLE server_metrics ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ NULL"); err != nil {
+		return err
+	}
 	if _, err := conn.Exec("CREATE INDEX IF NOT EXISTS idx_server_metrics_server_id_time_desc ON server_metrics (server_id, time DESC)"); err != nil {
 		return err
 	}
@@ -289,6 +293,41 @@ func ensureSchema(conn *sql.DB) error {
 	if _, err := conn.Exec("CREATE INDEX IF NOT EXISTS idx_metric_points_series_time_desc ON metric_points (server_id, measurement, field, time DESC)"); err != nil {
 		return err
 	}
+	if _, err := conn.Exec("ALTER TABLE metric_points ADD COLUMN IF NOT EXISTS tags_hash TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err := conn.Exec("ALTER TABLE metric_points ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ NULL"); err != nil {
+		return err
+	}
+	if _, err := conn.Exec("ALTER TABLE metric_points ADD COLUMN IF NOT EXISTS source TEXT NULL"); err != nil {
+		return err
+	}
+	if err := ensureUniqueIndexes(conn); err != nil {
+		return err
+	}
+
+	if _, err := conn.Exec("CREATE TABLE IF NOT EXISTS ingest_tokens (id BIGSERIAL PRIMARY KEY, server_id TEXT NOT NULL, token_hash TEXT NOT NULL UNIQUE, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), revoked_at TIMESTAMPTZ NULL, last_used_at TIMESTAMPTZ NULL)"); err != nil {
+		return err
+	}
+	if _, err := conn.Exec("CREATE INDEX IF NOT EXISTS idx_ingest_tokens_server_id ON ingest_tokens (server_id)"); err != nil {
+		return err
+	}
+	if _, err := conn.Exec("CREATE TABLE IF NOT EXISTS ingest_secrets (server_id TEXT PRIMARY KEY, secret TEXT NOT NULL, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), updated_at TIMESTAMPTZ NOT NULL DEFAULT now())"); err != nil {
+		return err
+	}
+
+	if _, err := conn.Exec("CREATE TABLE IF NOT EXISTS raw_payloads (id BIGSERIAL, received_at TIMESTAMPTZ NOT NULL, server_id TEXT NOT NULL, min_time TIMESTAMPTZ NULL, max_time TIMESTAMPTZ NULL, payload BYTEA NOT NULL)"); err != nil {
+		return err
+	}
+	if _, err := conn.Exec("CREATE INDEX IF NOT EXISTS idx_raw_payloads_server_id_received_at ON raw_payloads (server_id, received_at)"); err != nil {
+		return err
+	}
+	if _, err := conn.Exec("CREATE TABLE IF NOT EXISTS archive_gaps (id BIGSERIAL PRIMARY KEY, server_id TEXT NOT NULL, min_time TIMESTAMPTZ NOT NULL, max_time TIMESTAMPTZ NOT NULL, payloads INTEGER NOT NULL, recorded_at TIMESTAMPTZ NOT NULL DEFAULT now())"); err != nil {
+		return err
+	}
+	if _, err := conn.Exec("CREATE INDEX IF NOT EXISTS idx_archive_gaps_server_id_min_time ON archive_gaps (server_id, min_time)"); err != nil {
+		return err
+	}
 
 	var timescaleAvailable bool
 	if err := conn.QueryRow("SELECT EXISTS(SELECT 1 FROM pg_available_extensions WHERE name = 'timescaledb')").Scan(&timescaleAvailable); err != nil {
@@ -304,11 +343,50 @@ func ensureSchema(conn *sql.DB) error {
 		if _, err := conn.Exec("SELECT create_hypertable('metric_points', 'time', if_not_exists => TRUE)"); err != nil {
 			return err
 		}
+		if _, err := conn.Exec("SELECT create_hypertable('raw_payloads', 'received_at', chunk_time_interval => INTERVAL '1 day', if_not_exists => TRUE)"); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// ensureUniqueIndexes makes retried writes idempotent: one summary row per
+// (server_id, time) and one series point per series and time, where a series
+// is (server_id, measurement, field, tags). The indexes are only built here on
+// an empty table. An existing table may hold duplicates and points without a
+// tags_hash, which migrations/008_unique_series.sql cleans up chunk by chunk
+// before building the index; that is too slow to block startup on.
+func ensureUniqueIndexes(conn *sql.DB) error {
+	indexes := []struct {
+		table, name, columns string
+	}{
+		{"server_metrics", "idx_server_metrics_server_id_time_unique", "server_id, time"},
+		{"metric_points", "idx_metric_points_series_unique", "server_id, measurement, field, tags_hash, time"},
+	}
+	for _, idx := range indexes {
+		var exists bool
+		if err := conn.QueryRow("SELECT to_regclass($1) IS NOT NULL", idx.name).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		var hasRows bool
+		if err := conn.QueryRow(fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s)", idx.table)).Scan(&hasRows); err != nil {
+			return err
+		}
+		if hasRows {
+			log.Printf("db: %s has no unique index %s yet; writes to it fail until migrations/008_unique_series.sql is applied", idx.table, idx.name)
+			continue
+		}
+		if _, err := conn.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s)", idx.name, idx.table, idx.columns)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
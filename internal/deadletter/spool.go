@@ -0,0 +1,569 @@
+// Package deadletter keeps series-point and summary batches that failed to
+// insert in an on-disk spool and retries them with exponential backoff. The spool lives on
+// the local filesystem rather than in Postgres so it keeps working while the
+// database is the thing that is down.
+package deadletter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+var (
+	// ErrNotFound is returned for unknown batch ids.
+	ErrNotFound = errors.New("dead-letter batch not found")
+	// ErrBusy is returned when a batch is already being retried.
+	ErrBusy = errors.New("dead-letter batch is being retried")
+	// ErrFull is returned by Put when the spool has reached its size limit.
+	ErrFull = errors.New("dead-letter spool is full")
+)
+
+// Batch kinds.
+const (
+	KindPoints    = "points"
+	KindSummaries = "summaries"
+)
+
+// Store writes retried batches to the database.
+type Store interface {
+	SaveSeriesPoints(ctx context.Context, points []models.SeriesPoint) error
+	SaveMetrics(ctx context.Context, metrics []models.CleanMetric) error
+}
+
+// Entry describes one spooled batch. Kind is KindPoints (also for batches
+// spooled before summaries were) or KindSummaries.
+type Entry struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+	Points    int       `json:"points"`
+	Summaries int       `json:"summaries,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	NextRetry time.Time `json:"next_retry"`
+	// Quarantined batches failed MaxAttempts times. They are kept in the
+	// quarantine subdirectory and only retried on request.
+	Quarantined bool `json:"quarantined"`
+
+	busy bool
+}
+
+// Options tunes a spool. Zero values fall back to the defaults below.
+type Options struct {
+	// MaxBytes caps the total size of the spool files.
+	MaxBytes int64
+	// BaseDelay is the wait before the first retry; it doubles per attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff.
+	MaxDelay time.Duration
+	// MaxAttempts is how many failed inserts quarantine a batch.
+	MaxAttempts int
+}
+
+const (
+	defaultMaxBytes    = 512 << 20
+	defaultBaseDelay   = 30 * time.Second
+	defaultMaxDelay    = 15 * time.Minute
+	defaultMaxAttempts = 10
+
+	quarantineDir = "quarantine"
+)
+
+// Spool is a directory of dead-lettered batches, one file per batch: a JSON
+// Entry line followed by a JSON array of points or summary rows.
+type Spool struct {
+	dir  string
+	opts Options
+	seq  atomic.Uint64
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	size    int64
+}
+
+// Open creates dir if needed and loads the batches already spooled there,
+// including the quarantined ones.
+func Open(dir string, opts Options) (*Spool, error) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultMaxBytes
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = defaultBaseDelay
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = defaultMaxDelay
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if err := os.MkdirAll(filepath.Join(dir, quarantineDir), 0o750); err != nil {
+		return nil, err
+	}
+	// A volume mounted without write access for this user passes MkdirAll;
+	// find out now rather than when the first batch fails.
+	probe, err := os.CreateTemp(dir, ".probe-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	s := &Spool{dir: dir, opts: opts, entries: make(map[string]*Entry)}
+	if err := s.load(dir, false); err != nil {
+		return nil, err
+	}
+	if err := s.load(filepath.Join(dir, quarantineDir), true); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Spool) load(dir string, quarantined bool) error {
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, de := range names {
+		name := de.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		e, err := readEntry(filepath.Join(dir, name))
+		if err != nil {
+			log.Printf("deadletter: skipping unreadable %s: %v", name, err)
+			continue
+		}
+		if e.Kind == "" {
+			e.Kind = KindPoints
+		}
+		e.Quarantined = quarantined
+		s.entries[e.ID] = e
+		s.size += e.Bytes
+	}
+	return nil
+}
+
+// Dir returns the spool directory.
+func (s *Spool) Dir() string {
+	return s.dir
+}
+
+// Put spools a failed series-point batch with the error that made it fail.
+func (s *Spool) Put(points []models.SeriesPoint, cause error) (Entry, error) {
+	e := s.newEntry(KindPoints)
+	e.Points = len(points)
+	return s.put(e, encodePoints(points), cause)
+}
+
+// PutSummaries spools a failed batch of summary rows with the error that made
+// it fail.
+func (s *Spool) PutSummaries(rows []models.CleanMetric, cause error) (Entry, error) {
+	e := s.newEntry(KindSummaries)
+	e.Summaries = len(rows)
+	return s.put(e, rows, cause)
+}
+
+func (s *Spool) newEntry(kind string) *Entry {
+	now := time.Now()
+	return &Entry{
+		ID:        fmt.Sprintf("%d-%d", now.UnixNano(), s.seq.Add(1)),
+		Kind:      kind,
+		CreatedAt: now.UTC(),
+		Attempts:  1,
+		NextRetry: now.Add(s.opts.BaseDelay).UTC(),
+	}
+}
+
+func (s *Spool) put(e *Entry, body interface{}, cause error) (Entry, error) {
+	if cause != nil {
+		e.LastError = cause.Error()
+	}
+
+	s.mu.Lock()
+	full := s.size >= s.opts.MaxBytes
+	s.mu.Unlock()
+	if full {
+		return Entry{}, ErrFull
+	}
+
+	if err := s.write(e, body); err != nil {
+		return Entry{}, err
+	}
+
+	s.mu.Lock()
+	s.entries[e.ID] = e
+	s.size += e.Bytes
+	out := *e
+	s.mu.Unlock()
+	return out, nil
+}
+
+// List returns the spooled batches, oldest first.
+func (s *Spool) List() []Entry {
+	s.mu.Lock()
+	out := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		out = append(out, *e)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Stats returns the number of spooled batches and their total size.
+func (s *Spool) Stats() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries), s.size
+}
+
+// Quarantined returns the number of quarantined batches.
+func (s *Spool) Quarantined() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, e := range s.entries {
+		if e.Quarantined {
+			n++
+		}
+	}
+	return n
+}
+
+// Retry tries to save one batch now. On success the batch is removed; on
+// failure its attempt count and next retry time are updated, and after
+// MaxAttempts failures it is moved to quarantine.
+func (s *Spool) Retry(ctx context.Context, id string, store Store) error {
+	s.mu.Lock()
+	e, ok := s.entries[id]
+	if !ok {
+		s.mu.Unlock()
+		return ErrNotFound
+	}
+	if e.busy {
+		s.mu.Unlock()
+		return ErrBusy
+	}
+	e.busy = true
+	s.mu.Unlock()
+
+	path := s.path(e)
+	body, err := readBody(path)
+	if err != nil {
+		s.mu.Lock()
+		e.busy = false
+		s.mu.Unlock()
+		return fmt.Errorf("read %s: %w", id, err)
+	}
+
+	saveErr := saveBody(ctx, e.Kind, body, store)
+
+	if saveErr == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("deadletter: remove %s: %v", id, err)
+		}
+		s.mu.Lock()
+		delete(s.entries, id)
+		s.size -= e.Bytes
+		s.mu.Unlock()
+		return nil
+	}
+
+	upd := *e
+	upd.Attempts++
+	upd.LastError = saveErr.Error()
+	upd.NextRetry = time.Now().Add(s.backoff(upd.Attempts)).UTC()
+	upd.busy = false
+	if !upd.Quarantined && upd.Attempts >= s.opts.MaxAttempts {
+		upd.Quarantined = true
+		log.Printf("deadletter: quarantining %s after %d attempts: %v", id, upd.Attempts, saveErr)
+	}
+	if err := s.write(&upd, body); err != nil {
+		log.Printf("deadletter: update %s: %v", id, err)
+		upd.Quarantined = e.Quarantined
+	} else if upd.Quarantined != e.Quarantined {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("deadletter: remove %s: %v", id, err)
+		}
+	}
+
+	s.mu.Lock()
+	s.size += upd.Bytes - e.Bytes
+	*e = upd
+	s.mu.Unlock()
+	return saveErr
+}
+
+// RetryAll retries every batch that is not already being retried,
+// regardless of its backoff and including quarantined ones, and returns how
+// many succeeded and failed.
+func (s *Spool) RetryAll(ctx context.Context, store Store) (int, int) {
+	return s.retryWhere(ctx, store, func(Entry) bool { return true })
+}
+
+// RetryDue retries the batches that are not quarantined and whose backoff has
+// elapsed. A failing batch only pushes back its own next retry, so one batch
+// the database keeps refusing does not hold up the others; while the database
+// is down every batch backs off on its own.
+func (s *Spool) RetryDue(ctx context.Context, store Store) (int, int) {
+	now := time.Now()
+	return s.retryWhere(ctx, store, func(e Entry) bool { return !e.Quarantined && !now.Before(e.NextRetry) })
+}
+
+func (s *Spool) retryWhere(ctx context.Context, store Store, want func(Entry) bool) (int, int) {
+	succeeded, failed := 0, 0
+	for _, e := range s.List() {
+		if ctx.Err() != nil {
+			break
+		}
+		if e.busy || !want(e) {
+			continue
+		}
+		switch err := s.Retry(ctx, e.ID, store); {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, ErrNotFound), errors.Is(err, ErrBusy):
+		default:
+			failed++
+		}
+	}
+	return succeeded, failed
+}
+
+// Run retries due batches every interval until ctx is cancelled.
+func (s *Spool) Run(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ok, failed := s.RetryDue(ctx, store); ok > 0 || failed > 0 {
+				n, size := s.Stats()
+				log.Printf("deadletter: retried %d ok, %d failed; %d batches (%d bytes) spooled, %d quarantined", ok, failed, n, size, s.Quarantined())
+			}
+		}
+	}
+}
+
+// Purge deletes one batch without retrying it.
+func (s *Spool) Purge(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if e.busy {
+		return ErrBusy
+	}
+	if err := os.Remove(s.path(e)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	delete(s.entries, id)
+	s.size -= e.Bytes
+	return nil
+}
+
+// PurgeAll deletes every batch that is not being retried and returns how
+// many were deleted.
+func (s *Spool) PurgeAll() (int, error) {
+	purged := 0
+	for _, e := range s.List() {
+		switch err := s.Purge(e.ID); {
+		case err == nil:
+			purged++
+		case errors.Is(err, ErrNotFound), errors.Is(err, ErrBusy):
+		default:
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+func (s *Spool) backoff(attempts int) time.Duration {
+	d := s.opts.BaseDelay
+	for i := 1; i < attempts && d < s.opts.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > s.opts.MaxDelay {
+		d = s.opts.MaxDelay
+	}
+	return d
+}
+
+func (s *Spool) path(e *Entry) string {
+	if e.Quarantined {
+		return filepath.Join(s.dir, quarantineDir, e.ID+".json")
+	}
+	return filepath.Join(s.dir, e.ID+".json")
+}
+
+// spooledPoint is the on-disk form of models.SeriesPoint.
+type spooledPoint struct {
+	Time        time.Time       `json:"time"`
+	ServerID    string          `json:"server_id"`
+	Measurement string          `json:"measurement"`
+	Field       string          `json:"field"`
+	ValueDouble *float64        `json:"value_double,omitempty"`
+	ValueInt    *int64          `json:"value_int,omitempty"`
+	Tags        json.RawMessage `json:"tags"`
+	ReceivedAt  *time.Time      `json:"received_at,omitempty"`
+	Source      string          `json:"source,omitempty"`
+}
+
+// encodePoints converts points to their on-disk form.
+func encodePoints(points []models.SeriesPoint) []spooledPoint {
+	out := make([]spooledPoint, len(points))
+	for i, p := range points {
+		tags := json.RawMessage(p.TagsJSON)
+		if len(tags) == 0 {
+			tags = json.RawMessage(`{}`)
+		}
+		out[i] = spooledPoint{p.Time, p.ServerID, p.Measurement, p.Field, p.ValueDouble, p.ValueInt, tags, nil, p.Source}
+		if !p.ReceivedAt.IsZero() {
+			received := p.ReceivedAt
+			out[i].ReceivedAt = &received
+		}
+	}
+	return out
+}
+
+// write stores the entry and its batch atomically and sets e.Bytes. body is
+// the batch in its on-disk form, or the raw JSON read back by readBody.
+func (s *Spool) write(e *Entry, body interface{}) error {
+	tmp, err := os.CreateTemp(s.dir, e.ID+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(e); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := enc.Encode(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	e.Bytes = info.Size()
+	return os.Rename(tmp.Name(), s.path(e))
+}
+
+func readEntry(path string) (*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var e Entry
+	if err := json.NewDecoder(f).Decode(&e); err != nil {
+		return nil, err
+	}
+	if e.ID == "" {
+		return nil, errors.New("missing id")
+	}
+	if info, err := f.Stat(); err == nil {
+		e.Bytes = info.Size()
+	}
+	return &e, nil
+}
+
+// readBody returns the batch of a spool file as raw JSON.
+func readBody(path string) (json.RawMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	var e Entry
+	if err := dec.Decode(&e); err != nil {
+		return nil, err
+	}
+	var body json.RawMessage
+	if err := dec.Decode(&body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// saveBody decodes a batch of the given kind and writes it with store.
+func saveBody(ctx context.Context, kind string, body json.RawMessage, store Store) error {
+	if kind == KindSummaries {
+		var rows []models.CleanMetric
+		if err := json.Unmarshal(body, &rows); err != nil {
+			return fmt.Errorf("decode summaries: %w", err)
+		}
+		return store.SaveMetrics(ctx, rows)
+	}
+	points, err := decodePoints(body)
+	if err != nil {
+		return fmt.Errorf("decode points: %w", err)
+	}
+	return store.SaveSeriesPoints(ctx, points)
+}
+
+func decodePoints(body json.RawMessage) ([]models.SeriesPoint, error) {
+	var in []spooledPoint
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+	points := make([]models.SeriesPoint, len(in))
+	for i, p := range in {
+		points[i] = models.SeriesPoint{
+			Time:        p.Time,
+			ServerID:    p.ServerID,
+			Measurement: p.Measurement,
+			Field:       p.Field,
+			ValueDouble: p.ValueDouble,
+			ValueInt:    p.ValueInt,
+			TagsJSON:    []byte(p.Tags),
+			Source:      p.Source,
+		}
+		if p.ReceivedAt != nil {
+			points[i].ReceivedAt = *p.ReceivedAt
+		}
+	}
+	return points, nil
+}
@@ -0,0 +1,201 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// fakeStore records what retries saved and fails while err is set.
+type fakeStore struct {
+	err       error
+	points    []models.SeriesPoint
+	summaries []models.CleanMetric
+}
+
+func (f *fakeStore) SaveSeriesPoints(ctx context.Context, points []models.SeriesPoint) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.points = append(f.points, points...)
+	return nil
+}
+
+func (f *fakeStore) SaveMetrics(ctx context.Context, rows []models.CleanMetric) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.summaries = append(f.summaries, rows...)
+	return nil
+}
+
+func TestOpenUnwritableDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to any directory")
+	}
+	dir := filepath.Join(t.TempDir(), "spool")
+	if err := os.MkdirAll(filepath.Join(dir, quarantineDir), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0o550); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0o750) })
+	if _, err := Open(dir, Options{}); err == nil {
+		t.Fatal("Open accepted a directory it cannot write to")
+	}
+}
+
+func TestSpoolSummariesRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	rows := []models.CleanMetric{{
+		ServerID:        "kiosk-1",
+		Time:            at,
+		ReceivedAt:      at.Add(time.Second),
+		CPU:             12.5,
+		LinkState:       &models.LinkState{Interface: "wlan0", LinkUp: true},
+		ProcessStatuses: []models.ProcessStatus{{Name: "kiosk-browser", Running: true, ProcessCount: 3}},
+	}}
+	e, err := s.PutSummaries(rows, errors.New("connection refused"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Kind != KindSummaries || e.Summaries != 1 || e.Points != 0 {
+		t.Errorf("entry = %+v, want one summary row", e)
+	}
+	value := 1.5
+	if _, err := s.Put([]models.SeriesPoint{{Time: at, ServerID: "kiosk-1", Measurement: "cpu", Field: "usage_user", ValueDouble: &value, Source: models.SourceTelegraf}}, errors.New("timeout")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening must keep both kinds apart.
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &fakeStore{}
+	if ok, failed := s.RetryAll(context.Background(), store); ok != 2 || failed != 0 {
+		t.Fatalf("RetryAll = %d ok, %d failed", ok, failed)
+	}
+	if len(store.summaries) != 1 || len(store.points) != 1 {
+		t.Fatalf("saved %d summaries and %d points, want 1 and 1", len(store.summaries), len(store.points))
+	}
+	got := store.summaries[0]
+	if got.ServerID != "kiosk-1" || !got.Time.Equal(at) || !got.ReceivedAt.Equal(rows[0].ReceivedAt) || got.CPU != 12.5 {
+		t.Errorf("summary = %+v", got)
+	}
+	if got.LinkState == nil || got.LinkState.Interface != "wlan0" || len(got.ProcessStatuses) != 1 {
+		t.Errorf("nested fields lost: %+v", got)
+	}
+	if p := store.points[0]; p.Source != models.SourceTelegraf || *p.ValueDouble != 1.5 {
+		t.Errorf("point = %+v", p)
+	}
+	if n, _ := s.Stats(); n != 0 {
+		t.Errorf("spool holds %d batches after retry, want 0", n)
+	}
+}
+
+func TestSpoolRetryFailureKeepsBatch(t *testing.T) {
+	s, err := Open(t.TempDir(), Options{BaseDelay: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := s.PutSummaries([]models.CleanMetric{{ServerID: "kiosk-1"}}, errors.New("down"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &fakeStore{err: errors.New("still down")}
+	if err := s.Retry(context.Background(), e.ID, store); err == nil {
+		t.Fatal("Retry succeeded against a failing store")
+	}
+	list := s.List()
+	if len(list) != 1 || list[0].Attempts != 2 || list[0].LastError != "still down" || list[0].Kind != KindSummaries {
+		t.Fatalf("after failed retry: %+v", list)
+	}
+
+	store.err = nil
+	if err := s.Retry(context.Background(), e.ID, store); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.summaries) != 1 {
+		t.Errorf("saved %d summaries, want 1", len(store.summaries))
+	}
+}
+
+// poisonStore refuses the batches of one server and saves the rest.
+type poisonStore struct {
+	fakeStore
+	poison string
+}
+
+func (p *poisonStore) SaveSeriesPoints(ctx context.Context, points []models.SeriesPoint) error {
+	for _, pt := range points {
+		if pt.ServerID == p.poison {
+			return errors.New("violates check constraint")
+		}
+	}
+	return p.fakeStore.SaveSeriesPoints(ctx, points)
+}
+
+func TestRetryDueSkipsPoisonBatchAndQuarantines(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{BaseDelay: time.Nanosecond, MaxDelay: time.Nanosecond, MaxAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The poison batch is the oldest, so an oldest-first pass meets it first.
+	for _, id := range []string{"kiosk-bad", "kiosk-1", "kiosk-2"} {
+		if _, err := s.Put([]models.SeriesPoint{{ServerID: id, Measurement: "cpu", Field: "usage_user"}}, errors.New("down")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+
+	store := &poisonStore{poison: "kiosk-bad"}
+	if ok, failed := s.RetryDue(context.Background(), store); ok != 2 || failed != 1 {
+		t.Fatalf("RetryDue = %d ok, %d failed; want 2 ok, 1 failed", ok, failed)
+	}
+	if len(store.points) != 2 {
+		t.Fatalf("saved %d points, want 2", len(store.points))
+	}
+
+	// Attempt 3 quarantines the batch; later passes leave it alone.
+	time.Sleep(time.Millisecond)
+	s.RetryDue(context.Background(), store)
+	if s.Quarantined() != 1 {
+		t.Fatalf("quarantined = %d, want 1", s.Quarantined())
+	}
+	time.Sleep(time.Millisecond)
+	if ok, failed := s.RetryDue(context.Background(), store); ok != 0 || failed != 0 {
+		t.Errorf("RetryDue touched a quarantined batch: %d ok, %d failed", ok, failed)
+	}
+
+	// Quarantine survives a restart and a manual retry still reaches it.
+	s, err = Open(dir, Options{MaxAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := s.List()
+	if len(list) != 1 || !list[0].Quarantined || list[0].Attempts != 3 {
+		t.Fatalf("after reopen: %+v", list)
+	}
+	store.poison = ""
+	if ok, failed := s.RetryAll(context.Background(), store); ok != 1 || failed != 0 {
+		t.Fatalf("RetryAll = %d ok, %d failed", ok, failed)
+	}
+	if n, _ := s.Stats(); n != 0 {
+		t.Errorf("spool holds %d batches, want 0", n)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"metrics-api/internal/deadletter"
+)
+
+type deadLetterRequest struct {
+	ID  string `json:"id"`
+	All bool   `json:"all"`
+}
+
+func (h *MetricsHandler) deadLetterEnabled(w http.ResponseWriter) bool {
+	if h.deadLetters == nil {
+		WriteJSONError(w, http.StatusServiceUnavailable, "dead-letter spool disabled")
+		return false
+	}
+	return true
+}
+
+// decodeDeadLetterRequest accepts an empty body as an empty request.
+func decodeDeadLetterRequest(w http.ResponseWriter, r *http.Request) (deadLetterRequest, error) {
+	var req deadLetterRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return req, err
+}
+
+func deadLetterErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, deadletter.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, deadletter.ErrBusy):
+		return http.StatusConflict
+	}
+	return http.StatusServiceUnavailable
+}
+
+// AdminDeadLetters lists the dead-lettered series-point and summary batches,
+// oldest first.
+func (h *MetricsHandler) AdminDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !h.deadLetterEnabled(w) {
+		return
+	}
+	p, err := parsePaginationParams(r, defaultPageSize, maxPageSize)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "invalid pagination parameters")
+		return
+	}
+
+	entries := h.deadLetters.List()
+	if p.offset > len(entries) {
+		p.offset = len(entries)
+	}
+	end := p.offset + p.limit
+	hasMore := end < len(entries)
+	if !hasMore {
+		end = len(entries)
+	}
+	writePaginatedResponse(w, http.StatusOK, entries[p.offset:end], p.page, p.pageSize, hasMore)
+}
+
+// AdminDeadLettersRetry retries one batch ({"id": "..."}) or every batch
+// ({} or {"all": true}) immediately, ignoring the backoff.
+func (h *MetricsHandler) AdminDeadLettersRetry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !h.deadLetterEnabled(w) {
+		return
+	}
+	req, err := decodeDeadLetterRequest(w, r)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	if req.ID != "" {
+		if err := h.deadLetters.Retry(r.Context(), req.ID, h.repo); err != nil {
+			WriteJSONError(w, deadLetterErrorStatus(err), err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]int{"succeeded": 1, "failed": 0})
+		return
+	}
+
+	succeeded, failed := h.deadLetters.RetryAll(r.Context(), h.repo)
+	WriteJSON(w, http.StatusOK, map[string]int{"succeeded": succeeded, "failed": failed})
+}
+
+// AdminDeadLettersPurge deletes one batch ({"id": "..."}) or every batch
+// ({"all": true}) without retrying it.
+func (h *MetricsHandler) AdminDeadLettersPurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !h.deadLetterEnabled(w) {
+		return
+	}
+	req, err := decodeDeadLetterRequest(w, r)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	switch {
+	case req.ID != "":
+		if err := h.deadLetters.Purge(req.ID); err != nil {
+			status := deadLetterErrorStatus(err)
+			if status == http.StatusServiceUnavailable {
+				status = http.StatusInternalServerError
+			}
+			WriteJSONError(w, status, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]int{"purged": 1})
+	case req.All:
+		n, err := h.deadLetters.PurgeAll()
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]int{"purged": n})
+	default:
+		WriteJSONError(w, http.StatusBadRequest, "id or all required")
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import "net/http"
+
+// AdminSecrets lists the kiosks with an HMAC signing secret (GET) or
+// generates a new secret for a kiosk, replacing any previous one (POST
+// {"server_id": "..."}). The secret is only returned in the POST response.
+func (h *MetricsHandler) AdminSecrets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		p, err := parsePaginationParams(r, defaultPageSize, maxPageSize)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, "invalid pagination parameters")
+			return
+		}
+		items, hasMore, err := h.repo.ListIngestSecrets(r.Context(), p.limit, p.offset)
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writePaginatedResponse(w, http.StatusOK, items, p.page, p.pageSize, hasMore)
+
+	case http.MethodPost:
+		req, err := decodeAdminRequest(w, r)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+		if req.ServerID == "" {
+			WriteJSONError(w, http.StatusBadRequest, "server_id required")
+			return
+		}
+
+		secret, err := GenerateIngestToken()
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		stored, err := h.repo.SetIngestSecret(r.Context(), req.ServerID, secret)
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		stored.Secret = secret
+		WriteJSON(w, http.StatusCreated, stored)
+
+	default:
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// AdminSecretsRevoke deletes the signing secret of a kiosk.
+func (h *MetricsHandler) AdminSecretsRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req, err := decodeAdminRequest(w, r)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if req.ServerID == "" {
+		WriteJSONError(w, http.StatusBadRequest, "server_id required")
+		return
+	}
+
+	ok, err := h.repo.DeleteIngestSecret(r.Context(), req.ServerID)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		WriteJSONError(w, http.StatusNotFound, "no secret for that server_id")
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]int64{"revoked": 1})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type adminRequest struct {
+	ID           int64  `json:"id"`
+	ServerID     string `json:"server_id"`
+	GraceSeconds int64  `json:"grace_seconds"`
+}
+
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request) (adminRequest, error) {
+	var req adminRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		return req, err
+	}
+	req.ServerID = strings.TrimSpace(req.ServerID)
+	return req, nil
+}
+
+// AdminTokens lists ingest tokens (GET) or issues an additional token for a
+// kiosk (POST {"server_id": "..."}). The plaintext token is only returned in
+// the POST response.
+func (h *MetricsHandler) AdminTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		p, err := parsePaginationParams(r, defaultPageSize, maxPageSize)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, "invalid pagination parameters")
+			return
+		}
+		q := r.URL.Query()
+		includeRevoked := q.Get("include_revoked") == "1" || q.Get("include_revoked") == "true"
+
+		items, hasMore, err := h.repo.ListIngestTokens(r.Context(), q.Get("server_id"), includeRevoked, p.limit, p.offset)
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writePaginatedResponse(w, http.StatusOK, items, p.page, p.pageSize, hasMore)
+
+	case http.MethodPost:
+		req, err := decodeAdminRequest(w, r)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+		if req.ServerID == "" {
+			WriteJSONError(w, http.StatusBadRequest, "server_id required")
+			return
+		}
+
+		token, err := GenerateIngestToken()
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		issued, err := h.repo.CreateIngestToken(r.Context(), req.ServerID, hashIngestToken(token))
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		issued.Token = token
+		WriteJSON(w, http.StatusCreated, issued)
+
+	default:
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// AdminTokensRotate issues a new token for a kiosk and expires its other
+// tokens after grace_seconds (default 0, i.e. immediately).
+func (h *MetricsHandler) AdminTokensRotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req, err := decodeAdminRequest(w, r)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if req.ServerID == "" {
+		WriteJSONError(w, http.StatusBadRequest, "server_id required")
+		return
+	}
+	if req.GraceSeconds < 0 {
+		WriteJSONError(w, http.StatusBadRequest, "grace_seconds must be >= 0")
+		return
+	}
+
+	token, err := GenerateIngestToken()
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	issued, err := h.repo.RotateIngestToken(r.Context(), req.ServerID, hashIngestToken(token), time.Duration(req.GraceSeconds)*time.Second)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	issued.Token = token
+	WriteJSON(w, http.StatusCreated, issued)
+}
+
+// AdminTokensRevoke revokes one token ({"id": n}) or every active token of a
+// kiosk ({"server_id": "..."}).
+func (h *MetricsHandler) AdminTokensRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req, err := decodeAdminRequest(w, r)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	switch {
+	case req.ID > 0:
+		ok, err := h.repo.RevokeIngestToken(r.Context(), req.ID)
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !ok {
+			WriteJSONError(w, http.StatusNotFound, "no active token with that id")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]int64{"revoked": 1})
+	case req.ServerID != "":
+		n, err := h.repo.RevokeIngestTokens(r.Context(), req.ServerID)
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]int64{"revoked": n})
+	default:
+		WriteJSONError(w, http.StatusBadRequest, "id or server_id required")
+	}
+}
@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"metrics-api/internal/ingest"
+	"metrics-api/internal/models"
+	"metrics-api/internal/repository"
+)
+
+// reprocessPageSize is how many archived payloads a reprocess job reads and
+// writes back per round-trip.
+const reprocessPageSize = 100
+
+// Reprocess job states.
+const (
+	ReprocessRunning = "running"
+	ReprocessDone    = "done"
+	ReprocessFailed  = "failed"
+)
+
+// archivePayloads queues one raw payload per kiosk for the archive writers.
+// bodies holds each host's metrics as received (before clock-skew clamping);
+// the time bounds come from the metrics as stored. A full queue drops the
+// payload rather than slowing ingest down, recording it as an archive gap.
+func (h *MetricsHandler) archivePayloads(hosts []ingest.HostGroup, bodies [][]byte, receivedAt time.Time) {
+	for i, hg := range hosts {
+		if hg.ServerID == "" || bodies[i] == nil {
+			continue
+		}
+		p := models.RawPayload{ReceivedAt: receivedAt, ServerID: hg.ServerID, Payload: bodies[i]}
+		p.MinTime, p.MaxTime = metricTimeBounds(hg.Metrics)
+		select {
+		case h.archive <- p:
+		default:
+			h.archiveGaps.Record([]models.RawPayload{p}, "archive queue full")
+		}
+	}
+}
+
+// metricTimeBounds returns the oldest and newest metric timestamps, or zero
+// times when no metric has one.
+func metricTimeBounds(metrics []models.Metric) (time.Time, time.Time) {
+	var oldest, newest float64
+	for _, m := range metrics {
+		if m.Timestamp <= 0 {
+			continue
+		}
+		if oldest == 0 || m.Timestamp < oldest {
+			oldest = m.Timestamp
+		}
+		if m.Timestamp > newest {
+			newest = m.Timestamp
+		}
+	}
+	if newest == 0 {
+		return time.Time{}, time.Time{}
+	}
+	return ingest.MetricTime(oldest), ingest.MetricTime(newest)
+}
+
+// ArchiveGaps records payloads that never reached the raw payload archive, so
+// reprocess jobs do not delete rows the archive cannot rebuild. Gaps are
+// merged per kiosk in memory and moved to the archive_gaps table by Flush;
+// gaps that could not be stored yet stay in memory and still count.
+type ArchiveGaps struct {
+	repo *repository.MetricsRepository
+
+	mu      sync.Mutex
+	pending map[string]models.ArchiveGap
+}
+
+func NewArchiveGaps(repo *repository.MetricsRepository) *ArchiveGaps {
+	return &ArchiveGaps{repo: repo, pending: make(map[string]models.ArchiveGap)}
+}
+
+// Record logs payloads that could not be archived and adds them to the
+// pending gaps. Payloads without metric timestamps are bounded by their
+// receive time, which ingest stamps on their metrics.
+func (g *ArchiveGaps) Record(payloads []models.RawPayload, reason string) {
+	if len(payloads) == 0 {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, p := range payloads {
+		minTime, maxTime := p.MinTime, p.MaxTime
+		if minTime.IsZero() {
+			minTime, maxTime = p.ReceivedAt, p.ReceivedAt
+		}
+		gap, ok := g.pending[p.ServerID]
+		if !ok {
+			gap = models.ArchiveGap{ServerID: p.ServerID, MinTime: minTime, MaxTime: maxTime}
+		}
+		if minTime.Before(gap.MinTime) {
+			gap.MinTime = minTime
+		}
+		if maxTime.After(gap.MaxTime) {
+			gap.MaxTime = maxTime
+		}
+		gap.Payloads++
+		g.pending[p.ServerID] = gap
+	}
+	log.Printf("archive: %s, %d payloads not archived (first server_id=%s); recorded as archive gaps", reason, len(payloads), payloads[0].ServerID)
+}
+
+// Flush stores the pending gaps. A gap that grew while it was being stored
+// stays pending and is stored again; overlapping rows are harmless.
+func (g *ArchiveGaps) Flush(ctx context.Context) error {
+	g.mu.Lock()
+	if len(g.pending) == 0 || g.repo == nil {
+		g.mu.Unlock()
+		return nil
+	}
+	gaps := make([]models.ArchiveGap, 0, len(g.pending))
+	for _, gap := range g.pending {
+		gaps = append(gaps, gap)
+	}
+	g.mu.Unlock()
+
+	if err := g.repo.SaveArchiveGaps(ctx, gaps); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	for _, gap := range gaps {
+		if g.pending[gap.ServerID] == gap {
+			delete(g.pending, gap.ServerID)
+		}
+	}
+	g.mu.Unlock()
+	return nil
+}
+
+// overlapping returns the stored and pending gaps of serverIDs overlapping
+// [from, to).
+func (g *ArchiveGaps) overlapping(ctx context.Context, serverIDs []string, from, to time.Time) ([]models.ArchiveGap, error) {
+	var out []models.ArchiveGap
+	g.mu.Lock()
+	for _, serverID := range serverIDs {
+		if gap, ok := g.pending[serverID]; ok && !gap.MaxTime.Before(from) && gap.MinTime.Before(to) {
+			out = append(out, gap)
+		}
+	}
+	g.mu.Unlock()
+
+	if g.repo == nil {
+		return out, nil
+	}
+	stored, err := g.repo.ArchiveGaps(ctx, serverIDs, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, stored...), nil
+}
+
+// ReprocessJob is a run of the current parser over archived payloads. Counts
+// are updated while the job runs.
+type ReprocessJob struct {
+	ID                string     `json:"id"`
+	State             string     `json:"state"`
+	From              time.Time  `json:"from"`
+	To                time.Time  `json:"to"`
+	ServerIDs         []string   `json:"server_ids"`
+	StartedAt         time.Time  `json:"started_at"`
+	FinishedAt        *time.Time `json:"finished_at,omitempty"`
+	DeletedSummaries  int64      `json:"deleted_summaries"`
+	DeletedPoints     int64      `json:"deleted_points"`
+	Payloads          int        `json:"payloads"`
+	SummariesWritten  int        `json:"summaries_written"`
+	PointsWritten     int        `json:"points_written"`
+	UndecodedPayloads int        `json:"undecoded_payloads"`
+	Error             string     `json:"error,omitempty"`
+}
+
+type reprocessJobs struct {
+	mu   sync.Mutex
+	next int
+	jobs []*ReprocessJob
+}
+
+func newReprocessJobs() *reprocessJobs {
+	return &reprocessJobs{}
+}
+
+// start registers a new job unless another one is still running.
+func (j *reprocessJobs) start(from, to time.Time, serverIDs []string) (*ReprocessJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, job := range j.jobs {
+		if job.State == ReprocessRunning {
+			return nil, false
+		}
+	}
+	j.next++
+	job := &ReprocessJob{
+		ID:        strconv.Itoa(j.next),
+		State:     ReprocessRunning,
+		From:      from,
+		To:        to,
+		ServerIDs: serverIDs,
+		StartedAt: time.Now().UTC(),
+	}
+	j.jobs = append(j.jobs, job)
+	return job, true
+}
+
+// update applies fn to job under the lock.
+func (j *reprocessJobs) update(job *ReprocessJob, fn func(*ReprocessJob)) {
+	j.mu.Lock()
+	fn(job)
+	j.mu.Unlock()
+}
+
+// snapshot returns copies of the jobs, newest first.
+func (j *reprocessJobs) snapshot() []ReprocessJob {
+	j.mu.Lock()
+	out := make([]ReprocessJob, len(j.jobs))
+	for i, job := range j.jobs {
+		out[i] = *job
+	}
+	j.mu.Unlock()
+	sort.Slice(out, func(a, b int) bool { return out[a].StartedAt.After(out[b].StartedAt) })
+	return out
+}
+
+type reprocessRequest struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	ServerIDs []string  `json:"server_ids"`
+}
+
+// AdminReprocess lists the reprocess jobs (GET) or starts one (POST) with
+// {"from": RFC3339, "to": RFC3339, "server_ids": [...]}. An empty server_ids
+// means every kiosk with archived metrics in the window. Only one job runs at
+// a time.
+func (h *MetricsHandler) AdminReprocess(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		p, err := parsePaginationParams(r, defaultPageSize, maxPageSize)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, "invalid pagination parameters")
+			return
+		}
+		jobs := h.reprocess.snapshot()
+		if p.offset > len(jobs) {
+			p.offset = len(jobs)
+		}
+		end := p.offset + p.limit
+		hasMore := end < len(jobs)
+		if !hasMore {
+			end = len(jobs)
+		}
+		writePaginatedResponse(w, http.StatusOK, jobs[p.offset:end], p.page, p.pageSize, hasMore)
+	case http.MethodPost:
+		h.startReprocess(w, r)
+	default:
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *MetricsHandler) startReprocess(w http.ResponseWriter, r *http.Request) {
+	var req reprocessRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		WriteJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.From.IsZero() || req.To.IsZero() || !req.From.Before(req.To) {
+		WriteJSONError(w, http.StatusBadRequest, "from and to required, from before to")
+		return
+	}
+	from, to := req.From.UTC(), req.To.UTC()
+
+	// With the archive off, newly ingested rows have no payload to be
+	// rebuilt from.
+	if h.archive == nil {
+		WriteJSONError(w, http.StatusConflict, "raw payload archive is off")
+		return
+	}
+
+	// Rows before the oldest archived payload cannot be rebuilt, so they must
+	// not be deleted.
+	oldest, ok, err := h.repo.OldestRawPayload(r.Context())
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		WriteJSONError(w, http.StatusConflict, "raw payload archive is empty")
+		return
+	}
+	if from.Before(oldest) {
+		WriteJSONError(w, http.StatusConflict, fmt.Sprintf("from is before the oldest archived payload (%s)", oldest.UTC().Format(time.RFC3339)))
+		return
+	}
+
+	serverIDs := req.ServerIDs
+	if len(serverIDs) == 0 {
+		serverIDs, err = h.repo.RawPayloadServers(r.Context(), from, to)
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Rows of payloads that never reached the archive would be lost.
+	gaps, err := h.archiveGaps.overlapping(r.Context(), serverIDs, from, to)
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(gaps) > 0 {
+		g := gaps[0]
+		WriteJSONError(w, http.StatusConflict, fmt.Sprintf("archive is missing %d payloads of server_id=%s between %s and %s (%d gaps in the window)",
+			g.Payloads, g.ServerID, g.MinTime.UTC().Format(time.RFC3339), g.MaxTime.UTC().Format(time.RFC3339), len(gaps)))
+		return
+	}
+
+	job, ok := h.reprocess.start(from, to, serverIDs)
+	if !ok {
+		WriteJSONError(w, http.StatusConflict, "a reprocess job is already running")
+		return
+	}
+	log.Printf("reprocess %s: from=%s to=%s servers=%d", job.ID, from.Format(time.RFC3339), to.Format(time.RFC3339), len(serverIDs))
+	go h.runReprocess(context.Background(), job)
+
+	var resp ReprocessJob
+	h.reprocess.update(job, func(j *ReprocessJob) { resp = *j })
+	WriteJSON(w, http.StatusAccepted, resp)
+}
+
+// runReprocess deletes the job's window and rebuilds it from the archive with
+// the current registry, writing straight to the database rather than through
+// the ingest queues. Only points of archived sources are deleted. Until it
+// finishes the window is partly empty; a failed job can simply be started
+// again.
+func (h *MetricsHandler) runReprocess(ctx context.Context, job *ReprocessJob) {
+	err := h.reprocessWindow(ctx, job)
+	var final ReprocessJob
+	h.reprocess.update(job, func(j *ReprocessJob) {
+		now := time.Now().UTC()
+		j.FinishedAt = &now
+		j.State = ReprocessDone
+		if err != nil {
+			j.State = ReprocessFailed
+			j.Error = err.Error()
+		}
+		final = *j
+	})
+	if err != nil {
+		log.Printf("reprocess %s: failed: %v", final.ID, err)
+		return
+	}
+	log.Printf("reprocess %s: done payloads=%d summaries=%d points=%d", final.ID, final.Payloads, final.SummariesWritten, final.PointsWritten)
+}
+
+func (h *MetricsHandler) reprocessWindow(ctx context.Context, job *ReprocessJob) error {
+	if len(job.ServerIDs) == 0 {
+		return nil
+	}
+	deletedSummaries, deletedPoints, err := h.repo.DeleteMetricsWindow(ctx, job.ServerIDs, job.From, job.To)
+	if err != nil {
+		return fmt.Errorf("delete window: %w", err)
+	}
+	h.reprocess.update(job, func(j *ReprocessJob) {
+		j.DeletedSummaries = deletedSummaries
+		j.DeletedPoints = deletedPoints
+	})
+
+	var afterReceived time.Time
+	var afterID int64
+	for {
+		payloads, err := h.repo.ListRawPayloads(ctx, job.ServerIDs, job.From, job.To, afterReceived, afterID, reprocessPageSize)
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+		if len(payloads) == 0 {
+			return nil
+		}
+
+		var summaries []models.CleanMetric
+		var points []models.SeriesPoint
+		undecoded := 0
+		for _, p := range payloads {
+			s, pts, err := h.rebuildPayload(p, job.From, job.To)
+			if err != nil {
+				log.Printf("reprocess %s: payload %d server_id=%s: %v", job.ID, p.ID, p.ServerID, err)
+				undecoded++
+				continue
+			}
+			summaries = append(summaries, s...)
+			points = append(points, pts...)
+		}
+		if err := h.repo.SaveMetrics(ctx, summaries); err != nil {
+			return fmt.Errorf("write summaries: %w", err)
+		}
+		if err := h.repo.SaveSeriesPoints(ctx, points); err != nil {
+			return fmt.Errorf("write series points: %w", err)
+		}
+
+		last := payloads[len(payloads)-1]
+		afterReceived, afterID = last.ReceivedAt, last.ID
+		h.reprocess.update(job, func(j *ReprocessJob) {
+			j.Payloads += len(payloads)
+			j.SummariesWritten += len(summaries)
+			j.PointsWritten += len(points)
+			j.UndecodedPayloads += undecoded
+		})
+	}
+}
+
+// rebuildPayload runs an archived payload through the current registry the
+// way ingest does (clamping its clock under ClockSkewClamp, one summary per
+// interval) and keeps the rows with time in [from, to).
+func (h *MetricsHandler) rebuildPayload(p models.RawPayload, from, to time.Time) ([]models.CleanMetric, []models.SeriesPoint, error) {
+	var payload models.TelegrafPayload
+	if err := decodeTelegrafPayload(bytes.NewReader(p.Payload), &payload); err != nil {
+		return nil, nil, err
+	}
+	if h.clockSkewPolicy == ClockSkewClamp {
+		if skew, ok := payloadSkew(payload.Metrics, p.ReceivedAt); ok && h.clockSkewed(skew) {
+			for i := range payload.Metrics {
+				if payload.Metrics[i].Timestamp > 0 {
+					payload.Metrics[i].Timestamp -= skew.Seconds()
+				}
+			}
+		}
+	}
+
+	inWindow := func(t time.Time) bool { return !t.Before(from) && t.Before(to) }
+	built, builtPoints := h.buildHost(p.ServerID, payload.Metrics, p.ReceivedAt)
+	var summaries []models.CleanMetric
+	for _, s := range built {
+		if inWindow(s.Time) {
+			summaries = append(summaries, s)
+		}
+	}
+	var points []models.SeriesPoint
+	for _, pt := range builtPoints {
+		if inWindow(pt.Time) {
+			pt.Source = models.SourceReprocess
+			points = append(points, pt)
+		}
+	}
+	return summaries, points, nil
+}
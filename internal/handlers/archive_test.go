@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"metrics-api/internal/ingest"
+	"metrics-api/internal/models"
+)
+
+func TestArchiveGaps(t *testing.T) {
+	g := NewArchiveGaps(nil)
+	at := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	g.Record([]models.RawPayload{
+		{ServerID: "kiosk-1", MinTime: at, MaxTime: at.Add(10 * time.Second)},
+		{ServerID: "kiosk-1", MinTime: at.Add(-time.Minute), MaxTime: at},
+		// No metric timestamps: bounded by the receive time.
+		{ServerID: "kiosk-2", ReceivedAt: at.Add(time.Hour)},
+	}, "archive queue full")
+
+	tests := []struct {
+		name      string
+		serverIDs []string
+		from, to  time.Time
+		want      []models.ArchiveGap
+	}{
+		{
+			name: "merged per kiosk", serverIDs: []string{"kiosk-1"}, from: at.Add(-time.Hour), to: at.Add(time.Hour),
+			want: []models.ArchiveGap{{ServerID: "kiosk-1", MinTime: at.Add(-time.Minute), MaxTime: at.Add(10 * time.Second), Payloads: 2}},
+		},
+		{name: "window after the gap", serverIDs: []string{"kiosk-1"}, from: at.Add(11 * time.Second), to: at.Add(time.Hour)},
+		{name: "window ends at the gap", serverIDs: []string{"kiosk-1"}, from: at.Add(-time.Hour), to: at.Add(-time.Minute)},
+		{
+			name: "receive time", serverIDs: []string{"kiosk-2", "kiosk-3"}, from: at, to: at.Add(2 * time.Hour),
+			want: []models.ArchiveGap{{ServerID: "kiosk-2", MinTime: at.Add(time.Hour), MaxTime: at.Add(time.Hour), Payloads: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := g.overlapping(context.Background(), tt.serverIDs, tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) || (len(got) == 1 && got[0] != tt.want[0]) {
+				t.Errorf("overlapping = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Without a database the gaps stay pending.
+	if err := g.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.pending) != 2 {
+		t.Errorf("%d pending gaps after Flush without a repository, want 2", len(g.pending))
+	}
+}
+
+// TestArchivePayloadsRecordsDrops fills the archive queue and checks that the
+// payload that did not fit becomes a gap.
+func TestArchivePayloadsRecordsDrops(t *testing.T) {
+	archive := make(chan models.RawPayload, 1)
+	h := NewMetricsHandler(nil, make(chan models.SeriesPoint, 10), Config{Archive: archive})
+
+	hosts := []ingest.HostGroup{
+		{ServerID: "kiosk-1", Metrics: []models.Metric{{Name: "cpu", Timestamp: 1760000000}}},
+		{ServerID: "kiosk-2", Metrics: []models.Metric{{Name: "cpu", Timestamp: 1760000010}}},
+	}
+	h.archivePayloads(hosts, [][]byte{[]byte(`{}`), []byte(`{}`)}, time.Now())
+
+	if len(archive) != 1 {
+		t.Fatalf("archive queue holds %d payloads, want 1", len(archive))
+	}
+	gap, ok := h.archiveGaps.pending["kiosk-2"]
+	if !ok || gap.Payloads != 1 || gap.MinTime.Unix() != 1760000010 {
+		t.Errorf("pending gaps = %+v, want one payload of kiosk-2", h.archiveGaps.pending)
+	}
+}
+
+func TestReprocessRefusesWithArchiveOff(t *testing.T) {
+	h := NewMetricsHandler(nil, make(chan models.SeriesPoint, 1), Config{})
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/reprocess",
+		strings.NewReader(`{"from":"2026-10-15T00:00:00Z","to":"2026-10-16T00:00:00Z"}`))
+	rec := httptest.NewRecorder()
+	h.AdminReprocess(rec, req)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "archive is off") {
+		t.Errorf("status %d, body %s; want 409 archive is off", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"metrics-api/internal/models"
+	"metrics-api/internal/repository"
+)
+
+// Ingest authentication modes, selected with INGEST_AUTH_MODE.
+const (
+	// AuthModeOff accepts every ingest request (the historical behaviour).
+	AuthModeOff = "off"
+	// AuthModeOptional checks a bearer token or signature when one is sent
+	// and lets anonymous requests through, for rolling auth out kiosk by kiosk.
+	AuthModeOptional = "optional"
+	// AuthModeToken requires a valid bearer token on every ingest request.
+	AuthModeToken = "token"
+	// AuthModeHMAC requires a valid X-Signature on every ingest request.
+	AuthModeHMAC = "hmac"
+	// AuthModeAny requires either a bearer token or a signature.
+	AuthModeAny = "any"
+)
+
+// ValidAuthMode reports whether mode is one of the AuthMode constants.
+func ValidAuthMode(mode string) bool {
+	switch mode {
+	case AuthModeOff, AuthModeOptional, AuthModeToken, AuthModeHMAC, AuthModeAny:
+		return true
+	}
+	return false
+}
+
+// credentialStore looks up the kiosk bound to ingest credentials; the
+// repository implements it.
+type credentialStore interface {
+	LookupIngestToken(ctx context.Context, tokenHash string) (string, error)
+	IngestSecret(ctx context.Context, serverID string) (string, error)
+}
+
+type contextKey int
+
+const ingestIdentityKey contextKey = iota
+
+// errIdentityMismatch is returned when a payload names a different kiosk than
+// the one its credentials were issued to.
+var errIdentityMismatch = errors.New("server_id does not match the authenticated kiosk")
+
+func withIngestIdentity(ctx context.Context, serverID string) context.Context {
+	return context.WithValue(ctx, ingestIdentityKey, serverID)
+}
+
+// ingestIdentity returns the server_id bound to the request's credentials,
+// if the request was authenticated.
+func ingestIdentity(ctx context.Context) (string, bool) {
+	serverID, ok := ctx.Value(ingestIdentityKey).(string)
+	return serverID, ok && serverID != ""
+}
+
+// GenerateIngestToken returns a new random token (64 hex characters). It is
+// also used for HMAC signing secrets.
+func GenerateIngestToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashIngestToken is the form tokens are stored and looked up in.
+func hashIngestToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// IngestAuth authenticates ingest requests according to the configured mode
+// and stores the kiosk identity bound to the token or secret in the request
+// context. Signatures are verified before the handler reads the body, so
+// signed bodies are limited to the ingest body limit.
+func (h *MetricsHandler) IngestAuth(next http.HandlerFunc) http.HandlerFunc {
+	return h.ingestAuth(next, h.maxBodyBytes)
+}
+
+// ImportAuth is IngestAuth for /api/metrics/import: signed uploads may be as
+// large as the import limit and are buffered to a temporary file while the
+// signature is checked.
+func (h *MetricsHandler) ImportAuth(next http.HandlerFunc) http.HandlerFunc {
+	return h.ingestAuth(next, h.importMaxBytes)
+}
+
+func (h *MetricsHandler) ingestAuth(next http.HandlerFunc, signedLimit int64) http.HandlerFunc {
+	if h.authMode == AuthModeOff {
+		return next
+	}
+	allowToken := h.authMode != AuthModeHMAC
+	allowHMAC := h.authMode != AuthModeToken
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			serverID string
+			status   int
+			err      error
+		)
+		switch {
+		case allowHMAC && r.Header.Get(headerSignature) != "":
+			serverID, status, err = h.verifySignature(r, signedLimit)
+		case allowToken && bearerToken(r) != "":
+			serverID, status, err = h.verifyToken(r)
+		case h.authMode == AuthModeOptional:
+			next(w, r)
+			return
+		default:
+			if allowToken {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="ingest"`)
+			}
+			WriteJSONError(w, http.StatusUnauthorized, "missing credentials")
+			return
+		}
+
+		if err != nil {
+			if status == http.StatusInternalServerError {
+				log.Printf("ingest auth: %v", err)
+				WriteJSONError(w, status, "credential lookup failed")
+				return
+			}
+			WriteJSONError(w, status, err.Error())
+			return
+		}
+
+		// A signed body may be spooled to a temporary file; make sure it
+		// is removed even if the handler never closes it.
+		defer r.Body.Close()
+		next(w, r.WithContext(withIngestIdentity(r.Context(), serverID)))
+	}
+}
+
+func (h *MetricsHandler) verifyToken(r *http.Request) (string, int, error) {
+	serverID, err := h.credentials.LookupIngestToken(r.Context(), hashIngestToken(bearerToken(r)))
+	if errors.Is(err, repository.ErrTokenNotFound) {
+		return "", http.StatusUnauthorized, errors.New("invalid or revoked token")
+	}
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	return serverID, http.StatusOK, nil
+}
+
+// AdminAuth guards the admin endpoints with a static bearer token.
+func AdminAuth(adminToken string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token := bearerToken(r)
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				WriteJSONError(w, http.StatusUnauthorized, "admin token required")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// bindMetricsIdentity checks every metric against the authenticated kiosk and
+// tags metrics that carry no identity with its server_id. Unauthenticated
+// requests pass through unchanged.
+func bindMetricsIdentity(ctx context.Context, metrics []models.Metric) error {
+	bound, ok := ingestIdentity(ctx)
+	if !ok {
+		return nil
+	}
+	for i := range metrics {
+		m := &metrics[i]
+		serverID := m.Tags["server_id"]
+		if serverID == "" || serverID == "$HOSTNAME" {
+			serverID = m.Tags["host"]
+		}
+		if serverID == "" {
+			if m.Tags == nil {
+				m.Tags = make(map[string]string)
+			}
+			m.Tags["server_id"] = bound
+			continue
+		}
+		if serverID != bound {
+			return fmt.Errorf("%w: got %q", errIdentityMismatch, serverID)
+		}
+	}
+	return nil
+}
+
+// bindPointsIdentity is bindMetricsIdentity for already-built series points.
+func bindPointsIdentity(ctx context.Context, points []models.SeriesPoint) error {
+	bound, ok := ingestIdentity(ctx)
+	if !ok {
+		return nil
+	}
+	for i := range points {
+		if points[i].ServerID == "" {
+			points[i].ServerID = bound
+			continue
+		}
+		if points[i].ServerID != bound {
+			return fmt.Errorf("%w: got %q", errIdentityMismatch, points[i].ServerID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"metrics-api/internal/models"
+	"metrics-api/internal/repository"
+)
+
+// stubCredentials stands in for the repository's token and secret lookups.
+type stubCredentials struct {
+	// tokens maps a plaintext token to its kiosk; revoked tokens stay valid
+	// until their expiry, like a rotated token during its grace period.
+	tokens  map[string]stubToken
+	secrets map[string]string
+}
+
+type stubToken struct {
+	serverID  string
+	revokedAt time.Time
+}
+
+func (s *stubCredentials) LookupIngestToken(ctx context.Context, tokenHash string) (string, error) {
+	for token, t := range s.tokens {
+		if hashIngestToken(token) == tokenHash && (t.revokedAt.IsZero() || time.Now().Before(t.revokedAt)) {
+			return t.serverID, nil
+		}
+	}
+	return "", repository.ErrTokenNotFound
+}
+
+func (s *stubCredentials) IngestSecret(ctx context.Context, serverID string) (string, error) {
+	if secret, ok := s.secrets[serverID]; ok {
+		return secret, nil
+	}
+	return "", repository.ErrSecretNotFound
+}
+
+func newAuthHandler(mode string, creds *stubCredentials) *MetricsHandler {
+	h := NewMetricsHandler(nil, make(chan models.SeriesPoint, 100), Config{
+		IngestAuthMode: mode,
+		Summaries:      make(chan models.CleanMetric, 10),
+	})
+	h.credentials = creds
+	return h
+}
+
+// identityRecorder is an ingest handler that records the identity IngestAuth
+// bound to the request.
+func identityRecorder(got *string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*got, _ = ingestIdentity(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestIngestAuthModes(t *testing.T) {
+	creds := &stubCredentials{tokens: map[string]stubToken{"token-a": {serverID: "kiosk-a"}}}
+
+	tests := []struct {
+		mode       string
+		token      string
+		wantStatus int
+		wantID     string
+	}{
+		{mode: AuthModeOff, wantStatus: http.StatusNoContent},
+		// Off does not even look at credentials.
+		{mode: AuthModeOff, token: "bogus", wantStatus: http.StatusNoContent},
+		{mode: AuthModeOptional, wantStatus: http.StatusNoContent},
+		{mode: AuthModeOptional, token: "token-a", wantStatus: http.StatusNoContent, wantID: "kiosk-a"},
+		// A token that is sent must be valid, even when optional.
+		{mode: AuthModeOptional, token: "bogus", wantStatus: http.StatusUnauthorized},
+		{mode: AuthModeToken, wantStatus: http.StatusUnauthorized},
+		{mode: AuthModeToken, token: "token-a", wantStatus: http.StatusNoContent, wantID: "kiosk-a"},
+		{mode: AuthModeToken, token: "bogus", wantStatus: http.StatusUnauthorized},
+		{mode: AuthModeAny, wantStatus: http.StatusUnauthorized},
+		{mode: AuthModeAny, token: "token-a", wantStatus: http.StatusNoContent, wantID: "kiosk-a"},
+		// hmac ignores bearer tokens.
+		{mode: AuthModeHMAC, token: "token-a", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+"/"+tt.token, func(t *testing.T) {
+			h := newAuthHandler(tt.mode, creds)
+			var gotID string
+			r := httptest.NewRequest(http.MethodPost, "/api/metrics", strings.NewReader(`{}`))
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.IngestAuth(identityRecorder(&gotID))(rec, r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if gotID != tt.wantID {
+				t.Errorf("identity = %q, want %q", gotID, tt.wantID)
+			}
+			if rec.Code == http.StatusUnauthorized && tt.mode != AuthModeHMAC && tt.token == "" && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate on a 401 without credentials")
+			}
+		})
+	}
+}
+
+// TestIngestAuthTokenRotation checks that a rotated token keeps working
+// during its grace period, next to the new one, and not after it.
+func TestIngestAuthTokenRotation(t *testing.T) {
+	creds := &stubCredentials{tokens: map[string]stubToken{
+		"old": {serverID: "kiosk-a", revokedAt: time.Now().Add(time.Hour)},
+		"new": {serverID: "kiosk-a"},
+	}}
+	h := newAuthHandler(AuthModeToken, creds)
+
+	try := func(token string) int {
+		var gotID string
+		r := httptest.NewRequest(http.MethodPost, "/api/metrics", strings.NewReader(`{}`))
+		r.Header.Set("Authorization", "bearer "+token)
+		rec := httptest.NewRecorder()
+		h.IngestAuth(identityRecorder(&gotID))(rec, r)
+		return rec.Code
+	}
+	if got := try("old"); got != http.StatusNoContent {
+		t.Errorf("old token within grace: status %d, want 204", got)
+	}
+	if got := try("new"); got != http.StatusNoContent {
+		t.Errorf("new token: status %d, want 204", got)
+	}
+	creds.tokens["old"] = stubToken{serverID: "kiosk-a", revokedAt: time.Now().Add(-time.Second)}
+	if got := try("old"); got != http.StatusUnauthorized {
+		t.Errorf("old token after grace: status %d, want 401", got)
+	}
+}
+
+// TestIngestIdentityBinding sends metrics with a token issued to kiosk-a.
+func TestIngestIdentityBinding(t *testing.T) {
+	creds := &stubCredentials{tokens: map[string]stubToken{"token-a": {serverID: "kiosk-a"}}}
+
+	tests := []struct {
+		name       string
+		tags       string
+		wantStatus int
+	}{
+		{"own server_id", `{"server_id":"kiosk-a","host":"kiosk-a"}`, http.StatusOK},
+		{"own host", `{"host":"kiosk-a"}`, http.StatusOK},
+		{"no identity is bound to the token", `{"interface":"eth0"}`, http.StatusOK},
+		{"other server_id", `{"server_id":"kiosk-b"}`, http.StatusForbidden},
+		{"other host", `{"host":"kiosk-b"}`, http.StatusForbidden},
+		{"own host, other server_id", `{"server_id":"kiosk-b","host":"kiosk-a"}`, http.StatusForbidden},
+		{"placeholder server_id, other host", `{"server_id":"$HOSTNAME","host":"kiosk-b"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newAuthHandler(AuthModeToken, creds)
+			body := `{"metrics":[{"name":"net","tags":` + tt.tags + `,"fields":{"bytes_recv":1},"timestamp":` +
+				strconv.FormatInt(time.Now().Unix(), 10) + `}]}`
+			r := httptest.NewRequest(http.MethodPost, "/api/metrics", strings.NewReader(body))
+			r.Header.Set("Authorization", "Bearer token-a")
+			rec := httptest.NewRecorder()
+			h.IngestAuth(h.Ingest)(rec, r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				if n := len(h.metricPoints); n != 0 {
+					t.Errorf("%d points queued for a rejected request", n)
+				}
+				return
+			}
+			for len(h.metricPoints) > 0 {
+				if p := <-h.metricPoints; p.ServerID != "kiosk-a" {
+					t.Errorf("point stored for %q, want kiosk-a", p.ServerID)
+				}
+			}
+		})
+	}
+
+	// Points of remote_write and OTLP are bound the same way.
+	ctx := withIngestIdentity(context.Background(), "kiosk-a")
+	if err := bindPointsIdentity(ctx, []models.SeriesPoint{{ServerID: "kiosk-b"}}); err == nil {
+		t.Error("bindPointsIdentity accepted a point of kiosk-b")
+	}
+	points := []models.SeriesPoint{{}}
+	if err := bindPointsIdentity(ctx, points); err != nil || points[0].ServerID != "kiosk-a" {
+		t.Errorf("bindPointsIdentity = %v, server_id %q; want kiosk-a", err, points[0].ServerID)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	tests := []struct {
+		header     string
+		wantStatus int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic admin-secret", http.StatusUnauthorized},
+		{"Bearer admin-secret", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		var called bool
+		handler := AdminAuth("admin-secret")(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			w.WriteHeader(http.StatusNoContent)
+		})
+		r := httptest.NewRequest(http.MethodGet, "/api/admin/tokens", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		if rec.Code != tt.wantStatus || called != (tt.wantStatus == http.StatusNoContent) {
+			t.Errorf("Authorization %q: status %d, handler called %v; want %d", tt.header, rec.Code, called, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	defaultMaxBodyBytes = 32 << 20
+	// maxZstdWindow bounds the decoder window a frame header may request;
+	// 8 MiB covers the levels Telegraf and common clients use.
+	maxZstdWindow = 8 << 20
+)
+
+var (
+	errBodyTooLarge        = errors.New("request body too large")
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// requestBody returns r.Body decoded according to Content-Encoding (gzip,
+// deflate, zstd or identity). At most limit bytes are read after
+// decompression, so a small compressed body cannot expand without bound.
+func requestBody(r *http.Request, limit int64) (io.ReadCloser, error) {
+	if limit <= 0 {
+		limit = defaultMaxBodyBytes
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	raw := r.Body
+
+	var decoded io.Reader
+	closeFn := func() error { return nil }
+	switch encoding {
+	case "", "identity":
+		decoded = raw
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(raw)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		decoded = zr
+		closeFn = zr.Close
+	case "deflate":
+		// HTTP "deflate" is zlib-wrapped, but some clients send a raw
+		// deflate stream, so sniff the zlib header before choosing.
+		br := bufio.NewReader(raw)
+		if hdr, err := br.Peek(2); err == nil && isZlibHeader(hdr) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("deflate: %w", err)
+			}
+			decoded = zr
+			closeFn = zr.Close
+		} else {
+			fr := flate.NewReader(br)
+			decoded = fr
+			closeFn = fr.Close
+		}
+	case "zstd":
+		zr, err := zstd.NewReader(raw, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxZstdWindow))
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		decoded = zr
+		closeFn = func() error {
+			zr.Close()
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, encoding)
+	}
+
+	return &limitedBody{
+		r:       decoded,
+		remain:  limit,
+		closeFn: closeFn,
+		raw:     raw,
+	}, nil
+}
+
+func isZlibHeader(hdr []byte) bool {
+	return hdr[0]&0x0f == 8 && (uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0
+}
+
+// limitedBody fails with errBodyTooLarge instead of silently truncating once
+// more than the configured number of decoded bytes has been read.
+type limitedBody struct {
+	r       io.Reader
+	remain  int64
+	closeFn func() error
+	raw     io.Closer
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remain < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.remain+1 {
+		p = p[:b.remain+1]
+	}
+	n, err := b.r.Read(p)
+	b.remain -= int64(n)
+	if b.remain < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	err := b.closeFn()
+	if cerr := b.raw.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// drainBody reads what is left of a decoded body after a JSON value, so the
+// size cap and a compressed stream's checksum are checked even though the
+// decoder stopped at the end of the value.
+func drainBody(body io.Reader) error {
+	_, err := io.Copy(io.Discard, body)
+	return err
+}
+
+// bodyErrorStatus maps a body read/decode error onto an HTTP status code.
+func bodyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errUnsupportedEncoding):
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"metrics-api/internal/models"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	case "flate":
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = fw
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = zw
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readBody decodes body with requestBody and returns what was read and the
+// status a handler would answer on failure (0 on success).
+func readBody(encoding string, body []byte, limit int64) ([]byte, int) {
+	r := httptest.NewRequest(http.MethodPost, "/api/metrics", bytes.NewReader(body))
+	if encoding != "" {
+		r.Header.Set("Content-Encoding", encoding)
+	}
+	rc, err := requestBody(r, limit)
+	if err != nil {
+		return nil, bodyErrorStatus(err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		return got, bodyErrorStatus(err)
+	}
+	return got, 0
+}
+
+func TestRequestBody(t *testing.T) {
+	payload := []byte(`{"metrics":[{"name":"cpu","tags":{"host":"kiosk-1"},"fields":{"usage_idle":90},"timestamp":1760000000}]}`)
+	const limit = 1024
+	// A body that decodes to just over the limit: zeros compress to a few
+	// bytes, like a decompression bomb.
+	bomb := make([]byte, limit+1)
+	corruptGzip := compress(t, "gzip", payload)
+	corruptGzip[len(corruptGzip)/2] ^= 0xff
+
+	tests := []struct {
+		name       string
+		encoding   string
+		body       []byte
+		want       []byte
+		wantStatus int
+	}{
+		{name: "identity", body: payload, want: payload},
+		{name: "identity header", encoding: "identity", body: payload, want: payload},
+		{name: "gzip", encoding: "gzip", body: compress(t, "gzip", payload), want: payload},
+		{name: "x-gzip", encoding: "x-gzip", body: compress(t, "gzip", payload), want: payload},
+		{name: "mixed case", encoding: " GZip ", body: compress(t, "gzip", payload), want: payload},
+		{name: "deflate zlib", encoding: "deflate", body: compress(t, "zlib", payload), want: payload},
+		{name: "deflate raw", encoding: "deflate", body: compress(t, "flate", payload), want: payload},
+		{name: "zstd", encoding: "zstd", body: compress(t, "zstd", payload), want: payload},
+		{name: "at the limit", body: bomb[:limit], want: bomb[:limit]},
+		{name: "identity over the limit", body: bomb, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "gzip bomb", encoding: "gzip", body: compress(t, "gzip", bomb), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "deflate bomb", encoding: "deflate", body: compress(t, "zlib", bomb), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "zstd bomb", encoding: "zstd", body: compress(t, "zstd", bomb), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "unknown encoding", encoding: "br", body: payload, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "gzip bad header", encoding: "gzip", body: payload, wantStatus: http.StatusBadRequest},
+		{name: "gzip corrupt stream", encoding: "gzip", body: corruptGzip, wantStatus: http.StatusBadRequest},
+		{name: "gzip truncated", encoding: "gzip", body: compress(t, "gzip", payload)[:20], wantStatus: http.StatusBadRequest},
+		{name: "deflate corrupt stream", encoding: "deflate", body: []byte{0xff, 0xff, 0xff, 0xff}, wantStatus: http.StatusBadRequest},
+		{name: "zstd corrupt stream", encoding: "zstd", body: payload, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, status := readBody(tt.encoding, tt.body, limit)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if tt.wantStatus == 0 && !bytes.Equal(got, tt.want) {
+				t.Errorf("decoded %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIngestBodyErrors checks the status the ingest endpoint answers for
+// bodies that cannot be decoded.
+func TestIngestBodyErrors(t *testing.T) {
+	payload := []byte(`{"metrics":[]}`)
+	corrupt := compress(t, "gzip", payload)
+	corrupt[len(corrupt)-5] ^= 0xff // CRC
+
+	tests := []struct {
+		name       string
+		encoding   string
+		body       []byte
+		wantStatus int
+	}{
+		// Valid JSON followed by padding that decodes past the limit.
+		{"bomb", "gzip", compress(t, "gzip", append([]byte(`{"metrics":[]}`), bytes.Repeat([]byte(" "), 4096)...)), http.StatusRequestEntityTooLarge},
+		{"unknown encoding", "compress", payload, http.StatusUnsupportedMediaType},
+		{"corrupt gzip", "gzip", corrupt, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewMetricsHandler(nil, make(chan models.SeriesPoint, 1), Config{MaxBodyBytes: 1024})
+			r := httptest.NewRequest(http.MethodPost, "/api/metrics", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Content-Encoding", tt.encoding)
+			rec := httptest.NewRecorder()
+			h.Ingest(rec, r)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
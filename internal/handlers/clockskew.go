@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// Clock-skew policies for payloads whose timestamps are too far ahead of the
+// receive time.
+const (
+	// ClockSkewAccept stores the timestamps as sent and only tracks the skew.
+	ClockSkewAccept = "accept"
+	// ClockSkewClamp shifts the payload's timestamps so the newest one equals
+	// the receive time, keeping the spacing between intervals.
+	ClockSkewClamp = "clamp"
+	// ClockSkewReject drops the kiosk's metrics and reports it in the
+	// response; a request whose every kiosk is rejected gets 422.
+	ClockSkewReject = "reject"
+)
+
+const defaultClockSkewThreshold = 5 * time.Minute
+
+// ValidClockSkewPolicy reports whether policy is one of the ClockSkew
+// constants.
+func ValidClockSkewPolicy(policy string) bool {
+	switch policy {
+	case ClockSkewAccept, ClockSkewClamp, ClockSkewReject:
+		return true
+	}
+	return false
+}
+
+// ServerClockSkew is the clock state of one kiosk as seen by ingest. Skew is
+// how far the newest timestamp of a payload is ahead of its receive time; lag
+// is how far it is behind. Only skew is acted upon: a kiosk flushing a backlog
+// after an outage sends old timestamps with a correct clock, so lag cannot
+// tell a slow clock from buffered data.
+type ServerClockSkew struct {
+	ServerID         string    `json:"server_id"`
+	SkewSeconds      float64   `json:"skew_seconds"`
+	LagSeconds       float64   `json:"lag_seconds"`
+	MaxSkewSeconds   float64   `json:"max_skew_seconds"`
+	Requests         int64     `json:"requests"`
+	SkewedRequests   int64     `json:"skewed_requests"`
+	ClampedRequests  int64     `json:"clamped_requests"`
+	RejectedRequests int64     `json:"rejected_requests"`
+	LastSeen         time.Time `json:"last_seen"`
+}
+
+type clockSkewTracker struct {
+	mu      sync.Mutex
+	servers map[string]*ServerClockSkew
+}
+
+func newClockSkewTracker() *clockSkewTracker {
+	return &clockSkewTracker{servers: make(map[string]*ServerClockSkew)}
+}
+
+func (t *clockSkewTracker) observe(serverID string, skew time.Duration, skewed bool, action string, now time.Time) {
+	if serverID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.servers[serverID]
+	if !ok {
+		s = &ServerClockSkew{ServerID: serverID}
+		t.servers[serverID] = s
+	}
+	s.SkewSeconds = math.Max(skew.Seconds(), 0)
+	s.LagSeconds = math.Max(-skew.Seconds(), 0)
+	if s.SkewSeconds > s.MaxSkewSeconds {
+		s.MaxSkewSeconds = s.SkewSeconds
+	}
+	s.Requests++
+	if skewed {
+		s.SkewedRequests++
+		switch action {
+		case ClockSkewClamp:
+			s.ClampedRequests++
+		case ClockSkewReject:
+			s.RejectedRequests++
+		}
+	}
+	s.LastSeen = now
+}
+
+// list returns the kiosks whose latest skew is at least minSkew, largest
+// skew first.
+func (t *clockSkewTracker) list(minSkew time.Duration) []ServerClockSkew {
+	t.mu.Lock()
+	out := make([]ServerClockSkew, 0, len(t.servers))
+	for _, s := range t.servers {
+		if s.SkewSeconds > 0 && s.SkewSeconds >= minSkew.Seconds() {
+			out = append(out, *s)
+		}
+	}
+	t.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].SkewSeconds != out[j].SkewSeconds {
+			return out[i].SkewSeconds > out[j].SkewSeconds
+		}
+		return out[i].ServerID < out[j].ServerID
+	})
+	return out
+}
+
+// payloadSkew returns the newest timestamp of metrics minus receivedAt; it is
+// negative for a payload that lags behind. ok is false when no metric carries
+// a timestamp.
+func payloadSkew(metrics []models.Metric, receivedAt time.Time) (time.Duration, bool) {
+	newest := 0.0
+	for _, m := range metrics {
+		if m.Timestamp > newest {
+			newest = m.Timestamp
+		}
+	}
+	if newest == 0 {
+		return 0, false
+	}
+	recv := float64(receivedAt.UnixNano()) / float64(time.Second)
+	return time.Duration((newest - recv) * float64(time.Second)), true
+}
+
+// clockSkewed reports whether a payload skew counts as clock skew: timestamps
+// more than the threshold in the future. Old timestamps are lag, not skew.
+func (h *MetricsHandler) clockSkewed(skew time.Duration) bool {
+	return skew > h.clockSkewThreshold
+}
+
+// checkClockSkew applies the clock-skew policy to one kiosk's metrics,
+// shifting their timestamps in place when clamping. It returns the skew in
+// seconds when it exceeds the threshold (0 otherwise) and false when the
+// kiosk's metrics must be rejected.
+func (h *MetricsHandler) checkClockSkew(serverID string, metrics []models.Metric, receivedAt time.Time) (float64, bool) {
+	skew, ok := payloadSkew(metrics, receivedAt)
+	if !ok {
+		return 0, true
+	}
+	h.clockSkew.observe(serverID, skew, h.clockSkewed(skew), h.clockSkewPolicy, receivedAt)
+	return h.applyClockSkew(metrics, skew)
+}
+
+// applyClockSkew is checkClockSkew for a payload whose skew is already known,
+// without recording it; imports use it so backfilled timestamps don't show
+// up as the kiosk's current lag.
+func (h *MetricsHandler) applyClockSkew(metrics []models.Metric, skew time.Duration) (float64, bool) {
+	if !h.clockSkewed(skew) {
+		return 0, true
+	}
+
+	switch h.clockSkewPolicy {
+	case ClockSkewReject:
+		return skew.Seconds(), false
+	case ClockSkewClamp:
+		shift := skew.Seconds()
+		for i := range metrics {
+			if metrics[i].Timestamp > 0 {
+				metrics[i].Timestamp -= shift
+			}
+		}
+	}
+	return skew.Seconds(), true
+}
+
+// ClockSkew lists kiosks whose latest payload was at least min_seconds
+// (default: the skew threshold) ahead of the receive time.
+func (h *MetricsHandler) ClockSkew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	p, err := parsePaginationParams(r, defaultPageSize, maxPageSize)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "invalid pagination parameters")
+		return
+	}
+	minSkew := h.clockSkewThreshold
+	if v := r.URL.Query().Get("min_seconds"); v != "" {
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil || secs < 0 {
+			WriteJSONError(w, http.StatusBadRequest, "invalid min_seconds")
+			return
+		}
+		minSkew = time.Duration(secs * float64(time.Second))
+	}
+
+	servers := h.clockSkew.list(minSkew)
+	if p.offset > len(servers) {
+		p.offset = len(servers)
+	}
+	end := p.offset + p.limit
+	hasMore := end < len(servers)
+	if !hasMore {
+		end = len(servers)
+	}
+	writePaginatedResponse(w, http.StatusOK, servers[p.offset:end], p.page, p.pageSize, hasMore)
+}
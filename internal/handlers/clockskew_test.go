@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+func TestCheckClockSkew(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	unix := float64(now.Unix())
+
+	tests := []struct {
+		name       string
+		policy     string
+		timestamps []float64
+		wantOK     bool
+		wantSkew   float64
+		wantTimes  []float64
+	}{
+		{"current", ClockSkewClamp, []float64{unix - 10, unix}, true, 0, []float64{unix - 10, unix}},
+		{"backlog is lag, clamp leaves it", ClockSkewClamp, []float64{unix - 7200, unix - 7190}, true, 0, []float64{unix - 7200, unix - 7190}},
+		{"backlog is lag, reject keeps it", ClockSkewReject, []float64{unix - 86400}, true, 0, []float64{unix - 86400}},
+		{"ahead is clamped", ClockSkewClamp, []float64{unix + 3590, unix + 3600}, true, 3600, []float64{unix - 10, unix}},
+		{"ahead is rejected", ClockSkewReject, []float64{unix + 3600}, false, 3600, []float64{unix + 3600}},
+		{"ahead is accepted", ClockSkewAccept, []float64{unix + 3600}, true, 3600, []float64{unix + 3600}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewMetricsHandler(nil, nil, Config{ClockSkewPolicy: tc.policy})
+			metrics := make([]models.Metric, len(tc.timestamps))
+			for i, ts := range tc.timestamps {
+				metrics[i] = models.Metric{Name: "cpu", Timestamp: ts}
+			}
+			skew, ok := h.checkClockSkew("kiosk-1", metrics, now)
+			if ok != tc.wantOK || skew != tc.wantSkew {
+				t.Fatalf("checkClockSkew = (%v, %v), want (%v, %v)", skew, ok, tc.wantSkew, tc.wantOK)
+			}
+			for i, m := range metrics {
+				if m.Timestamp != tc.wantTimes[i] {
+					t.Errorf("timestamp %d = %v, want %v", i, m.Timestamp, tc.wantTimes[i])
+				}
+			}
+		})
+	}
+}
+
+// TestIngestRejectsSkewedHostOnly posts a relayed payload in which one kiosk's
+// clock runs an hour ahead; under reject only that kiosk is dropped.
+func TestIngestRejectsSkewedHostOnly(t *testing.T) {
+	summaries := make(chan models.CleanMetric, 10)
+	h := NewMetricsHandler(nil, make(chan models.SeriesPoint, 100), Config{
+		ClockSkewPolicy: ClockSkewReject,
+		Summaries:       summaries,
+	})
+
+	now := time.Now().Unix()
+	body := fmt.Sprintf(`{"metrics":[
+		{"name":"system","tags":{"server_id":"kiosk-good"},"fields":{"uptime":100},"timestamp":%d},
+		{"name":"system","tags":{"server_id":"kiosk-ahead"},"fields":{"uptime":100},"timestamp":%d}]}`, now, now+3600)
+
+	rec := httptest.NewRecorder()
+	h.Ingest(rec, httptest.NewRequest(http.MethodPost, "/api/metrics", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Hosts []hostResult `json:"hosts"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	errs := map[string]string{}
+	for _, res := range resp.Hosts {
+		errs[res.ServerID] = res.Error
+	}
+	if errs["kiosk-good"] != "" || errs["kiosk-ahead"] == "" {
+		t.Errorf("host errors = %v, want only kiosk-ahead rejected", errs)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("queued summaries = %d, want 1", len(summaries))
+	}
+	if cm := <-summaries; cm.ServerID != "kiosk-good" {
+		t.Errorf("queued summary for %s, want kiosk-good", cm.ServerID)
+	}
+
+	// A request with only skewed kiosks still fails as a whole.
+	body = fmt.Sprintf(`{"metrics":[{"name":"system","tags":{"server_id":"kiosk-ahead"},"fields":{"uptime":1},"timestamp":%d}]}`, now+3600)
+	rec = httptest.NewRecorder()
+	h.Ingest(rec, httptest.NewRequest(http.MethodPost, "/api/metrics", strings.NewReader(body)))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("all-skewed status = %d, want 422", rec.Code)
+	}
+}
+
+// TestImportLineClockSkew checks that imports keep old timestamps but apply
+// the reject policy to future ones, per kiosk.
+func TestImportLineClockSkew(t *testing.T) {
+	h := NewMetricsHandler(nil, nil, Config{ClockSkewPolicy: ClockSkewReject})
+	now := time.Now().UTC()
+	line := fmt.Sprintf(`{"metrics":[
+		{"name":"system","tags":{"server_id":"kiosk-old"},"fields":{"uptime":100},"timestamp":%d},
+		{"name":"system","tags":{"server_id":"kiosk-ahead"},"fields":{"uptime":100},"timestamp":%d}]}`,
+		now.Add(-72*time.Hour).Unix(), now.Add(time.Hour).Unix())
+
+	var chunk importChunk
+	err := h.importLine(httptest.NewRequest(http.MethodPost, "/api/metrics/import", nil), []byte(line), now, &chunk)
+	if err == nil || !strings.Contains(err.Error(), "kiosk-ahead") {
+		t.Fatalf("importLine error = %v, want kiosk-ahead rejected", err)
+	}
+	if len(chunk.summaries) != 1 || chunk.summaries[0].ServerID != "kiosk-old" {
+		t.Fatalf("summaries = %+v, want only kiosk-old", chunk.summaries)
+	}
+	if chunk.payloads != 1 {
+		t.Errorf("payloads = %d, want 1", chunk.payloads)
+	}
+	if got := h.clockSkew.list(0); len(got) != 0 {
+		t.Errorf("import recorded clock skew: %+v", got)
+	}
+}
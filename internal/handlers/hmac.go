@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"metrics-api/internal/repository"
+)
+
+// Headers of an HMAC-signed ingest request. The signature is
+// hex(HMAC-SHA256(secret, timestamp + "\n" + nonce + "\n" + body)) over the
+// body exactly as sent (i.e. still compressed).
+const (
+	headerSignature = "X-Signature"
+	headerTimestamp = "X-Timestamp"
+	headerNonce     = "X-Nonce"
+	headerServerID  = "X-Server-ID"
+
+	defaultHMACMaxSkew = 5 * time.Minute
+	maxNonceLength     = 128
+)
+
+var (
+	errSignatureHeaders = errors.New("X-Signature requires X-Server-ID, X-Timestamp and X-Nonce")
+	errStaleTimestamp   = errors.New("X-Timestamp outside the allowed clock skew")
+	errBadSignature     = errors.New("invalid signature")
+	errReplayedNonce    = errors.New("nonce already used")
+)
+
+// nonceCache remembers the nonces seen within the skew window. It is kept in
+// memory, which is enough for the single API replica we run.
+type nonceCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newNonceCache(ttl time.Duration) *nonceCache {
+	return &nonceCache{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// add records key and reports false if it was already seen.
+func (c *nonceCache) add(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPrune) > c.ttl/4 {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	if exp, ok := c.seen[key]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[key] = now.Add(c.ttl)
+	return true
+}
+
+// verifySignature checks an HMAC-signed request of at most limit bytes and
+// returns the kiosk it was signed for. The body is hashed while it is read
+// into memory, or into a temporary file once it outgrows maxBodyBytes; on
+// success r.Body is replaced with the buffered body so the handler can decode
+// it as usual.
+func (h *MetricsHandler) verifySignature(r *http.Request, limit int64) (string, int, error) {
+	serverID := strings.TrimSpace(r.Header.Get(headerServerID))
+	rawTimestamp := r.Header.Get(headerTimestamp)
+	nonce := r.Header.Get(headerNonce)
+	signature := strings.TrimPrefix(strings.TrimSpace(r.Header.Get(headerSignature)), "sha256=")
+	if serverID == "" || rawTimestamp == "" || nonce == "" || len(nonce) > maxNonceLength {
+		return "", http.StatusUnauthorized, errSignatureHeaders
+	}
+
+	ts, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return "", http.StatusUnauthorized, errStaleTimestamp
+	}
+	now := time.Now()
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew > h.hmacMaxSkew || skew < -h.hmacMaxSkew {
+		return "", http.StatusUnauthorized, errStaleTimestamp
+	}
+
+	want, err := hex.DecodeString(signature)
+	if err != nil {
+		return "", http.StatusUnauthorized, errBadSignature
+	}
+
+	secret, err := h.credentials.IngestSecret(r.Context(), serverID)
+	if errors.Is(err, repository.ErrSecretNotFound) {
+		return "", http.StatusUnauthorized, errBadSignature
+	}
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(rawTimestamp))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(nonce))
+	mac.Write([]byte("\n"))
+
+	body, size, err := bufferBody(io.TeeReader(io.LimitReader(r.Body, limit+1), mac), h.maxBodyBytes)
+	r.Body.Close()
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	if size > limit {
+		body.Close()
+		return "", http.StatusRequestEntityTooLarge, errBodyTooLarge
+	}
+	if !hmac.Equal(mac.Sum(nil), want) {
+		body.Close()
+		return "", http.StatusUnauthorized, errBadSignature
+	}
+
+	// Only remember nonces of valid requests so forged ones can't burn them.
+	if !h.nonces.add(serverID+"\x00"+nonce, now) {
+		body.Close()
+		return "", http.StatusUnauthorized, errReplayedNonce
+	}
+
+	r.Body = body
+	return serverID, http.StatusOK, nil
+}
+
+// bufferBody reads r to the end, in memory up to memLimit bytes and in a
+// temporary file beyond that. The returned body removes the file on Close.
+func bufferBody(r io.Reader, memLimit int64) (io.ReadCloser, int64, error) {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, memLimit+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if n <= memLimit {
+		return io.NopCloser(&buf), n, nil
+	}
+
+	f, err := os.CreateTemp("", "metrics-api-body-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	body := &tempBody{File: f}
+	if _, err := buf.WriteTo(f); err != nil {
+		body.Close()
+		return nil, 0, err
+	}
+	rest, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		body.Close()
+		return nil, 0, err
+	}
+	return body, n + rest, nil
+}
+
+// tempBody is a request body spooled to a temporary file.
+type tempBody struct {
+	*os.File
+	once sync.Once
+}
+
+func (b *tempBody) Close() error {
+	var err error
+	b.once.Do(func() {
+		err = b.File.Close()
+		os.Remove(b.File.Name())
+	})
+	return err
+}
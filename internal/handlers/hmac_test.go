@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBufferBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		memLimit int64
+		wantFile bool
+	}{
+		{name: "in memory", size: 100, memLimit: 1024},
+		{name: "at the memory limit", size: 1024, memLimit: 1024},
+		{name: "spooled to a file", size: 4096, memLimit: 1024, wantFile: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := bytes.Repeat([]byte("x"), tt.size)
+			body, n, err := bufferBody(bytes.NewReader(in), tt.memLimit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(tt.size) {
+				t.Fatalf("size = %d, want %d", n, tt.size)
+			}
+			tmp, isFile := body.(*tempBody)
+			if isFile != tt.wantFile {
+				t.Fatalf("spooled to file = %v, want %v", isFile, tt.wantFile)
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, in) {
+				t.Fatalf("read %d bytes back, want the %d written", len(got), len(in))
+			}
+			body.Close()
+			body.Close()
+			if isFile {
+				if _, err := os.Stat(tmp.Name()); !os.IsNotExist(err) {
+					t.Fatalf("temporary file not removed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func sign(secret, timestamp, nonce, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n" + body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedRequest builds an ingest request signed with secret; tamper, if set,
+// replaces the body after signing.
+func signedRequest(serverID, secret string, ts time.Time, nonce, body, tamper string) *http.Request {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	sig := sign(secret, timestamp, nonce, body)
+	if tamper != "" {
+		body = tamper
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/metrics", strings.NewReader(body))
+	r.Header.Set(headerServerID, serverID)
+	r.Header.Set(headerTimestamp, timestamp)
+	r.Header.Set(headerNonce, nonce)
+	r.Header.Set(headerSignature, "sha256="+sig)
+	return r
+}
+
+func TestVerifySignature(t *testing.T) {
+	const body = `{"metrics":[]}`
+	now := time.Now()
+	creds := &stubCredentials{secrets: map[string]string{"kiosk-a": "secret-a", "kiosk-b": "secret-b"}}
+	h := newAuthHandler(AuthModeHMAC, creds)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		// drop removes a header after signing.
+		drop       string
+		wantStatus int
+		wantErr    string
+	}{
+		{name: "valid", req: signedRequest("kiosk-a", "secret-a", now, "n1", body, ""), wantStatus: http.StatusNoContent},
+		{name: "replayed nonce", req: signedRequest("kiosk-a", "secret-a", now, "n1", body, ""), wantStatus: http.StatusUnauthorized, wantErr: errReplayedNonce.Error()},
+		// Nonces are per kiosk.
+		{name: "same nonce, other kiosk", req: signedRequest("kiosk-b", "secret-b", now, "n1", body, ""), wantStatus: http.StatusNoContent},
+		{name: "tampered body", req: signedRequest("kiosk-a", "secret-a", now, "n2", body, `{"metrics":[{}]}`), wantStatus: http.StatusUnauthorized, wantErr: errBadSignature.Error()},
+		{name: "wrong secret", req: signedRequest("kiosk-a", "secret-b", now, "n3", body, ""), wantStatus: http.StatusUnauthorized, wantErr: errBadSignature.Error()},
+		{name: "unknown server_id", req: signedRequest("kiosk-c", "secret-a", now, "n4", body, ""), wantStatus: http.StatusUnauthorized, wantErr: errBadSignature.Error()},
+		{name: "stale timestamp", req: signedRequest("kiosk-a", "secret-a", now.Add(-defaultHMACMaxSkew-time.Minute), "n5", body, ""), wantStatus: http.StatusUnauthorized, wantErr: errStaleTimestamp.Error()},
+		{name: "future timestamp", req: signedRequest("kiosk-a", "secret-a", now.Add(defaultHMACMaxSkew+time.Minute), "n6", body, ""), wantStatus: http.StatusUnauthorized, wantErr: errStaleTimestamp.Error()},
+		{name: "within the skew", req: signedRequest("kiosk-a", "secret-a", now.Add(-defaultHMACMaxSkew+time.Minute), "n7", body, ""), wantStatus: http.StatusNoContent},
+		// The forged requests above did not burn their nonces.
+		{name: "nonce of a forged request", req: signedRequest("kiosk-a", "secret-a", now, "n2", body, ""), wantStatus: http.StatusNoContent},
+		{name: "missing nonce", req: signedRequest("kiosk-a", "secret-a", now, "n8", body, ""), drop: headerNonce, wantStatus: http.StatusUnauthorized, wantErr: errSignatureHeaders.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.drop != "" {
+				tt.req.Header.Del(tt.drop)
+			}
+			var gotID, gotBody string
+			rec := httptest.NewRecorder()
+			h.IngestAuth(func(w http.ResponseWriter, r *http.Request) {
+				gotID, _ = ingestIdentity(r.Context())
+				b, _ := io.ReadAll(r.Body)
+				gotBody = string(b)
+				w.WriteHeader(http.StatusNoContent)
+			})(rec, tt.req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantErr != "" && !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("body = %s, want %q", rec.Body, tt.wantErr)
+			}
+			if rec.Code == http.StatusNoContent {
+				if gotID != tt.req.Header.Get(headerServerID) {
+					t.Errorf("identity = %q, want %q", gotID, tt.req.Header.Get(headerServerID))
+				}
+				if gotBody != body {
+					t.Errorf("handler read %q, want the signed body", gotBody)
+				}
+			}
+		})
+	}
+}
+
+func TestNonceCacheTTL(t *testing.T) {
+	h := newAuthHandler(AuthModeHMAC, &stubCredentials{})
+	if h.nonces.ttl != 2*h.hmacMaxSkew {
+		t.Fatalf("nonce TTL = %v, want twice the skew (%v)", h.nonces.ttl, 2*h.hmacMaxSkew)
+	}
+
+	c := newNonceCache(10 * time.Minute)
+	start := time.Now()
+	steps := []struct {
+		key   string
+		after time.Duration
+		want  bool
+	}{
+		{"a", 0, true},
+		{"a", time.Minute, false},
+		{"b", time.Minute, true},
+		{"a", 10*time.Minute - time.Second, false},
+		// Expired: accepted again, and the stale entry was pruned.
+		{"a", 10*time.Minute + time.Second, true},
+	}
+	for _, s := range steps {
+		if got := c.add(s.key, start.Add(s.after)); got != s.want {
+			t.Errorf("add(%q) after %v = %v, want %v", s.key, s.after, got, s.want)
+		}
+	}
+	if _, ok := c.seen["b"]; !ok {
+		t.Error("nonce b pruned before it expired")
+	}
+}
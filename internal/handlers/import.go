@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"metrics-api/internal/ingest"
+	"metrics-api/internal/models"
+)
+
+const (
+	defaultImportMaxBytes    = 1 << 30
+	defaultImportConcurrency = 2
+	// importChunkLines and importChunkPoints bound how much an import
+	// buffers before writing; progress is reported after every chunk.
+	importChunkLines  = 200
+	importChunkPoints = 20000
+	// maxImportLineErrors caps the per-line errors kept for the final
+	// report; the error count is still exact.
+	maxImportLineErrors = 100
+)
+
+// importLineError is one NDJSON line that could not be imported.
+type importLineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importProgress is one line of the streamed /api/metrics/import response.
+// The last line has Status set and carries the line errors.
+type importProgress struct {
+	Status     string            `json:"status,omitempty"`
+	Lines      int               `json:"lines"`
+	Payloads   int               `json:"payloads"`
+	Summaries  int               `json:"summaries"`
+	Points     int               `json:"points"`
+	Errors     int               `json:"errors"`
+	LineErrors []importLineError `json:"line_errors,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// importChunk is the parsed output of up to importChunkLines lines.
+type importChunk struct {
+	summaries []models.CleanMetric
+	points    []models.SeriesPoint
+	raw       []models.RawPayload
+	payloads  int
+}
+
+// Import backfills history from an NDJSON stream of Telegraf JSON payloads
+// (one payload per line), optionally gzipped with Content-Encoding or as a
+// .gz file. Lines go through the same parser and clock-skew policy as Ingest
+// (old timestamps are lag, which is the point of an import) but are written to
+// the database in chunks by the request itself. That bypasses the real-time
+// queues, so neither the overflow policy nor the WAL applies: a chunk is only
+// reported once it is committed. The response is NDJSON too: a progress line
+// after every chunk and a final line with "status" and the per-line errors.
+func (h *MetricsHandler) Import(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	select {
+	case h.importSlots <- struct{}{}:
+		defer func() { <-h.importSlots }()
+	default:
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.retryAfter/time.Second)))
+		WriteJSONError(w, http.StatusServiceUnavailable, "too many imports running, retry later")
+		return
+	}
+
+	body, err := requestBody(r, h.importMaxBytes)
+	if err != nil {
+		WriteJSONError(w, bodyErrorStatus(err), err.Error())
+		return
+	}
+	defer body.Close()
+
+	// An uploaded .gz file usually arrives without Content-Encoding.
+	br := bufio.NewReader(body)
+	var in io.Reader = br
+	if magic, err := br.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, "gzip: "+err.Error())
+			return
+		}
+		defer zr.Close()
+		in = zr
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	receivedAt := time.Now().UTC()
+	var progress importProgress
+	var chunk importChunk
+	flush := func() error {
+		if err := h.writeImportChunk(r, &chunk); err != nil {
+			return err
+		}
+		progress.Payloads += chunk.payloads
+		progress.Summaries += len(chunk.summaries)
+		progress.Points += len(chunk.points)
+		chunk = importChunk{}
+		_ = enc.Encode(progress)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+	fail := func(err error) {
+		progress.Status = "failed"
+		progress.Error = err.Error()
+		_ = enc.Encode(progress)
+		log.Printf("import: failed after %d lines: %v", progress.Lines, err)
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), int(h.maxBodyBytes))
+	chunkLines := 0
+	for scanner.Scan() {
+		progress.Lines++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := h.importLine(r, line, receivedAt, &chunk); err != nil {
+			progress.Errors++
+			if len(progress.LineErrors) < maxImportLineErrors {
+				progress.LineErrors = append(progress.LineErrors, importLineError{Line: progress.Lines, Error: err.Error()})
+			}
+			continue
+		}
+		chunkLines++
+		if chunkLines >= importChunkLines || len(chunk.points) >= importChunkPoints {
+			if err := flush(); err != nil {
+				fail(err)
+				return
+			}
+			chunkLines = 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// Lines parsed so far are still written; the client can resume
+		// after the last reported line.
+		if ferr := flush(); ferr != nil {
+			err = ferr
+		}
+		fail(fmt.Errorf("line %d: %w", progress.Lines+1, err))
+		return
+	}
+	if err := flush(); err != nil {
+		fail(err)
+		return
+	}
+
+	progress.Status = "ok"
+	_ = enc.Encode(progress)
+	log.Printf("import: lines=%d payloads=%d summaries=%d points=%d errors=%d", progress.Lines, progress.Payloads, progress.Summaries, progress.Points, progress.Errors)
+}
+
+// importLine parses one NDJSON line into chunk.
+func (h *MetricsHandler) importLine(r *http.Request, line []byte, receivedAt time.Time, chunk *importChunk) error {
+	var payload models.TelegrafPayload
+	if err := decodeTelegrafPayload(bytes.NewReader(line), &payload); err != nil {
+		return err
+	}
+	if len(payload.Metrics) == 0 {
+		return fmt.Errorf("no metrics")
+	}
+	if err := bindMetricsIdentity(r.Context(), payload.Metrics); err != nil {
+		return err
+	}
+
+	var rejected []string
+	hosts := ingest.SplitHosts(payload.Metrics)
+	for _, hg := range hosts {
+		if skew, ok := payloadSkew(hg.Metrics, receivedAt); ok {
+			if secs, ok := h.applyClockSkew(hg.Metrics, skew); !ok {
+				rejected = append(rejected, fmt.Sprintf("clock skew of %.0fs for server_id=%s exceeds %s", secs, hg.ServerID, h.clockSkewThreshold))
+				continue
+			}
+		}
+		if h.archive != nil && hg.ServerID != "" {
+			if b, err := json.Marshal(models.TelegrafPayload{Metrics: hg.Metrics}); err == nil {
+				p := models.RawPayload{ReceivedAt: receivedAt, ServerID: hg.ServerID, Payload: b}
+				p.MinTime, p.MaxTime = metricTimeBounds(hg.Metrics)
+				chunk.raw = append(chunk.raw, p)
+			}
+		}
+		summaries, points := h.buildHost(hg.ServerID, hg.Metrics, receivedAt)
+		setSource(points, models.SourceImport)
+		chunk.summaries = append(chunk.summaries, summaries...)
+		chunk.points = append(chunk.points, points...)
+	}
+	if len(rejected) < len(hosts) {
+		chunk.payloads++
+	}
+	if len(rejected) > 0 {
+		return errors.New(strings.Join(rejected, "; "))
+	}
+	return nil
+}
+
+// writeImportChunk stores a chunk straight to the database; the raw payloads
+// go to the archive in the same pass when archiving is on.
+func (h *MetricsHandler) writeImportChunk(r *http.Request, chunk *importChunk) error {
+	if err := h.repo.SaveMetrics(r.Context(), chunk.summaries); err != nil {
+		return fmt.Errorf("write summaries: %w", err)
+	}
+	if err := h.repo.SaveSeriesPoints(r.Context(), chunk.points); err != nil {
+		return fmt.Errorf("write series points: %w", err)
+	}
+	if err := h.repo.SaveRawPayloads(r.Context(), chunk.raw); err != nil {
+		// The rows are stored already, so the payloads become a gap.
+		h.archiveGaps.Record(chunk.raw, "archive insert failed: "+err.Error())
+		return fmt.Errorf("archive payloads: %w", err)
+	}
+	return nil
+}
+
+// buildHost runs one kiosk's metrics through the registry, one summary per
+// collection interval, stamping rows and points with receivedAt. The points
+// have been through the tag policy.
+func (h *MetricsHandler) buildHost(serverID string, metrics []models.Metric, receivedAt time.Time) ([]models.CleanMetric, []models.SeriesPoint) {
+	var summaries []models.CleanMetric
+	var points []models.SeriesPoint
+	for _, group := range ingest.SplitIntervals(metrics, h.intervalWidth) {
+		batch := h.registry.NewBatch()
+		batch.Summary.ServerID = serverID
+		batch.Summary.ReceivedAt = receivedAt
+		for _, m := range group {
+			batch.Add(m)
+		}
+		batch.Finish()
+
+		summaries = append(summaries, batch.Summary)
+		for _, p := range batch.Points {
+			p.ReceivedAt = receivedAt
+			points = append(points, p)
+		}
+	}
+	return summaries, h.applyTagPolicy(points)
+}
@@ -1,55 +1,240 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"metrics-api/internal/deadletter"
+	"metrics-api/internal/ingest"
+	"metrics-api/internal/lineprotocol"
 	"metrics-api/internal/models"
 	"metrics-api/internal/repository"
+	"metrics-api/internal/wal"
 )
 
 const (
+	defaultIntervalWidth = 10 * time.Second
+
 	defaultPageSize = 25
 	maxPageSize     = 200
 )
 
 type MetricsHandler struct {
-	repo           *repository.MetricsRepository
-	metricPoints   chan models.SeriesPoint
-	debugLoggingOn bool
-	directInsert   bool
-	logPayload     bool
-	debugServerID  string
+	repo            *repository.MetricsRepository
+	metricPoints    chan models.SeriesPoint
+	summaries       chan models.CleanMetric
+	debugLoggingOn  bool
+	directInsert    bool
+	logPayload      bool
+	debugServerID   string
+	maxBodyBytes    int64
+	registry        *ingest.Registry
+	authMode        string
+	credentials     credentialStore
+	hmacMaxSkew     time.Duration
+	nonces          *nonceCache
+	deadLetters     *deadletter.Spool
+	wal             *wal.WAL
+	overflowPolicy  string
+	overflowTimeout time.Duration
+	retryAfter      time.Duration
+	overflow        *overflowCounters
+	intervalWidth   time.Duration
+	// enqueue is held while a block or reject request waits for room in the
+	// series-point and summary queues and queues its rows; see reserveQueue.
+	enqueue chan struct{}
+
+	clockSkewPolicy    string
+	clockSkewThreshold time.Duration
+	clockSkew          *clockSkewTracker
+
+	archive     chan models.RawPayload
+	archiveGaps *ArchiveGaps
+	reprocess   *reprocessJobs
+
+	importMaxBytes int64
+	importSlots    chan struct{}
+
+	tagSets   *tagSetTracker
+	tagPolicy *tagPolicyCounters
 }
 
-func NewMetricsHandler(repo *repository.MetricsRepository, metricPoints chan models.SeriesPoint, debug bool, directInsert bool, logPayload bool, debugServerID string) *MetricsHandler {
-	return &MetricsHandler{
-		repo:           repo,
-		metricPoints:   metricPoints,
-		debugLoggingOn: debug,
-		directInsert:   directInsert,
-		logPayload:     logPayload,
-		debugServerID:  debugServerID,
-	}
+// Config carries the ingest tuning knobs read from the environment in main.
+type Config struct {
+	Debug         bool
+	DirectInsert  bool
+	LogPayload    bool
+	DebugServerID string
+	// MaxBodyBytes caps the decompressed request body size for ingest
+	// endpoints; zero falls back to defaultMaxBodyBytes.
+	MaxBodyBytes int64
+	// Registry holds the per-measurement parsers; nil uses
+	// ingest.NewDefaultRegistry().
+	Registry *ingest.Registry
+	// IngestAuthMode is one of the AuthMode constants; empty means
+	// AuthModeOff.
+	IngestAuthMode string
+	// HMACMaxSkew bounds how far X-Timestamp may drift from the server clock;
+	// zero falls back to defaultHMACMaxSkew.
+	HMACMaxSkew time.Duration
+	// DeadLetter is the spool behind the /api/admin/deadletter endpoints;
+	// nil disables them.
+	DeadLetter *deadletter.Spool
+	// WAL, when set, receives queued points before the request is
+	// acknowledged.
+	WAL *wal.WAL
+	// OverflowPolicy is what happens to points that do not fit in the queue:
+	// OverflowDrop (default), OverflowBlock or OverflowReject.
+	OverflowPolicy string
+	// OverflowTimeout is how long OverflowBlock waits for room.
+	OverflowTimeout time.Duration
+	// RetryAfter is sent with 503 responses when the queue is full.
+	RetryAfter time.Duration
+	// Summaries, when set, queues summary rows for the batched summary
+	// writers instead of upserting them inside the request. DirectInsert
+	// still writes synchronously.
+	Summaries chan models.CleanMetric
+	// IntervalWidth is how far apart timestamps in one payload may be and
+	// still belong to the same collection interval (one summary row).
+	IntervalWidth time.Duration
+	// ClockSkewPolicy is ClockSkewAccept (default), ClockSkewClamp or
+	// ClockSkewReject; it applies when a payload's newest timestamp is more
+	// than ClockSkewThreshold away from the receive time.
+	ClockSkewPolicy    string
+	ClockSkewThreshold time.Duration
+	// Archive, when set, receives each kiosk's raw payload for the archive
+	// writers; nil disables the archive.
+	Archive chan models.RawPayload
+	// ArchiveGaps records the payloads that did not reach the archive; nil
+	// keeps them in a recorder of the handler's own.
+	ArchiveGaps *ArchiveGaps
+	// ImportMaxBytes caps the decoded size of one /api/metrics/import
+	// upload; zero falls back to defaultImportMaxBytes.
+	ImportMaxBytes int64
+	// ImportConcurrency is how many imports may run at once; zero falls back
+	// to defaultImportConcurrency.
+	ImportConcurrency int
+	// TagSetWindow is how long a tag set counts towards the mapping's
+	// max_tag_sets after it was last seen; zero falls back to
+	// defaultTagSetWindow.
+	TagSetWindow time.Duration
+	// TagSeriesPerServer caps the series per server whose tag sets are
+	// tracked for max_tag_sets; zero falls back to defaultTagSeriesPerServer.
+	TagSeriesPerServer int
 }
 
-func deriveServerIdentifiers(metrics []models.Metric) (string, string) {
-	for _, metric := range metrics {
-		serverID := metric.Tags["server_id"]
-		host := metric.Tags["host"]
-		if serverID == "" || serverID == "$HOSTNAME" {
-			serverID = host
-		}
-		if serverID != "" || host != "" {
-			return serverID, host
-		}
+func NewMetricsHandler(repo *repository.MetricsRepository, metricPoints chan models.SeriesPoint, cfg Config) *MetricsHandler {
+	maxBodyBytes := cfg.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = defaultMaxBodyBytes
+	}
+	registry := cfg.Registry
+	if registry == nil {
+		registry = ingest.NewDefaultRegistry()
+	}
+	authMode := cfg.IngestAuthMode
+	if authMode == "" {
+		authMode = AuthModeOff
+	}
+	hmacMaxSkew := cfg.HMACMaxSkew
+	if hmacMaxSkew <= 0 {
+		hmacMaxSkew = defaultHMACMaxSkew
+	}
+	overflowPolicy := cfg.OverflowPolicy
+	if overflowPolicy == "" {
+		overflowPolicy = OverflowDrop
+	}
+	overflowTimeout := cfg.OverflowTimeout
+	if overflowTimeout <= 0 {
+		overflowTimeout = defaultOverflowTimeout
+	}
+	retryAfter := cfg.RetryAfter
+	if retryAfter < time.Second {
+		retryAfter = defaultRetryAfter
+	}
+	clockSkewPolicy := cfg.ClockSkewPolicy
+	if clockSkewPolicy == "" {
+		clockSkewPolicy = ClockSkewAccept
+	}
+	clockSkewThreshold := cfg.ClockSkewThreshold
+	if clockSkewThreshold <= 0 {
+		clockSkewThreshold = defaultClockSkewThreshold
+	}
+	intervalWidth := cfg.IntervalWidth
+	if intervalWidth <= 0 {
+		intervalWidth = defaultIntervalWidth
+	}
+	importMaxBytes := cfg.ImportMaxBytes
+	if importMaxBytes <= 0 {
+		importMaxBytes = defaultImportMaxBytes
+	}
+	importConcurrency := cfg.ImportConcurrency
+	if importConcurrency <= 0 {
+		importConcurrency = defaultImportConcurrency
+	}
+	tagSetWindow := cfg.TagSetWindow
+	if tagSetWindow <= 0 {
+		tagSetWindow = defaultTagSetWindow
+	}
+	archiveGaps := cfg.ArchiveGaps
+	if archiveGaps == nil {
+		archiveGaps = NewArchiveGaps(repo)
 	}
-	return "", ""
+	tagSeriesPerServer := cfg.TagSeriesPerServer
+	if tagSeriesPerServer <= 0 {
+		tagSeriesPerServer = defaultTagSeriesPerServer
+	}
+	h := &MetricsHandler{
+		repo:           repo,
+		metricPoints:   metricPoints,
+		summaries:      cfg.Summaries,
+		debugLoggingOn: cfg.Debug,
+		directInsert:   cfg.DirectInsert,
+		logPayload:     cfg.LogPayload,
+		debugServerID:  cfg.DebugServerID,
+		maxBodyBytes:   maxBodyBytes,
+		registry:       registry,
+		authMode:       authMode,
+		hmacMaxSkew:    hmacMaxSkew,
+		// A nonce must outlive every timestamp that could still pass the
+		// skew check, i.e. up to one skew window either side of now.
+		nonces:      newNonceCache(2 * hmacMaxSkew),
+		deadLetters: cfg.DeadLetter,
+		wal:         cfg.WAL,
+
+		overflowPolicy:  overflowPolicy,
+		overflowTimeout: overflowTimeout,
+		retryAfter:      retryAfter,
+		overflow:        newOverflowCounters(),
+		intervalWidth:   intervalWidth,
+		enqueue:         make(chan struct{}, 1),
+
+		clockSkewPolicy:    clockSkewPolicy,
+		clockSkewThreshold: clockSkewThreshold,
+		clockSkew:          newClockSkewTracker(),
+
+		archive:     cfg.Archive,
+		archiveGaps: archiveGaps,
+		reprocess:   newReprocessJobs(),
+
+		importMaxBytes: importMaxBytes,
+		importSlots:    make(chan struct{}, importConcurrency),
+
+		tagSets:   newTagSetTracker(tagSetWindow, tagSeriesPerServer),
+		tagPolicy: newTagPolicyCounters(),
+	}
+	if repo != nil {
+		h.credentials = repo
+	}
+	return h
 }
 
 func (h *MetricsHandler) Root(w http.ResponseWriter, r *http.Request) {
@@ -59,695 +244,488 @@ func (h *MetricsHandler) Root(w http.ResponseWriter, r *http.Request) {
 func (h *MetricsHandler) Ingest(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	var payload models.TelegrafPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		WriteJSONError(w, http.StatusBadRequest, err.Error())
+	body, err := requestBody(r, h.maxBodyBytes)
+	if err != nil {
+		WriteJSONError(w, bodyErrorStatus(err), err.Error())
 		return
 	}
+	defer body.Close()
 
-	payloadServerID, payloadHost := deriveServerIdentifiers(payload.Metrics)
-
-	if h.logPayload && h.shouldLogForServer(payloadServerID, payloadHost) {
-		if b, err := json.Marshal(payload); err == nil {
-			log.Printf("ingest_payload: %s", string(b))
-		} else {
-			log.Printf("ingest_payload_error: %v", err)
+	var payload models.TelegrafPayload
+	if isLineProtocol(r) {
+		metrics, err := lineprotocol.Parse(body, r.URL.Query().Get("precision"), time.Now())
+		if err != nil {
+			WriteJSONError(w, bodyErrorStatus(err), err.Error())
+			return
 		}
-	}
-
-	var cm models.CleanMetric
-	var points []models.SeriesPoint
-
-	var diskTotalBytes int64
-	var diskUsedBytes int64
-	var diskFreeBytes int64
-	var netBytesSent int64
-	var netBytesRecv int64
-	var temperatureCaptured bool
-	var chassisTemperatureCaptured bool
-	var fanCaptured bool
-	var volumeCaptured bool
-	var hotspotCaptured bool
-	var powerOnlineCaptured bool
-	var batteryCaptured bool
-	var displayCaptured bool
-	var displayBestRank int
-	displayBestRank = -1
-	var dailyVnstatCaptured bool
-	var monthlyVnstatCaptured bool
-	var sawTemperatureMetric bool
-	var sawNetMetric bool
-	var inputDevices []models.InputDevice
-	var inputDevicesHealthy int64
-	var inputDevicesMissing int64
-	var linkState *models.LinkState
-	processStatusMap := make(map[string]models.ProcessStatus)
-	seenDisk := make(map[string]struct{})
-
-	headerLogged := false
-
-	firstNonEmpty := func(values ...string) string {
-		for _, v := range values {
-			if strings.TrimSpace(v) != "" {
-				return v
-			}
+		payload.Metrics = metrics
+	} else {
+		if err := decodeTelegrafPayload(body, &payload); err != nil {
+			WriteJSONError(w, bodyErrorStatus(err), err.Error())
+			return
+		}
+		if err := drainBody(body); err != nil {
+			WriteJSONError(w, bodyErrorStatus(err), err.Error())
+			return
 		}
-		return ""
 	}
 
-	for _, m := range payload.Metrics {
-
-		if cm.ServerID == "" {
-			cm.ServerID = m.Tags["server_id"]
-			if cm.ServerID == "" || cm.ServerID == "$HOSTNAME" {
-				cm.ServerID = m.Tags["host"]
-			}
-		}
+	h.ingestPayload(w, r, payload)
+}
 
-		logThisMetric := h.shouldLogForServer(cm.ServerID, m.Tags["host"])
+// decodeTelegrafPayload decodes Telegraf JSON keeping field values as
+// json.Number, so passthrough series can tell integers from floats.
+func decodeTelegrafPayload(r io.Reader, payload *models.TelegrafPayload) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return dec.Decode(payload)
+}
 
-		if logThisMetric && !headerLogged {
-			log.Printf("ingest: received %d metrics", len(payload.Metrics))
-			headerLogged = true
-		}
+// Write accepts InfluxDB line protocol regardless of the request Content-Type,
+// mirroring the InfluxDB v1 /write endpoint (including ?precision=).
+func (h *MetricsHandler) Write(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
 
-		if logThisMetric {
-			log.Printf("ingest: metric name=%s tags=%v fields=%v", m.Name, m.Tags, keysOf(m.Fields))
-		}
+	body, err := requestBody(r, h.maxBodyBytes)
+	if err != nil {
+		WriteJSONError(w, bodyErrorStatus(err), err.Error())
+		return
+	}
+	defer body.Close()
 
-		if strings.HasPrefix(m.Name, "kiosk_") {
-			if cm.City == "" && m.Tags["city"] != "" {
-				cm.City = m.Tags["city"]
-			}
-			if cm.CityName == "" && m.Tags["city_full_name"] != "" {
-				cm.CityName = m.Tags["city_full_name"]
-			}
-			if cm.Region == "" && m.Tags["code"] != "" {
-				cm.Region = m.Tags["code"]
-			}
-			if cm.RegionName == "" && m.Tags["name"] != "" {
-				cm.RegionName = m.Tags["name"]
-			}
-		}
+	metrics, err := lineprotocol.Parse(body, r.URL.Query().Get("precision"), time.Now())
+	if err != nil {
+		WriteJSONError(w, bodyErrorStatus(err), err.Error())
+		return
+	}
 
-		if cm.Time.IsZero() && m.Timestamp > 0 {
-			cm.Time = time.Unix(int64(m.Timestamp), 0)
-		}
-		ptTime := time.Unix(int64(m.Timestamp), 0)
+	h.ingestPayload(w, r, models.TelegrafPayload{Metrics: metrics})
+}
 
-		switch m.Name {
-		case "cpu":
-			if m.Tags["cpu"] != "cpu-total" {
-				continue
-			}
-			if cm.CPU == 0 {
-				if v, ok := m.Fields["usage_idle"].(float64); ok {
-					cm.CPU = 100 - v
-				}
-			}
+func isLineProtocol(r *http.Request) bool {
+	contentType := strings.ToLower(r.Header.Get("Content-Type"))
+	return strings.HasPrefix(contentType, "text/plain") ||
+		strings.HasPrefix(contentType, "application/x-influxdb-line-protocol")
+}
 
-		case "kiosk_display":
-			isPrimary := false
-			if primaryVal, ok := toInt64(m.Fields["primary"]); ok && primaryVal != 0 {
-				isPrimary = true
-			}
+func (h *MetricsHandler) ingestPayload(w http.ResponseWriter, r *http.Request, payload models.TelegrafPayload) {
+	if err := bindMetricsIdentity(r.Context(), payload.Metrics); err != nil {
+		WriteJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
 
-			rank := 0
-			if connectedVal, ok := toInt64(m.Fields["connected"]); ok && connectedVal != 0 {
-				rank = 1
-				if isPrimary {
-					rank = 3
-				}
-			} else if isPrimary {
-				rank = 2
-			}
+	receivedAt := time.Now().UTC()
+	hosts := ingest.SplitHosts(payload.Metrics)
 
-			if !displayCaptured || rank > displayBestRank {
-				if connectedVal, ok := toInt64(m.Fields["connected"]); ok {
-					cm.DisplayConnected = connectedVal != 0
-				} else {
-					cm.DisplayConnected = false
-				}
-				if widthVal, ok := toInt64(m.Fields["width"]); ok {
-					cm.DisplayWidth = widthVal
-				} else {
-					cm.DisplayWidth = 0
-				}
-				if heightVal, ok := toInt64(m.Fields["height"]); ok {
-					cm.DisplayHeight = heightVal
-				} else {
-					cm.DisplayHeight = 0
-				}
-				if refreshVal, ok := toInt64(m.Fields["refresh_hz"]); ok {
-					cm.DisplayRefreshHz = refreshVal
-				} else {
-					cm.DisplayRefreshHz = 0
-				}
-				if dpmsVal, ok := toInt64(m.Fields["dpms_enabled"]); ok {
-					cm.DisplayDpmsEnabled = dpmsVal != 0
-				} else {
-					cm.DisplayDpmsEnabled = false
-				}
-				cm.DisplayPrimary = isPrimary
-				displayCaptured = true
-				displayBestRank = rank
-			}
+	if h.logPayload && h.shouldLogHosts(hosts) {
+		if b, err := json.Marshal(payload); err == nil {
+			log.Printf("ingest_payload: %s", string(b))
+		} else {
+			log.Printf("ingest_payload_error: %v", err)
+		}
+	}
 
-			if len(m.Fields) > 0 {
-				for fieldName, raw := range m.Fields {
-					if iv, ok := toInt64(raw); ok {
-						val := iv
-						points = append(points, models.SeriesPoint{
-							Time:        ptTime,
-							ServerID:    cm.ServerID,
-							Measurement: "kiosk_display",
-							Field:       fieldName,
-							ValueInt:    &val,
-							TagsJSON:    mustJSON(m.Tags),
-						})
-						continue
-					}
-					if fv, ok := toFloat64(raw); ok {
-						val := fv
-						points = append(points, models.SeriesPoint{
-							Time:        ptTime,
-							ServerID:    cm.ServerID,
-							Measurement: "kiosk_display",
-							Field:       fieldName,
-							ValueDouble: &val,
-							TagsJSON:    mustJSON(m.Tags),
-						})
-					}
-				}
+	// Keep the metrics as received for the archive; clamping below rewrites
+	// their timestamps in place.
+	var archived [][]byte
+	if h.archive != nil {
+		archived = make([][]byte, len(hosts))
+		for i, hg := range hosts {
+			if b, err := json.Marshal(models.TelegrafPayload{Metrics: hg.Metrics}); err == nil {
+				archived[i] = b
 			}
+		}
+	}
 
-		case "kiosk_power":
-			powerType := strings.ToLower(m.Tags["type"])
-			if powerType == "battery" {
-				if batteryCaptured {
-					continue
-				}
-				if presentVal, ok := toInt64(m.Fields["present"]); ok {
-					cm.BatteryPresent = presentVal != 0
-				}
-				if chargeVal, ok := toInt64(m.Fields["charge_percent"]); ok {
-					cm.BatteryChargePct = chargeVal
-				}
-				if voltageVal, ok := toInt64(m.Fields["voltage_mv"]); ok {
-					cm.BatteryVoltageMV = voltageVal
-				}
-				if currentVal, ok := toInt64(m.Fields["current_ma"]); ok {
-					cm.BatteryCurrentMA = currentVal
-				}
-				batteryCaptured = true
-			} else {
-				if powerOnlineCaptured {
-					continue
-				}
-				if onlineVal, ok := toInt64(m.Fields["online"]); ok {
-					cm.PowerOnline = onlineVal != 0
-					powerOnlineCaptured = true
-				}
-			}
+	// Check every kiosk's clock before anything is stored. A rejected kiosk
+	// only loses its own metrics; the request fails only when every kiosk in
+	// it was rejected.
+	skews := make([]float64, len(hosts))
+	rejected := make([]string, len(hosts))
+	accepted := 0
+	for i, hg := range hosts {
+		skew, ok := h.checkClockSkew(hg.ServerID, hg.Metrics, receivedAt)
+		skews[i] = skew
+		if !ok {
+			rejected[i] = fmt.Sprintf("clock skew of %.0fs for server_id=%s exceeds %s", skew, hg.ServerID, h.clockSkewThreshold)
+			continue
+		}
+		accepted++
+	}
+	if len(hosts) > 0 && accepted == 0 {
+		WriteJSONError(w, http.StatusUnprocessableEntity, rejected[0])
+		return
+	}
 
-			if len(m.Fields) > 0 {
-				for fieldName, raw := range m.Fields {
-					if iv, ok := toInt64(raw); ok {
-						val := iv
-						points = append(points, models.SeriesPoint{
-							Time:        ptTime,
-							ServerID:    cm.ServerID,
-							Measurement: "kiosk_power",
-							Field:       fieldName,
-							ValueInt:    &val,
-							TagsJSON:    mustJSON(m.Tags),
-						})
-						continue
-					}
-					if fv, ok := toFloat64(raw); ok {
-						val := fv
-						points = append(points, models.SeriesPoint{
-							Time:        ptTime,
-							ServerID:    cm.ServerID,
-							Measurement: "kiosk_power",
-							Field:       fieldName,
-							ValueDouble: &val,
-							TagsJSON:    mustJSON(m.Tags),
-						})
-					}
+	withReport := wantsReport(r)
+	var headerLogged bool
+	results := make([]hostResult, 0, len(hosts))
+	// Parse every kiosk before storing anything, so that a request the
+	// queues cannot take is refused as a whole, not after some of its kiosks
+	// were already queued.
+	type parsedHost struct {
+		result  int
+		host    string
+		batches []*ingest.Batch
+		points  []models.SeriesPoint
+		// built counts the points before the tag policy, which reports
+		// the points it rejects as dropped.
+		built int
+	}
+	parsed := make([]parsedHost, 0, len(hosts))
+	for i, hg := range hosts {
+		res := hostResult{ServerID: hg.ServerID, Host: hg.Host, Metrics: len(hg.Metrics)}
+		res.ClockSkewSeconds = skews[i]
+		if rejected[i] != "" {
+			res.Error = rejected[i]
+			results = append(results, res)
+			if archived != nil {
+				archived[i] = nil
+			}
+			continue
+		}
+		ph := parsedHost{result: len(results), host: hg.Host}
+		for _, group := range ingest.SplitIntervals(hg.Metrics, h.intervalWidth) {
+			batch := h.registry.NewBatch()
+			// Metrics without identity tags still belong to this kiosk, even
+			// when their interval has no tagged metric.
+			batch.Summary.ServerID = hg.ServerID
+			batch.Summary.ReceivedAt = receivedAt
+			for _, m := range group {
+				batch.Add(m)
+
+				logThisMetric := h.shouldLogForServer(batch.Summary.ServerID, m.Tags["host"])
+				if logThisMetric && !headerLogged {
+					log.Printf("ingest: received %d metrics", len(payload.Metrics))
+					headerLogged = true
 				}
-			}
-
-		case "kiosk_input":
-			device := models.InputDevice{
-				Source: m.Tags["source"],
-				Name:   m.Tags["name"],
-				Vendor: m.Tags["vendor"],
-				Product: m.Tags["product"],
-				Bus:    m.Tags["bus"],
-				Device: m.Tags["device"],
-				Target: m.Tags["target"],
-			}
-			device.Identifier = firstNonEmpty(m.Tags["id"], m.Tags["identifier"], device.Name, device.Target, device.Device)
-
-			present := false
-			if presentVal, ok := toInt64(m.Fields["present"]); ok {
-				present = presentVal != 0
-			} else if eventVal, ok := toInt64(m.Fields["event_present"]); ok {
-				present = eventVal != 0
-			} else if linkVal, ok := toInt64(m.Fields["link_present"]); ok {
-				present = linkVal != 0
-			}
-			device.Present = present
-			if present {
-				inputDevicesHealthy++
-			} else {
-				inputDevicesMissing++
-			}
-			inputDevices = append(inputDevices, device)
-
-		case "kiosk_link":
-			if linkState == nil {
-				linkState = &models.LinkState{}
-			}
-			if iface := m.Tags["interface"]; iface != "" {
-				linkState.Interface = iface
-			}
-			if lt := m.Tags["type"]; lt != "" {
-				linkState.Type = lt
-			}
-			if v, ok := toInt64(m.Fields["link_up"]); ok {
-				linkState.LinkUp = v != 0
-			}
-			if v, ok := toInt64(m.Fields["speed_mbps"]); ok {
-				linkState.SpeedMbps = v
-			}
-			if v, ok := toInt64(m.Fields["duplex_full"]); ok {
-				linkState.DuplexFull = v != 0
-			}
-			if v, ok := toInt64(m.Fields["autoneg"]); ok {
-				linkState.Autoneg = v != 0
-			}
-			if v, ok := toInt64(m.Fields["rx_errors"]); ok {
-				linkState.RxErrors = v
-			}
-			if v, ok := toInt64(m.Fields["tx_errors"]); ok {
-				linkState.TxErrors = v
-			}
-			if v, ok := toInt64(m.Fields["rx_dropped"]); ok {
-				linkState.RxDropped = v
-			}
-			if v, ok := toInt64(m.Fields["tx_dropped"]); ok {
-				linkState.TxDropped = v
-			}
-			if v, ok := toInt64(m.Fields["signal_dbm"]); ok {
-				linkState.SignalDbm = v
-			}
-			if v, ok := toInt64(m.Fields["tx_bitrate_mbps"]); ok {
-				linkState.TxBitrateMbps = v
-			}
-			if v, ok := toInt64(m.Fields["rx_bitrate_mbps"]); ok {
-				linkState.RxBitrateMbps = v
-			}
-
-		case "kiosk_service":
-			name := strings.TrimSpace(m.Tags["name"])
-			if name == "" {
-				continue
-			}
-			status := processStatusMap[name]
-			status.Name = name
-			if v, ok := toInt64(m.Fields["running"]); ok {
-				status.Running = v != 0
-			}
-			if v, ok := toInt64(m.Fields["process_count"]); ok {
-				status.ProcessCount = v
-			}
-			processStatusMap[name] = status
-
-		case "kiosk_hotspot":
-			if hotspotCaptured {
-				continue
-			}
-			if tempRaw, ok := m.Fields["temp_c"]; ok {
-				if tempVal, ok := toFloat64(tempRaw); ok {
-					cm.HotspotTemperature = tempVal
-					hotspotCaptured = true
-					points = append(points,
-						models.SeriesPoint{Time: ptTime, ServerID: cm.ServerID, Measurement: "kiosk_hotspot", Field: "temp_c", ValueDouble: &tempVal, TagsJSON: mustJSON(m.Tags)},
-					)
+				if logThisMetric {
+					log.Printf("ingest: metric name=%s tags=%v fields=%v", m.Name, m.Tags, keysOf(m.Fields))
 				}
 			}
+			batch.Finish()
 
-			points = append(points,
-				seriesPointFloat(ptTime, cm.ServerID, "cpu", "usage_user", m.Fields, m.Tags),
-				seriesPointFloat(ptTime, cm.ServerID, "cpu", "usage_system", m.Fields, m.Tags),
-				seriesPointFloat(ptTime, cm.ServerID, "cpu", "usage_iowait", m.Fields, m.Tags),
-				seriesPointFloat(ptTime, cm.ServerID, "cpu", "usage_steal", m.Fields, m.Tags),
-			)
-
-		case "mem":
-			if v, ok := m.Fields["available_percent"].(float64); ok {
-				cm.Memory = 100 - v
-			}
-			if v, ok := m.Fields["total"].(float64); ok {
-				cm.MemoryTotalBytes = int64(v)
-			}
-			if v, ok := m.Fields["used"].(float64); ok {
-				cm.MemoryUsedBytes = int64(v)
-			}
-
-			points = append(points,
-				seriesPointFloat(ptTime, cm.ServerID, "mem", "used_percent", m.Fields, m.Tags),
-				seriesPointInt(ptTime, cm.ServerID, "mem", "total", m.Fields, m.Tags),
-				seriesPointInt(ptTime, cm.ServerID, "mem", "used", m.Fields, m.Tags),
-			)
-
-		case "swap":
-			points = append(points,
-				seriesPointFloat(ptTime, cm.ServerID, "swap", "used_percent", m.Fields, m.Tags),
-				seriesPointInt(ptTime, cm.ServerID, "swap", "in", m.Fields, m.Tags),
-				seriesPointInt(ptTime, cm.ServerID, "swap", "out", m.Fields, m.Tags),
-			)
-
-		case "disk":
-			switch m.Tags["fstype"] {
-			case "tmpfs", "devtmpfs", "overlay", "squashfs",
-				"proc", "sysfs", "cgroup", "cgroup2",
-				"nsfs", "rpc_pipefs", "devpts",
-				"securityfs", "pstore", "hugetlbfs",
-				"mqueue", "tracefs", "fusectl":
-				continue
+			res.Intervals++
+			ph.batches = append(ph.batches, batch)
+			for _, p := range batch.Points {
+				p.ReceivedAt = receivedAt
+				p.Source = models.SourceTelegraf
+				ph.points = append(ph.points, p)
 			}
 
-			key := m.Tags["device"] + "|" + m.Tags["path"]
-			if _, ok := seenDisk[key]; ok {
-				continue
-			}
-			seenDisk[key] = struct{}{}
-
-			if v, ok := m.Fields["total"].(float64); ok {
-				diskTotalBytes += int64(v)
-			}
-			if v, ok := m.Fields["used"].(float64); ok {
-				diskUsedBytes += int64(v)
-			}
-			if v, ok := m.Fields["free"].(float64); ok {
-				diskFreeBytes += int64(v)
-			}
-
-		case "diskio":
-			points = append(points,
-				seriesPointInt(ptTime, cm.ServerID, "diskio", "read_bytes", m.Fields, m.Tags),
-				seriesPointInt(ptTime, cm.ServerID, "diskio", "write_bytes", m.Fields, m.Tags),
-				seriesPointFloat(ptTime, cm.ServerID, "diskio", "io_util", m.Fields, m.Tags),
-				seriesPointFloat(ptTime, cm.ServerID, "diskio", "io_await", m.Fields, m.Tags),
-			)
-
-		case "system":
-			if v, ok := m.Fields["uptime"].(float64); ok {
-				cm.Uptime = int64(v)
-				points = append(points,
-					seriesPointInt(ptTime, cm.ServerID, "system", "uptime", m.Fields, m.Tags),
-				)
-			}
-
-			points = append(points,
-				seriesPointFloat(ptTime, cm.ServerID, "system", "load1", m.Fields, m.Tags),
-				seriesPointFloat(ptTime, cm.ServerID, "system", "load5", m.Fields, m.Tags),
-				seriesPointFloat(ptTime, cm.ServerID, "system", "load15", m.Fields, m.Tags),
-				seriesPointInt(ptTime, cm.ServerID, "system", "uptime", m.Fields, m.Tags),
-			)
-
-		case "processes":
-			points = append(points,
-				seriesPointInt(ptTime, cm.ServerID, "processes", "running", m.Fields, m.Tags),
-				seriesPointInt(ptTime, cm.ServerID, "processes", "blocked", m.Fields, m.Tags),
-				seriesPointInt(ptTime, cm.ServerID, "processes", "zombies", m.Fields, m.Tags),
-				seriesPointInt(ptTime, cm.ServerID, "processes", "total", m.Fields, m.Tags),
-			)
-
-		case "net":
-			sawNetMetric = true
-			iface := m.Tags["interface"]
-			if iface == "" || strings.HasPrefix(iface, "lo") {
-				continue
-			}
-
-			if v, ok := m.Fields["bytes_sent"].(float64); ok {
-				value := int64(v)
-				netBytesSent += value
-				points = append(points,
-					seriesPointIntValue(ptTime, cm.ServerID, "net", "bytes_sent", value, m.Tags),
-				)
-			}
-
-			if v, ok := m.Fields["bytes_recv"].(float64); ok {
-				value := int64(v)
-				netBytesRecv += value
-				points = append(points,
-					seriesPointIntValue(ptTime, cm.ServerID, "net", "bytes_recv", value, m.Tags),
-				)
-			}
-
-		case "temperature", "sensors", "kiosk_temperature":
-			sawTemperatureMetric = true
-			if tempValue, ok := extractTemperature(m.Fields); ok {
-				if !temperatureCaptured {
-					cm.Temperature = tempValue
-					temperatureCaptured = true
-				}
-				points = append(points,
-					seriesPointFloatValue(ptTime, cm.ServerID, "environment", "temperature_c", tempValue, m.Tags),
-				)
-			}
-
-		case "kiosk_chassis":
-			if chassisTemperatureCaptured {
-				continue
-			}
-			if tempRaw, ok := m.Fields["temp_c"]; ok {
-				if tempVal, ok := toFloat64(tempRaw); ok {
-					cm.ChassisTemperature = tempVal
-					chassisTemperatureCaptured = true
-					points = append(points,
-						models.SeriesPoint{Time: ptTime, ServerID: cm.ServerID, Measurement: "kiosk_chassis", Field: "temp_c", ValueDouble: &tempVal, TagsJSON: mustJSON(m.Tags)},
-					)
+			if withReport {
+				if res.Report == nil {
+					res.Report = batch.Report()
+				} else {
+					res.Report.Merge(batch.Report())
 				}
 			}
-
-		case "kiosk_fan":
-			if fanCaptured {
-				continue
-			}
-			if rpmRaw, ok := m.Fields["rpm"]; ok {
-				if rpmVal, ok := toInt64(rpmRaw); ok {
-					cm.FanRPM = rpmVal
-					fanCaptured = true
-					points = append(points,
-						models.SeriesPoint{Time: ptTime, ServerID: cm.ServerID, Measurement: "kiosk_fan", Field: "rpm", ValueInt: &rpmVal, TagsJSON: mustJSON(m.Tags)},
-					)
+		}
+		ph.built = len(ph.points)
+		ph.points = h.applyTagPolicy(ph.points)
+		parsed = append(parsed, ph)
+		results = append(results, res)
+	}
+
+	if h.reservesQueue() {
+		var points []models.SeriesPoint
+		summaries := 0
+		for _, ph := range parsed {
+			points = append(points, ph.points...)
+			summaries += len(ph.batches)
+		}
+		if !h.reserveQueue(r.Context(), len(points), summaries) {
+			h.countOverflow(points, false)
+			for _, ph := range parsed {
+				for _, batch := range ph.batches {
+					h.overflow.addSummary(batch.Summary.ServerID, false)
 				}
 			}
+			log.Printf("ingest: queue full, rejecting %d points and %d summaries from %d kiosks", len(points), summaries, len(parsed))
+			h.writePersistError(w, errQueueFull)
+			return
+		}
+		defer h.releaseQueue()
+	}
 
-		case "vnstat_daily":
-			if dailyVnstatCaptured {
-				continue
-			}
-			rxBytes, rxOK := mibFieldToBytes(m.Fields, "rx_mib")
-			txBytes, txOK := mibFieldToBytes(m.Fields, "tx_mib")
-			if rxOK {
-				cm.NetDailyRxBytes = rxBytes
-			}
-			if txOK {
-				cm.NetDailyTxBytes = txBytes
-			}
-			if rxOK || txOK {
-				dailyVnstatCaptured = true
-				points = append(points,
-					seriesPointIntValue(ptTime, cm.ServerID, "vnstat_daily", "rx_bytes", cm.NetDailyRxBytes, m.Tags),
-					seriesPointIntValue(ptTime, cm.ServerID, "vnstat_daily", "tx_bytes", cm.NetDailyTxBytes, m.Tags),
-				)
-			}
-
-		case "vnstat_monthly":
-			if monthlyVnstatCaptured {
-				continue
-			}
-			rxBytes, rxOK := mibFieldToBytes(m.Fields, "rx_mib")
-			txBytes, txOK := mibFieldToBytes(m.Fields, "tx_mib")
-			if rxOK {
-				cm.NetMonthlyRxBytes = rxBytes
-			}
-			if txOK {
-				cm.NetMonthlyTxBytes = txBytes
-			}
-			if rxOK || txOK {
-				monthlyVnstatCaptured = true
-				points = append(points,
-					seriesPointIntValue(ptTime, cm.ServerID, "vnstat_monthly", "rx_bytes", cm.NetMonthlyRxBytes, m.Tags),
-					seriesPointIntValue(ptTime, cm.ServerID, "vnstat_monthly", "tx_bytes", cm.NetMonthlyTxBytes, m.Tags),
-				)
-			}
-
-		case "kiosk_volume":
-			if volumeCaptured {
-				continue
-			}
-			if v, ok := m.Fields["level_percent"]; ok {
-				switch vv := v.(type) {
-				case float64:
-					cm.SoundVolumePercent = int64(vv)
-					volumeCaptured = true
-				case int64:
-					cm.SoundVolumePercent = vv
-					volumeCaptured = true
-				case json.Number:
-					if vi, err := vv.Int64(); err == nil {
-						cm.SoundVolumePercent = vi
-						volumeCaptured = true
-					}
-				}
-				if volumeCaptured {
-					val := cm.SoundVolumePercent
-					if mutedVal, ok := m.Fields["muted"].(float64); ok {
-						cm.SoundMuted = mutedVal != 0
-					} else if mutedNum, ok := m.Fields["muted"].(json.Number); ok {
-						if vi, err := mutedNum.Int64(); err == nil {
-							cm.SoundMuted = vi != 0
-						}
-					}
-					points = append(points,
-						models.SeriesPoint{Time: ptTime, ServerID: cm.ServerID, Measurement: "kiosk_volume", Field: "level_percent", ValueInt: &val, TagsJSON: mustJSON(m.Tags)},
-					)
-					mutedInt := int64(0)
-					if cm.SoundMuted {
-						mutedInt = 1
-					}
-					points = append(points,
-						models.SeriesPoint{Time: ptTime, ServerID: cm.ServerID, Measurement: "kiosk_volume", Field: "muted", ValueInt: &mutedInt, TagsJSON: mustJSON(m.Tags)},
-					)
-				}
+	for _, ph := range parsed {
+		res := &results[ph.result]
+		for _, batch := range ph.batches {
+			if !h.saveSummary(w, r, batch, ph.host) {
+				return
 			}
 		}
+		queued, err := h.writePoints(r.Context(), ph.points, res.ServerID, ph.host)
+		if err != nil {
+			h.writePersistError(w, err)
+			return
+		}
+		res.PointsQueued = queued
+		res.PointsDropped = ph.built - queued
+		if res.Report != nil {
+			res.Report.PointsQueued = res.PointsQueued
+			res.Report.PointsDropped = res.PointsDropped
+		}
 	}
 
-	if diskTotalBytes > 0 {
-		cm.Disk = float64(diskUsedBytes) * 100 / float64(diskTotalBytes)
-		cm.DiskTotalBytes = diskTotalBytes
-		cm.DiskUsedBytes = diskUsedBytes
-		cm.DiskFreeBytes = diskFreeBytes
-	}
-	points = append(points,
-		models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "disk", Field: "total", ValueInt: &cm.DiskTotalBytes, TagsJSON: []byte(`{"aggregated":true}`)},
-		models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "disk", Field: "used", ValueInt: &cm.DiskUsedBytes, TagsJSON: []byte(`{"aggregated":true}`)},
-		models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "disk", Field: "free", ValueInt: &cm.DiskFreeBytes, TagsJSON: []byte(`{"aggregated":true}`)},
-		models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "disk", Field: "used_percent", ValueDouble: &cm.Disk, TagsJSON: []byte(`{"aggregated":true}`)},
-	)
-
-	cm.NetBytesSent = netBytesSent
-	cm.NetBytesRecv = netBytesRecv
-	if netBytesSent > 0 || netBytesRecv > 0 {
-		ns := cm.NetBytesSent
-		nr := cm.NetBytesRecv
-		points = append(points,
-			models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "net", Field: "bytes_sent_total", ValueInt: &ns, TagsJSON: []byte(`{"aggregated":true}`)},
-			models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "net", Field: "bytes_recv_total", ValueInt: &nr, TagsJSON: []byte(`{"aggregated":true}`)},
-		)
-	}
-
-	cm.InputDevicesHealthy = inputDevicesHealthy
-	cm.InputDevicesMissing = inputDevicesMissing
-	if len(inputDevices) > 0 {
-		cm.InputDevices = inputDevices
-	} else {
-		cm.InputDevices = nil
+	if archived != nil {
+		h.archivePayloads(hosts, archived, receivedAt)
 	}
 
-	cm.LinkState = linkState
-	if len(processStatusMap) > 0 {
-		names := make([]string, 0, len(processStatusMap))
-		for name := range processStatusMap {
-			names = append(names, name)
-		}
-		sort.Strings(names)
-		for _, name := range names {
-			cm.ProcessStatuses = append(cm.ProcessStatuses, processStatusMap[name])
+	resp := map[string]interface{}{"status": "ok", "hosts": results}
+	if withReport && len(results) == 1 {
+		resp["report"] = results[0].Report
+	}
+	WriteJSON(w, http.StatusOK, resp)
+}
+
+// hostResult is the per-kiosk part of the ingest response.
+type hostResult struct {
+	ServerID      string `json:"server_id"`
+	Host          string `json:"host,omitempty"`
+	Metrics       int    `json:"metrics"`
+	Intervals     int    `json:"intervals"`
+	PointsQueued  int    `json:"points_queued"`
+	PointsDropped int    `json:"points_dropped"`
+	// ClockSkewSeconds is set when the payload's newest timestamp was
+	// further ahead of the receive time than the clock-skew threshold.
+	ClockSkewSeconds float64 `json:"clock_skew_seconds,omitempty"`
+	// Error is set when the kiosk's metrics were rejected; the other kiosks
+	// of the request are unaffected.
+	Error  string         `json:"error,omitempty"`
+	Report *ingest.Report `json:"report,omitempty"`
+}
+
+// shouldLogHosts is shouldLogForServer for any kiosk in the payload.
+func (h *MetricsHandler) shouldLogHosts(hosts []ingest.HostGroup) bool {
+	if len(hosts) == 0 {
+		return h.shouldLogForServer("", "")
+	}
+	for _, hg := range hosts {
+		if h.shouldLogForServer(hg.ServerID, hg.Host) {
+			return true
 		}
 	}
+	return false
+}
 
+// saveSummary persists the summary row of one interval. On failure it writes
+// the error response and returns false.
+func (h *MetricsHandler) saveSummary(w http.ResponseWriter, r *http.Request, batch *ingest.Batch, payloadHost string) bool {
+	cm := batch.Summary
 	debugForServer := h.shouldLogForServer(cm.ServerID, payloadHost)
 
 	if h.debugLoggingOn && debugForServer {
 		log.Printf("ingest: parsed server_id=%s time=%s cpu=%.4f memory=%.4f temperature=%.2f chassis_temp=%.2f hotspot_temp=%.2f fan_rpm=%d volume_percent=%d muted=%t memory_total_bytes=%d memory_used_bytes=%d disk=%.4f disk_total_bytes=%d disk_used_bytes=%d disk_free_bytes=%d net_bytes_sent=%d net_bytes_recv=%d link_state=%+v",
 			cm.ServerID, cm.Time.UTC().Format(time.RFC3339), cm.CPU, cm.Memory,
 			cm.Temperature, cm.ChassisTemperature, cm.HotspotTemperature, cm.FanRPM, cm.SoundVolumePercent, cm.SoundMuted, cm.MemoryTotalBytes, cm.MemoryUsedBytes, cm.Disk, cm.DiskTotalBytes, cm.DiskUsedBytes, cm.DiskFreeBytes, cm.NetBytesSent, cm.NetBytesRecv,
-			linkState)
+			cm.LinkState)
 
-		if !sawTemperatureMetric {
-			log.Printf("ingest: temperature measurement missing for server_id=%s", cm.ServerID)
-		}
-		if !temperatureCaptured && sawTemperatureMetric {
-			log.Printf("ingest: temperature measurement present but unusable fields for server_id=%s fields=%v", cm.ServerID, payload.Metrics)
-		}
-		if !sawNetMetric {
-			log.Printf("ingest: net measurement missing for server_id=%s", cm.ServerID)
-		} else if netBytesSent == 0 && netBytesRecv == 0 {
-			log.Printf("ingest: net measurement reported zero bytes for server_id=%s (check interfaces)", cm.ServerID)
+		for _, note := range batch.Notes {
+			log.Printf("ingest: %s for server_id=%s", note, cm.ServerID)
 		}
 	}
 
 	if h.debugLoggingOn && debugForServer {
 		log.Printf("ingest: saving summary metric server_id=%s time=%s", cm.ServerID, cm.Time.UTC().Format(time.RFC3339))
 	}
-	if err := h.repo.SaveMetric(r.Context(), cm); err != nil {
+	if err := h.persistSummary(r.Context(), cm); err != nil {
 		if h.debugLoggingOn && debugForServer {
 			log.Printf("ingest: failed to save summary metric server_id=%s time=%s err=%v", cm.ServerID, cm.Time.UTC().Format(time.RFC3339), err)
 		}
+		if errors.Is(err, errQueueFull) {
+			h.writePersistError(w, err)
+			return false
+		}
 		WriteJSONError(w, http.StatusInternalServerError, "failed to persist metric: "+err.Error())
-		return
+		return false
 	}
 	if h.debugLoggingOn && debugForServer {
 		log.Printf("ingest: saved summary metric server_id=%s time=%s", cm.ServerID, cm.Time.UTC().Format(time.RFC3339))
 	}
+	return true
+}
+
+// wantsReport reports whether the client asked for the ingest report with
+// ?report=1 or an X-Ingest-Report: 1 header.
+func wantsReport(r *http.Request) bool {
+	v := r.URL.Query().Get("report")
+	if v == "" {
+		v = r.Header.Get("X-Ingest-Report")
+	}
+	on, _ := strconv.ParseBool(v)
+	return on
+}
+
+// persistSummary upserts the summary row synchronously when DIRECT_INSERT is
+// on (or no summary queue is configured) and otherwise hands it to the summary
+// writers. A full queue is handled like the series-point queue, and dropped or
+// rejected rows are counted per server the same way.
+func (h *MetricsHandler) persistSummary(ctx context.Context, cm models.CleanMetric) error {
+	if h.directInsert || h.summaries == nil {
+		return h.repo.SaveMetric(ctx, cm)
+	}
+
+	select {
+	case h.summaries <- cm:
+		return nil
+	default:
+	}
+
+	switch h.overflowPolicy {
+	case OverflowBlock:
+		timer := time.NewTimer(h.overflowTimeout)
+		defer timer.Stop()
+		select {
+		case h.summaries <- cm:
+			return nil
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		h.overflow.addSummary(cm.ServerID, false)
+		return errQueueFull
+	case OverflowReject:
+		h.overflow.addSummary(cm.ServerID, false)
+		return errQueueFull
+	}
+	h.overflow.addSummary(cm.ServerID, true)
+	log.Printf("ingest: summary queue full, dropping summary server_id=%s time=%s", cm.ServerID, cm.Time.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// persistPoints writes points synchronously when DIRECT_INSERT is on (or no
+// queue is configured) and otherwise hands them to the batch writers, after
+// appending them to the WAL if one is configured. When the queue is full the
+// overflow policy decides between dropping points and returning errQueueFull;
+// block and reject queue all of the points or none.
+// Points go through the mapping's tag policy first, and points without a
+// receive time are stamped with the current time. It returns how many points
+// were written or queued; points without a server_id, points rejected by the
+// tag policy and points that did not fit in the queue are not counted.
+func (h *MetricsHandler) persistPoints(ctx context.Context, points []models.SeriesPoint, serverID, hostTag string) (int, error) {
+	points = h.applyTagPolicy(points)
+	if len(points) == 0 {
+		return 0, nil
+	}
+	if h.reservesQueue() {
+		if !h.reserveQueue(ctx, len(points), 0) {
+			h.countOverflow(points, false)
+			log.Printf("ingest: queue full, rejecting %d points server_id=%s", len(points), serverID)
+			return 0, errQueueFull
+		}
+		defer h.releaseQueue()
+	}
+	return h.writePoints(ctx, points, serverID, hostTag)
+}
+
+// writePoints is persistPoints after the tag policy, with the queue room
+// already reserved under the block and reject policies.
+func (h *MetricsHandler) writePoints(ctx context.Context, points []models.SeriesPoint, serverID, hostTag string) (int, error) {
+	if len(points) == 0 {
+		return 0, nil
+	}
+	receivedAt := time.Now().UTC()
+	for i := range points {
+		if points[i].ReceivedAt.IsZero() {
+			points[i].ReceivedAt = receivedAt
+		}
+	}
 
-	if len(points) > 0 {
-		if h.directInsert || h.metricPoints == nil {
+	debugForServer := h.shouldLogForServer(serverID, hostTag)
+
+	if h.directInsert || h.metricPoints == nil {
+		if h.debugLoggingOn && debugForServer {
+			log.Printf("ingest: writing %d series points for server_id=%s", len(points), serverID)
+		}
+		if err := h.repo.SaveSeriesPoints(ctx, points); err != nil {
 			if h.debugLoggingOn && debugForServer {
-				log.Printf("ingest: writing %d series points for server_id=%s", len(points), cm.ServerID)
+				log.Printf("ingest: failed to save series points server_id=%s err=%v", serverID, err)
 			}
-			if err := h.repo.SaveSeriesPoints(r.Context(), points); err != nil {
-				if h.debugLoggingOn && debugForServer {
-					log.Printf("ingest: failed to save series points server_id=%s err=%v", cm.ServerID, err)
-				}
-				WriteJSONError(w, http.StatusInternalServerError, "failed to persist series points: "+err.Error())
-				return
+			return 0, err
+		}
+		if h.debugLoggingOn && debugForServer {
+			log.Printf("ingest: saved series points for server_id=%s", serverID)
+		}
+		written := 0
+		for _, p := range points {
+			if p.ServerID != "" {
+				written++
 			}
-			if h.debugLoggingOn && debugForServer {
-				log.Printf("ingest: saved series points for server_id=%s", cm.ServerID)
+		}
+		return written, nil
+	}
+
+	if h.wal != nil {
+		if err := h.wal.Append(points); err != nil {
+			log.Printf("ingest: wal append failed server_id=%s err=%v", serverID, err)
+			return 0, err
+		}
+	}
+
+	queued := 0
+	for _, p := range points {
+		pointLog := h.shouldLogForServer(p.ServerID, hostTag)
+		if h.debugLoggingOn && pointLog {
+			log.Printf("ingest: queueing point measurement=%s field=%s", p.Measurement, p.Field)
+		}
+
+		ok := true
+		if h.overflowPolicy == OverflowDrop {
+			select {
+			case h.metricPoints <- p:
+			default:
+				ok = false
 			}
 		} else {
-			for _, p := range points {
-				pointLog := h.shouldLogForServer(p.ServerID, payloadHost)
-				if h.debugLoggingOn && pointLog {
-					log.Printf("ingest: queueing point measurement=%s field=%s", p.Measurement, p.Field)
-				}
-				select {
-				case h.metricPoints <- p:
-					if h.debugLoggingOn && pointLog {
-						log.Printf("ingest: queued point measurement=%s field=%s", p.Measurement, p.Field)
-					}
-
-				default:
-					if h.debugLoggingOn && pointLog {
-						log.Printf("ingest: failed to queue point measurement=%s field=%s", p.Measurement, p.Field)
-					}
-				}
+			// The room was reserved, so this only waits if the WAL replay
+			// queued in between.
+			h.metricPoints <- p
+		}
+
+		if ok {
+			if p.ServerID != "" {
+				queued++
 			}
+			if h.debugLoggingOn && pointLog {
+				log.Printf("ingest: queued point measurement=%s field=%s", p.Measurement, p.Field)
+			}
+			continue
 		}
+
+		if h.debugLoggingOn && pointLog {
+			log.Printf("ingest: failed to queue point measurement=%s field=%s", p.Measurement, p.Field)
+		}
+		if h.wal != nil {
+			h.wal.Release([]models.SeriesPoint{p})
+		}
+		h.countOverflow([]models.SeriesPoint{p}, true)
 	}
+	return queued, nil
+}
 
-	WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+// setSource stamps points with the ingest path they came through.
+func setSource(points []models.SeriesPoint, source string) {
+	for i := range points {
+		points[i].Source = source
+	}
+}
+
+// countOverflow adds points that did not fit in the queue to the per-server
+// counters. Points without a server_id would not be stored anyway.
+func (h *MetricsHandler) countOverflow(points []models.SeriesPoint, dropped bool) {
+	counts := make(map[string]int)
+	for _, p := range points {
+		if p.ServerID != "" {
+			counts[p.ServerID]++
+		}
+	}
+	for serverID, n := range counts {
+		if dropped {
+			h.overflow.add(serverID, n, 0)
+		} else {
+			h.overflow.add(serverID, 0, n)
+		}
+	}
 }
 
 func (h *MetricsHandler) SeriesList(w http.ResponseWriter, r *http.Request) {
@@ -763,7 +741,8 @@ func (h *MetricsHandler) SeriesList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	items, hasMore, err := h.repo.ListSeriesMeta(r.Context(), serverID, p.limit, p.offset)
+	measurement := r.URL.Query().Get("measurement")
+	items, hasMore, err := h.repo.ListSeriesMeta(r.Context(), serverID, measurement, p.limit, p.offset)
 	if err != nil {
 		WriteJSONError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -820,7 +799,21 @@ func (h *MetricsHandler) SeriesQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	out, hasMore, err := h.repo.SeriesQuery(r.Context(), serverID, measurement, field, rng, tagFilter, p.limit, p.offset)
+	query := h.repo.SeriesQuery
+	switch r.URL.Query().Get("fn") {
+	case "":
+	case "rate":
+		if !h.registry.Mapping().IsCounter(measurement, field) {
+			WriteJSONError(w, http.StatusBadRequest, fmt.Sprintf("fn=rate needs a counter; %s/%s is not listed under counters in the series mapping", measurement, field))
+			return
+		}
+		query = h.repo.SeriesRate
+	default:
+		WriteJSONError(w, http.StatusBadRequest, "invalid fn")
+		return
+	}
+
+	out, hasMore, err := query(r.Context(), serverID, measurement, field, rng, tagFilter, p.limit, p.offset)
 	if err != nil {
 		WriteJSONError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -964,168 +957,6 @@ func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
-func seriesPointFloat(t time.Time, serverID, measurement, field string, fields map[string]interface{}, tags map[string]string) models.SeriesPoint {
-	var vPtr *float64
-	if v, ok := fields[field].(float64); ok {
-		vv := v
-		vPtr = &vv
-	}
-	jb, _ := json.Marshal(tags)
-	return models.SeriesPoint{Time: t, ServerID: serverID, Measurement: measurement, Field: field, ValueDouble: vPtr, TagsJSON: jb}
-}
-
-func seriesPointInt(t time.Time, serverID, measurement, field string, fields map[string]interface{}, tags map[string]string) models.SeriesPoint {
-	var vPtr *int64
-	if v, ok := fields[field].(float64); ok {
-		vv := int64(v)
-		vPtr = &vv
-	}
-	jb, _ := json.Marshal(tags)
-	return models.SeriesPoint{Time: t, ServerID: serverID, Measurement: measurement, Field: field, ValueInt: vPtr, TagsJSON: jb}
-}
-
-func seriesPointIntValue(t time.Time, serverID, measurement, field string, value int64, tags map[string]string) models.SeriesPoint {
-	vv := value
-	jb, _ := json.Marshal(tags)
-	return models.SeriesPoint{Time: t, ServerID: serverID, Measurement: measurement, Field: field, ValueInt: &vv, TagsJSON: jb}
-}
-
-func seriesPointFloatValue(t time.Time, serverID, measurement, field string, value float64, tags map[string]string) models.SeriesPoint {
-	vv := value
-	jb, _ := json.Marshal(tags)
-	return models.SeriesPoint{Time: t, ServerID: serverID, Measurement: measurement, Field: field, ValueDouble: &vv, TagsJSON: jb}
-}
-
-func mustJSON(tags map[string]string) []byte {
-	if tags == nil {
-		return []byte(`{}`)
-	}
-	if jb, err := json.Marshal(tags); err == nil {
-		return jb
-	}
-	return []byte(`{}`)
-}
-
-func extractTemperature(fields map[string]interface{}) (float64, bool) {
-	if fields == nil {
-		return 0, false
-	}
-
-	getFloat := func(val interface{}) (float64, bool) {
-		switch v := val.(type) {
-		case float64:
-			return v, true
-		case int64:
-			return float64(v), true
-		case int:
-			return float64(v), true
-		case string:
-			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
-				return parsed, true
-			}
-		}
-		return 0, false
-	}
-
-	preferredKeys := []string{"temp_input", "temperature", "temp_c", "temp", "value", "current"}
-	for _, key := range preferredKeys {
-		if val, ok := fields[key]; ok {
-			if f, ok := getFloat(val); ok {
-				return f, true
-			}
-		}
-	}
-
-	for key, val := range fields {
-		lower := strings.ToLower(key)
-		if strings.Contains(lower, "temp") {
-			if f, ok := getFloat(val); ok {
-				return f, true
-			}
-		}
-	}
-
-	return 0, false
-}
-
-func toFloat64(val interface{}) (float64, bool) {
-	switch v := val.(type) {
-	case float64:
-		return v, true
-	case float32:
-		return float64(v), true
-	case int:
-		return float64(v), true
-	case int64:
-		return float64(v), true
-	case json.Number:
-		if f, err := v.Float64(); err == nil {
-			return f, true
-		}
-	case string:
-		if f, err := strconv.ParseFloat(v, 64); err == nil {
-			return f, true
-		}
-	}
-	return 0, false
-}
-
-func toInt64(val interface{}) (int64, bool) {
-	switch v := val.(type) {
-	case int64:
-		return v, true
-	case int:
-		return int64(v), true
-	case float64:
-		return int64(v), true
-	case float32:
-		return int64(v), true
-	case json.Number:
-		if i, err := v.Int64(); err == nil {
-			return i, true
-		}
-	case string:
-		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
-			return i, true
-		}
-	}
-	return 0, false
-}
-
-func mibFieldToBytes(fields map[string]interface{}, key string) (int64, bool) {
-	if fields == nil {
-		return 0, false
-	}
-	val, ok := fields[key]
-	if !ok {
-		return 0, false
-	}
-	var f float64
-	switch v := val.(type) {
-	case float64:
-		f = v
-	case float32:
-		f = float64(v)
-	case int:
-		f = float64(v)
-	case int64:
-		f = float64(v)
-	case string:
-		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
-			f = parsed
-		} else {
-			return 0, false
-		}
-	default:
-		return 0, false
-	}
-	bytes := int64(f * 1024 * 1024)
-	if bytes < 0 {
-		bytes = 0
-	}
-	return bytes, true
-}
-
 func keysOf(m map[string]interface{}) []string {
 	if m == nil {
 		return nil
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"metrics-api/internal/ingest"
+	"metrics-api/internal/models"
+)
+
+// TestDecodeTelegrafPayloadNumbers runs a Telegraf JSON body through the
+// ingest decoder, which keeps numbers as json.Number, and checks the fields
+// read by the helpers that used to switch on float64 only.
+func TestDecodeTelegrafPayloadNumbers(t *testing.T) {
+	f, err := os.Open("testdata/telegraf_kiosk.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var payload models.TelegrafPayload
+	if err := decodeTelegrafPayload(f, &payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	b := ingest.NewDefaultRegistry().Build(payload.Metrics)
+	s := b.Summary
+
+	checks := []struct {
+		name      string
+		got, want int64
+	}{
+		{"daily rx", s.NetDailyRxBytes, 1608790310},
+		{"daily tx", s.NetDailyTxBytes, 222822400},
+		{"monthly rx", s.NetMonthlyRxBytes, 42165207040},
+		{"monthly tx", s.NetMonthlyTxBytes, 5368709120},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %d, want %d", c.name, c.got, c.want)
+		}
+	}
+	if s.Temperature != 47 {
+		t.Errorf("temperature = %v, want 47", s.Temperature)
+	}
+
+	var envTemp *float64
+	for _, p := range b.Points {
+		if p.Measurement == "environment" && p.Field == "temperature_c" {
+			envTemp = p.ValueDouble
+		}
+	}
+	if envTemp == nil || *envTemp != 47 {
+		t.Errorf("environment/temperature_c = %v, want 47", envTemp)
+	}
+}
+
+// TestIngestTelegrafPayload posts the same Telegraf body to the ingest
+// endpoint and checks the queued summary and series points, so a regression
+// anywhere between decoding and persisting shows up, not only in the decoder.
+func TestIngestTelegrafPayload(t *testing.T) {
+	body, err := os.ReadFile("testdata/telegraf_kiosk.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Move the fixture's timestamps to now so the clock-skew check passes
+	// whatever the test machine's clock says.
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	body = []byte(strings.ReplaceAll(string(body), `"timestamp":1792056000`, `"timestamp":`+now))
+
+	points := make(chan models.SeriesPoint, 100)
+	summaries := make(chan models.CleanMetric, 10)
+	h := NewMetricsHandler(nil, points, Config{Summaries: summaries})
+
+	r := httptest.NewRequest(http.MethodPost, "/api/metrics", strings.NewReader(string(body)))
+	r.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.Ingest(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	if len(summaries) != 1 {
+		t.Fatalf("queued %d summaries, want 1", len(summaries))
+	}
+	s := <-summaries
+	if s.ServerID != "kiosk-0142" {
+		t.Errorf("summary server_id = %q", s.ServerID)
+	}
+	if s.NetDailyRxBytes != 1608790310 || s.NetMonthlyTxBytes != 5368709120 {
+		t.Errorf("summary vnstat = daily rx %d, monthly tx %d", s.NetDailyRxBytes, s.NetMonthlyTxBytes)
+	}
+	if s.Temperature != 47 {
+		t.Errorf("summary temperature = %v, want 47", s.Temperature)
+	}
+
+	got := make(map[string]models.SeriesPoint)
+	for len(points) > 0 {
+		p := <-points
+		got[p.Measurement+"/"+p.Field] = p
+	}
+	ints := []struct {
+		series string
+		want   int64
+	}{
+		{"vnstat_daily/rx_bytes", 1608790310},
+		{"vnstat_daily/tx_bytes", 222822400},
+		{"vnstat_monthly/rx_bytes", 42165207040},
+		{"vnstat_monthly/tx_bytes", 5368709120},
+	}
+	for _, c := range ints {
+		p, ok := got[c.series]
+		if !ok || p.ValueInt == nil {
+			t.Errorf("%s: no integer point queued", c.series)
+			continue
+		}
+		if *p.ValueInt != c.want {
+			t.Errorf("%s = %d, want %d", c.series, *p.ValueInt, c.want)
+		}
+		if p.ServerID != "kiosk-0142" || p.Source != models.SourceTelegraf {
+			t.Errorf("%s: server_id %q source %q", c.series, p.ServerID, p.Source)
+		}
+	}
+	p, ok := got["environment/temperature_c"]
+	if !ok || p.ValueDouble == nil || *p.ValueDouble != 47 {
+		t.Errorf("environment/temperature_c = %+v, want 47", p.ValueDouble)
+	}
+}
+
+// A rate only makes sense on a cumulative counter; on a gauge it would read
+// every dip as a counter reset.
+func TestSeriesQueryRateNeedsCounter(t *testing.T) {
+	h := NewMetricsHandler(nil, make(chan models.SeriesPoint, 1), Config{Summaries: make(chan models.CleanMetric, 1)})
+	tests := []struct {
+		name, query string
+	}{
+		{"gauge", "measurement=mem&field=used_percent&fn=rate"},
+		{"unmapped series", "measurement=nginx&field=requests&fn=rate"},
+		{"unknown fn", "measurement=net&field=bytes_recv&fn=delta"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/series/query?server_id=kiosk-1&"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			h.SeriesQuery(rec, r)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"metrics-api/internal/ingest"
+	"metrics-api/internal/models"
+	"metrics-api/internal/otlp"
+)
+
+const contentTypeProtobuf = "application/x-protobuf"
+
+// OTLPMetrics accepts OTLP/HTTP metric exports (protobuf or JSON) and stores
+// gauges, sums and histograms in metric_points. Delta-temporality sums and
+// histograms are rejected through the response's partial_success, since
+// stored counters are read as cumulative.
+func (h *MetricsHandler) OTLPMetrics(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	body, err := requestBody(r, h.maxBodyBytes)
+	if err != nil {
+		WriteJSONError(w, bodyErrorStatus(err), err.Error())
+		return
+	}
+	defer body.Close()
+
+	isProto := strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), contentTypeProtobuf)
+
+	var req *otlp.ExportRequest
+	if isProto {
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			WriteJSONError(w, bodyErrorStatus(err), err.Error())
+			return
+		}
+		req, err = otlp.UnmarshalProto(raw)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else {
+		req, err = otlp.DecodeJSON(body)
+		if err == nil {
+			err = drainBody(body)
+		}
+		if err != nil {
+			WriteJSONError(w, bodyErrorStatus(err), err.Error())
+			return
+		}
+	}
+
+	points, serverID, rejected := otlpPoints(req, time.Now())
+	if h.debugLoggingOn && h.shouldLogForServer(serverID, "") {
+		log.Printf("otlp: received %d resource metrics, %d points", len(req.ResourceMetrics), len(points))
+	}
+
+	if err := bindPointsIdentity(r.Context(), points); err != nil {
+		WriteJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	setSource(points, models.SourceOTLP)
+	if _, err := h.persistPoints(r.Context(), points, serverID, ""); err != nil {
+		h.writePersistError(w, err)
+		return
+	}
+
+	// An empty ExportMetricsServiceResponse signals full success.
+	if isProto {
+		w.Header().Set("Content-Type", contentTypeProtobuf)
+		w.WriteHeader(http.StatusOK)
+		if rejected > 0 {
+			w.Write(otlp.MarshalPartialSuccess(int64(rejected), errOTLPDelta))
+		}
+		return
+	}
+	resp := map[string]interface{}{}
+	if rejected > 0 {
+		resp["partialSuccess"] = map[string]interface{}{
+			"rejectedDataPoints": strconv.Itoa(rejected),
+			"errorMessage":       errOTLPDelta,
+		}
+	}
+	WriteJSON(w, http.StatusOK, resp)
+}
+
+// errOTLPDelta explains rejected delta data points to the exporter.
+const errOTLPDelta = "delta temporality is not supported; configure the exporter for cumulative temporality"
+
+// otlpPoints maps an export request onto series points. host.name (or a
+// server_id attribute) on the resource becomes the server ID; service.name is
+// kept as a tag next to the data point attributes. Resources without an
+// identity are skipped. The first server ID seen is returned for logging,
+// along with the number of delta data points rejected.
+func otlpPoints(req *otlp.ExportRequest, received time.Time) ([]models.SeriesPoint, string, int) {
+	var points []models.SeriesPoint
+	rejected := 0
+	firstServerID := ""
+
+	for _, rm := range req.ResourceMetrics {
+		serverID, _ := otlp.Attribute(rm.Resource.Attributes, "host.name")
+		if serverID == "" {
+			serverID, _ = otlp.Attribute(rm.Resource.Attributes, "server_id")
+		}
+		if serverID == "" {
+			continue
+		}
+		if firstServerID == "" {
+			firstServerID = serverID
+		}
+
+		baseTags := map[string]string{}
+		if service, ok := otlp.Attribute(rm.Resource.Attributes, "service.name"); ok && service != "" {
+			baseTags["service.name"] = service
+		}
+
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				if m.Name == "" {
+					continue
+				}
+				switch {
+				case m.Gauge != nil:
+					for _, dp := range m.Gauge.DataPoints {
+						if p, ok := otlpNumberPoint(serverID, m.Name, dp, baseTags, received); ok {
+							points = append(points, p)
+						}
+					}
+				case m.Sum != nil && m.Sum.AggregationTemporality == otlp.TemporalityDelta:
+					rejected += len(m.Sum.DataPoints)
+				case m.Histogram != nil && m.Histogram.AggregationTemporality == otlp.TemporalityDelta:
+					rejected += len(m.Histogram.DataPoints)
+				case m.Sum != nil:
+					for _, dp := range m.Sum.DataPoints {
+						if p, ok := otlpNumberPoint(serverID, m.Name, dp, baseTags, received); ok {
+							points = append(points, p)
+						}
+					}
+				case m.Histogram != nil:
+					for _, dp := range m.Histogram.DataPoints {
+						points = append(points, otlpHistogramPoints(serverID, m.Name, dp, baseTags, received)...)
+					}
+				}
+			}
+		}
+	}
+	return points, firstServerID, rejected
+}
+
+func otlpNumberPoint(serverID, name string, dp otlp.NumberDataPoint, baseTags map[string]string, received time.Time) (models.SeriesPoint, bool) {
+	p := models.SeriesPoint{
+		Time:        otlpTime(uint64(dp.TimeUnixNano), received),
+		ServerID:    serverID,
+		Measurement: name,
+		Field:       "value",
+		TagsJSON:    ingest.MustJSON(otlpTags(baseTags, dp.Attributes)),
+	}
+	switch {
+	case dp.AsInt != nil:
+		v := int64(*dp.AsInt)
+		p.ValueInt = &v
+	case dp.AsDouble != nil:
+		if math.IsNaN(*dp.AsDouble) || math.IsInf(*dp.AsDouble, 0) {
+			return p, false
+		}
+		v := *dp.AsDouble
+		p.ValueDouble = &v
+	default:
+		return p, false
+	}
+	return p, true
+}
+
+// otlpHistogramPoints emits count/sum/min/max plus one cumulative "bucket"
+// point per bound, tagged with le like a Prometheus histogram.
+func otlpHistogramPoints(serverID, name string, dp otlp.HistogramDataPoint, baseTags map[string]string, received time.Time) []models.SeriesPoint {
+	t := otlpTime(uint64(dp.TimeUnixNano), received)
+	tags := otlpTags(baseTags, dp.Attributes)
+
+	points := []models.SeriesPoint{
+		ingest.IntPoint(t, serverID, name, "count", int64(dp.Count), tags),
+	}
+	if dp.Sum != nil {
+		points = append(points, ingest.FloatPoint(t, serverID, name, "sum", *dp.Sum, tags))
+	}
+	if dp.Min != nil {
+		points = append(points, ingest.FloatPoint(t, serverID, name, "min", *dp.Min, tags))
+	}
+	if dp.Max != nil {
+		points = append(points, ingest.FloatPoint(t, serverID, name, "max", *dp.Max, tags))
+	}
+
+	var cumulative int64
+	for i, count := range dp.BucketCounts {
+		cumulative += int64(count)
+		le := "+Inf"
+		if i < len(dp.ExplicitBounds) {
+			le = strconv.FormatFloat(dp.ExplicitBounds[i], 'f', -1, 64)
+		}
+		bucketTags := make(map[string]string, len(tags)+1)
+		for k, v := range tags {
+			bucketTags[k] = v
+		}
+		bucketTags["le"] = le
+		points = append(points, ingest.IntPoint(t, serverID, name, "bucket", cumulative, bucketTags))
+	}
+	return points
+}
+
+func otlpTags(base map[string]string, attrs []otlp.KeyValue) map[string]string {
+	tags := make(map[string]string, len(base)+len(attrs))
+	for k, v := range base {
+		tags[k] = v
+	}
+	for _, kv := range attrs {
+		tags[kv.Key] = kv.Value.String()
+	}
+	return tags
+}
+
+func otlpTime(unixNano uint64, received time.Time) time.Time {
+	if unixNano == 0 {
+		return received
+	}
+	return time.Unix(0, int64(unixNano))
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"metrics-api/internal/models"
+)
+
+// TestOTLPRejectsDeltaSums posts a cumulative and a delta sum; only the
+// cumulative one is queued and the delta point is reported through
+// partialSuccess.
+func TestOTLPRejectsDeltaSums(t *testing.T) {
+	queue := make(chan models.SeriesPoint, 10)
+	h := NewMetricsHandler(nil, queue, Config{})
+
+	body := `{"resourceMetrics":[{
+	  "resource":{"attributes":[{"key":"host.name","value":{"stringValue":"kiosk-0042"}}]},
+	  "scopeMetrics":[{"metrics":[
+	    {"name":"bytes","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[{"timeUnixNano":"1760000000000000000","asInt":"100"}]}},
+	    {"name":"requests","sum":{"aggregationTemporality":1,"isMonotonic":true,"dataPoints":[{"timeUnixNano":"1760000000000000000","asInt":"5"}]}}]}]}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.OTLPMetrics(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		PartialSuccess struct {
+			RejectedDataPoints string `json:"rejectedDataPoints"`
+			ErrorMessage       string `json:"errorMessage"`
+		} `json:"partialSuccess"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.PartialSuccess.RejectedDataPoints != "1" || resp.PartialSuccess.ErrorMessage == "" {
+		t.Errorf("partialSuccess = %+v, want 1 rejected point", resp.PartialSuccess)
+	}
+	if len(queue) != 1 {
+		t.Fatalf("queued %d points, want 1", len(queue))
+	}
+	if p := <-queue; p.Measurement != "bytes" || p.ValueInt == nil || *p.ValueInt != 100 {
+		t.Errorf("queued %+v, want bytes=100", p)
+	}
+}
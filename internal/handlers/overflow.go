@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Overflow policies for a full series-point queue.
+const (
+	// OverflowDrop drops the points that do not fit and counts them.
+	OverflowDrop = "drop"
+	// OverflowBlock waits up to the overflow timeout for room for the whole
+	// request, then rejects it.
+	OverflowBlock = "block"
+	// OverflowReject rejects the request with 503 when its points do not fit.
+	OverflowReject = "reject"
+)
+
+const (
+	defaultOverflowTimeout = 2 * time.Second
+	defaultRetryAfter      = 10 * time.Second
+	// queuePollInterval is how often a blocked request checks the queue for
+	// room; the writers do not signal when they take points.
+	queuePollInterval = 5 * time.Millisecond
+)
+
+// ValidOverflowPolicy reports whether policy is one of the Overflow constants.
+func ValidOverflowPolicy(policy string) bool {
+	switch policy {
+	case OverflowDrop, OverflowBlock, OverflowReject:
+		return true
+	}
+	return false
+}
+
+// reservesQueue reports whether requests have to reserve queue room before
+// queueing, which the block and reject policies do to stay all or nothing.
+func (h *MetricsHandler) reservesQueue() bool {
+	return !h.directInsert && h.metricPoints != nil && h.overflowPolicy != OverflowDrop
+}
+
+// reserveQueue takes the enqueue lock once the series-point queue has room
+// for n points and the summary queue room for summaries rows, so that a
+// request under the block or reject policy is queued all or nothing, across
+// every kiosk and interval in it: requests holding the lock are the only ones
+// that fill the queues, and the writers only empty them. Under block it waits
+// up to the overflow timeout for the lock and the room; under reject it fails
+// at once when the room is missing. A request larger than a queue never
+// fits. On success the caller queues its rows and calls releaseQueue.
+func (h *MetricsHandler) reserveQueue(ctx context.Context, n, summaries int) bool {
+	if h.summaries == nil {
+		summaries = 0
+	}
+	if n > cap(h.metricPoints) || summaries > cap(h.summaries) {
+		return false
+	}
+	hasRoom := func() bool {
+		return cap(h.metricPoints)-len(h.metricPoints) >= n && cap(h.summaries)-len(h.summaries) >= summaries
+	}
+	var timeout <-chan time.Time
+	if h.overflowPolicy == OverflowBlock {
+		timer := time.NewTimer(h.overflowTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case h.enqueue <- struct{}{}:
+	case <-timeout:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+	if hasRoom() {
+		return true
+	}
+	if h.overflowPolicy != OverflowBlock {
+		h.releaseQueue()
+		return false
+	}
+
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	for !hasRoom() {
+		select {
+		case <-ticker.C:
+		case <-timeout:
+			h.releaseQueue()
+			return false
+		case <-ctx.Done():
+			h.releaseQueue()
+			return false
+		}
+	}
+	return true
+}
+
+func (h *MetricsHandler) releaseQueue() {
+	<-h.enqueue
+}
+
+// errQueueFull is returned by persistPoints when the overflow policy refuses
+// the request; clients get 503 with Retry-After so they back off and resend.
+var errQueueFull = errors.New("ingest queue full")
+
+// ServerOverflow counts the points and summary rows of one kiosk that did not
+// make it into their queues. Dropped ones are lost; rejected ones were refused
+// with 503 and are expected to be resent by the client.
+type ServerOverflow struct {
+	ServerID          string    `json:"server_id"`
+	Dropped           int64     `json:"dropped"`
+	Rejected          int64     `json:"rejected"`
+	SummariesDropped  int64     `json:"summaries_dropped"`
+	SummariesRejected int64     `json:"summaries_rejected"`
+	LastAt            time.Time `json:"last_at"`
+}
+
+type overflowCounters struct {
+	mu      sync.Mutex
+	servers map[string]*ServerOverflow
+}
+
+func newOverflowCounters() *overflowCounters {
+	return &overflowCounters{servers: make(map[string]*ServerOverflow)}
+}
+
+func (c *overflowCounters) add(serverID string, dropped, rejected int) {
+	if dropped == 0 && rejected == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.server(serverID)
+	s.Dropped += int64(dropped)
+	s.Rejected += int64(rejected)
+	s.LastAt = time.Now().UTC()
+}
+
+// addSummary counts one summary row that did not fit in the summary queue.
+func (c *overflowCounters) addSummary(serverID string, dropped bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.server(serverID)
+	if dropped {
+		s.SummariesDropped++
+	} else {
+		s.SummariesRejected++
+	}
+	s.LastAt = time.Now().UTC()
+}
+
+// server returns the counters of serverID, creating them; c.mu must be held.
+func (c *overflowCounters) server(serverID string) *ServerOverflow {
+	s, ok := c.servers[serverID]
+	if !ok {
+		s = &ServerOverflow{ServerID: serverID}
+		c.servers[serverID] = s
+	}
+	return s
+}
+
+// snapshot returns the counters sorted by server_id.
+func (c *overflowCounters) snapshot() []ServerOverflow {
+	c.mu.Lock()
+	out := make([]ServerOverflow, 0, len(c.servers))
+	for _, s := range c.servers {
+		out = append(out, *s)
+	}
+	c.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ServerID < out[j].ServerID })
+	return out
+}
+
+// writePersistError answers a failed persistPoints call.
+func (h *MetricsHandler) writePersistError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errQueueFull) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.retryAfter/time.Second)))
+		WriteJSONError(w, http.StatusServiceUnavailable, "ingest queue full, retry later")
+		return
+	}
+	WriteJSONError(w, http.StatusInternalServerError, "failed to persist series points: "+err.Error())
+}
+
+// AdminQueue reports the series-point and summary queue depths, the overflow
+// policy, the per-server counts of dropped and rejected points and summary
+// rows since startup, and the WAL state when it is enabled.
+func (h *MetricsHandler) AdminQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var dropped, rejected, summariesDropped, summariesRejected int64
+	servers := h.overflow.snapshot()
+	for _, s := range servers {
+		dropped += s.Dropped
+		rejected += s.Rejected
+		summariesDropped += s.SummariesDropped
+		summariesRejected += s.SummariesRejected
+	}
+	resp := map[string]interface{}{
+		"policy":             h.overflowPolicy,
+		"length":             len(h.metricPoints),
+		"capacity":           cap(h.metricPoints),
+		"summary_length":     len(h.summaries),
+		"summary_capacity":   cap(h.summaries),
+		"dropped":            dropped,
+		"rejected":           rejected,
+		"summaries_dropped":  summariesDropped,
+		"summaries_rejected": summariesRejected,
+		"servers":            servers,
+	}
+	if h.wal != nil {
+		resp["wal"] = h.wal.Stats()
+	}
+	WriteJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// TestPersistPointsBlockAllOrNothing checks that under the block policy a
+// request whose points do not all fit queues none of them, and that one
+// queues all of them once the writers made room.
+func TestPersistPointsBlockAllOrNothing(t *testing.T) {
+	queue := make(chan models.SeriesPoint, 3)
+	h := NewMetricsHandler(nil, queue, Config{OverflowPolicy: OverflowBlock, OverflowTimeout: 50 * time.Millisecond})
+	queue <- models.SeriesPoint{ServerID: "other"}
+	queue <- models.SeriesPoint{ServerID: "other"}
+
+	points := func() []models.SeriesPoint {
+		return []models.SeriesPoint{
+			{ServerID: "kiosk-1", Measurement: "net", Field: "bytes_recv"},
+			{ServerID: "kiosk-1", Measurement: "net", Field: "bytes_sent"},
+		}
+	}
+	if _, err := h.persistPoints(context.Background(), points(), "kiosk-1", ""); !errors.Is(err, errQueueFull) {
+		t.Fatalf("persistPoints error = %v, want errQueueFull", err)
+	}
+	if len(queue) != 2 {
+		t.Fatalf("queue holds %d points after the rejection, want the 2 queued before", len(queue))
+	}
+	if s := h.overflow.snapshot(); len(s) != 1 || s[0].Rejected != 2 {
+		t.Errorf("overflow counters = %+v, want 2 rejected for kiosk-1", s)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-queue
+	}()
+	queued, err := h.persistPoints(context.Background(), points(), "kiosk-1", "")
+	if err != nil || queued != 2 {
+		t.Fatalf("persistPoints = %d, %v; want 2 queued", queued, err)
+	}
+	if len(queue) != 3 {
+		t.Errorf("queue holds %d points, want 3", len(queue))
+	}
+
+	// More points than the queue holds can never fit.
+	big := append(points(), points()...)
+	if _, err := h.persistPoints(context.Background(), big, "kiosk-1", ""); !errors.Is(err, errQueueFull) {
+		t.Errorf("persistPoints of %d points into a queue of 3: error = %v, want errQueueFull", len(big), err)
+	}
+}
+
+// TestIngestMultiHostAllOrNothing checks that a payload with several kiosks
+// is refused as a whole when the queues cannot take every kiosk's points and
+// summary rows, instead of failing after the first kiosks were queued.
+func TestIngestMultiHostAllOrNothing(t *testing.T) {
+	now := time.Now().Unix()
+	var metrics []string
+	for _, id := range []string{"kiosk-1", "kiosk-2"} {
+		metrics = append(metrics, fmt.Sprintf(`{"name":"mem","fields":{"used_percent":10,"total":100,"used":10},"tags":{"server_id":%q},"timestamp":%d}`, id, now))
+	}
+	body := `{"metrics":[` + strings.Join(metrics, ",") + `]}`
+
+	tests := []struct {
+		name                      string
+		points, summaries         int
+		wantStatus                int
+		wantQueued, wantSummaries int
+	}{
+		{name: "points fit one kiosk", points: 5, summaries: 2, wantStatus: http.StatusServiceUnavailable},
+		{name: "summaries fit one kiosk", points: 6, summaries: 1, wantStatus: http.StatusServiceUnavailable},
+		{name: "room for both", points: 6, summaries: 2, wantStatus: http.StatusOK, wantQueued: 6, wantSummaries: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := make(chan models.SeriesPoint, tt.points)
+			summaries := make(chan models.CleanMetric, tt.summaries)
+			h := NewMetricsHandler(nil, points, Config{Summaries: summaries, OverflowPolicy: OverflowReject})
+
+			r := httptest.NewRequest(http.MethodPost, "/api/metrics", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.Ingest(rec, r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if len(points) != tt.wantQueued || len(summaries) != tt.wantSummaries {
+				t.Errorf("queued %d points and %d summaries, want %d and %d", len(points), len(summaries), tt.wantQueued, tt.wantSummaries)
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable {
+				if rec.Header().Get("Retry-After") == "" {
+					t.Error("503 without Retry-After")
+				}
+				if s := h.overflow.snapshot(); len(s) != 2 || s[0].Rejected != 3 || s[1].SummariesRejected != 1 {
+					t.Errorf("overflow counters = %+v, want 3 points and 1 summary rejected per kiosk", s)
+				}
+			}
+		})
+	}
+}
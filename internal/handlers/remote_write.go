@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"metrics-api/internal/ingest"
+	"metrics-api/internal/models"
+	"metrics-api/internal/promwrite"
+)
+
+// RemoteWrite accepts Prometheus remote_write requests and stores every
+// sample as a metric_points row (measurement = metric name, field = "value").
+func (h *MetricsHandler) RemoteWrite(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	compressed, err := io.ReadAll(io.LimitReader(r.Body, h.maxBodyBytes+1))
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if int64(len(compressed)) > h.maxBodyBytes {
+		WriteJSONError(w, http.StatusRequestEntityTooLarge, errBodyTooLarge.Error())
+		return
+	}
+
+	series, err := promwrite.Decode(compressed, int(h.maxBodyBytes))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, promwrite.ErrTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		WriteJSONError(w, status, err.Error())
+		return
+	}
+
+	points, serverID := remoteWritePoints(series)
+	if h.debugLoggingOn && h.shouldLogForServer(serverID, "") {
+		log.Printf("remote_write: received %d series, %d points", len(series), len(points))
+	}
+
+	if err := bindPointsIdentity(r.Context(), points); err != nil {
+		WriteJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	setSource(points, models.SourceRemoteWrite)
+	if _, err := h.persistPoints(r.Context(), points, serverID, ""); err != nil {
+		h.writePersistError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remoteWritePoints maps samples onto series points. The kiosk identity comes
+// from the server_id label, falling back to host and then the host part of
+// instance; series without any of them are skipped. It also returns the first
+// server ID seen, for logging.
+func remoteWritePoints(series []promwrite.TimeSeries) ([]models.SeriesPoint, string) {
+	var points []models.SeriesPoint
+	firstServerID := ""
+
+	for _, ts := range series {
+		name := ts.Name()
+		if name == "" {
+			continue
+		}
+		serverID := remoteWriteServerID(ts)
+		if serverID == "" {
+			continue
+		}
+		if firstServerID == "" {
+			firstServerID = serverID
+		}
+
+		tags := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			if l.Name == "__name__" {
+				continue
+			}
+			tags[l.Name] = l.Value
+		}
+		tagsJSON := ingest.MustJSON(tags)
+
+		for _, s := range ts.Samples {
+			// Stale markers and other non-finite values cannot be charted.
+			if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+				continue
+			}
+			v := s.Value
+			points = append(points, models.SeriesPoint{
+				Time:        time.UnixMilli(s.TimestampMs),
+				ServerID:    serverID,
+				Measurement: name,
+				Field:       "value",
+				ValueDouble: &v,
+				TagsJSON:    tagsJSON,
+			})
+		}
+	}
+	return points, firstServerID
+}
+
+func remoteWriteServerID(ts promwrite.TimeSeries) string {
+	if v := ts.Label("server_id"); v != "" {
+		return v
+	}
+	if v := ts.Label("host"); v != "" {
+		return v
+	}
+	instance := ts.Label("instance")
+	if host, _, err := net.SplitHostPort(instance); err == nil {
+		return host
+	}
+	return instance
+}
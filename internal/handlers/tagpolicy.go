@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"metrics-api/internal/ingest"
+	"metrics-api/internal/models"
+)
+
+const (
+	defaultTagSetWindow = 24 * time.Hour
+	// defaultTagSeriesPerServer caps the series tracked per server for the
+	// tag set limit.
+	defaultTagSeriesPerServer = 10000
+	// maxRejectedSeries caps the series listed per server in the tag policy
+	// report.
+	maxRejectedSeries = 20
+)
+
+// ServerTagPolicy counts the tag policy's actions on one kiosk's points since
+// startup. RejectedSeries lists the measurement/field pairs that hit the tag
+// set limit; SeriesEvicted counts series the tag set tracker forgot to stay
+// under its per-server series cap.
+type ServerTagPolicy struct {
+	ServerID        string    `json:"server_id"`
+	TagsRemoved     int64     `json:"tags_removed"`
+	ValuesTruncated int64     `json:"values_truncated"`
+	PointsRejected  int64     `json:"points_rejected"`
+	RejectedSeries  []string  `json:"rejected_series,omitempty"`
+	SeriesEvicted   int64     `json:"series_evicted"`
+	LastAt          time.Time `json:"last_at"`
+}
+
+// tagSetTracker remembers the distinct tag sets each server stored per
+// series. A tag set not seen for window is forgotten, so replaced devices or
+// renamed services free their slot. Each server tracks at most maxSeries
+// series; a new series beyond that evicts the one least recently stored,
+// whose tag sets then count from zero again.
+type tagSetTracker struct {
+	window    time.Duration
+	maxSeries int
+
+	mu      sync.Mutex
+	servers map[string]*serverSeries
+}
+
+// serverSeries holds one server's series, most recently stored first.
+type serverSeries struct {
+	lru    *list.List // of *trackedSeries
+	series map[string]*list.Element
+}
+
+type trackedSeries struct {
+	key  string
+	sets map[string]time.Time
+}
+
+func newTagSetTracker(window time.Duration, maxSeries int) *tagSetTracker {
+	return &tagSetTracker{window: window, maxSeries: maxSeries, servers: make(map[string]*serverSeries)}
+}
+
+// admit reports whether the tag set may be stored for the server's series,
+// recording it when it is new and within limit. evicted is set when tracking
+// the series pushed another one of the server out.
+func (t *tagSetTracker) admit(serverID, seriesKey, tagSet string, limit int, now time.Time) (ok, evicted bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	srv, found := t.servers[serverID]
+	if !found {
+		srv = &serverSeries{lru: list.New(), series: make(map[string]*list.Element)}
+		t.servers[serverID] = srv
+	}
+
+	var ts *trackedSeries
+	if el, found := srv.series[seriesKey]; found {
+		srv.lru.MoveToFront(el)
+		ts = el.Value.(*trackedSeries)
+	} else {
+		if srv.lru.Len() >= t.maxSeries {
+			oldest := srv.lru.Back()
+			srv.lru.Remove(oldest)
+			delete(srv.series, oldest.Value.(*trackedSeries).key)
+			evicted = true
+		}
+		ts = &trackedSeries{key: seriesKey, sets: make(map[string]time.Time)}
+		srv.series[seriesKey] = srv.lru.PushFront(ts)
+	}
+
+	sets := ts.sets
+	if _, found := sets[tagSet]; found || len(sets) < limit {
+		sets[tagSet] = now
+		return true, evicted
+	}
+	for k, seen := range sets {
+		if now.Sub(seen) > t.window {
+			delete(sets, k)
+		}
+	}
+	if len(sets) < limit {
+		sets[tagSet] = now
+		return true, evicted
+	}
+	return false, evicted
+}
+
+type tagPolicyCounters struct {
+	mu      sync.Mutex
+	servers map[string]*ServerTagPolicy
+}
+
+func newTagPolicyCounters() *tagPolicyCounters {
+	return &tagPolicyCounters{servers: make(map[string]*ServerTagPolicy)}
+}
+
+func (c *tagPolicyCounters) add(serverID string, changes ingest.TagChanges, rejectedSeries string, evicted bool) {
+	if changes.Removed == 0 && changes.Truncated == 0 && rejectedSeries == "" && !evicted {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.servers[serverID]
+	if !ok {
+		s = &ServerTagPolicy{ServerID: serverID}
+		c.servers[serverID] = s
+	}
+	s.TagsRemoved += int64(changes.Removed)
+	s.ValuesTruncated += int64(changes.Truncated)
+	if evicted {
+		s.SeriesEvicted++
+	}
+	if rejectedSeries != "" {
+		s.PointsRejected++
+		if len(s.RejectedSeries) < maxRejectedSeries && !containsSeries(s.RejectedSeries, rejectedSeries) {
+			s.RejectedSeries = append(s.RejectedSeries, rejectedSeries)
+		}
+	}
+	s.LastAt = time.Now().UTC()
+}
+
+func containsSeries(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot returns the counters sorted by server_id.
+func (c *tagPolicyCounters) snapshot() []ServerTagPolicy {
+	c.mu.Lock()
+	out := make([]ServerTagPolicy, 0, len(c.servers))
+	for _, s := range c.servers {
+		cp := *s
+		cp.RejectedSeries = append([]string(nil), s.RejectedSeries...)
+		out = append(out, cp)
+	}
+	c.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ServerID < out[j].ServerID })
+	return out
+}
+
+// applyTagPolicy enforces the current mapping's tag policy on points before
+// they are stored: it rewrites their tags and drops the points whose tag set
+// would exceed the per-server limit for their series. Points without a
+// server_id are passed through untouched; they are never stored. Without an
+// active policy points is returned as is; otherwise the caller's slice is
+// left alone and a new one returned.
+func (h *MetricsHandler) applyTagPolicy(points []models.SeriesPoint) []models.SeriesPoint {
+	m := h.registry.Mapping()
+	if m == nil || len(points) == 0 || !m.TagPolicy.Active() {
+		return points
+	}
+	policy := &m.TagPolicy
+	now := time.Now()
+
+	kept := make([]models.SeriesPoint, 0, len(points))
+	for _, p := range points {
+		if p.ServerID == "" {
+			kept = append(kept, p)
+			continue
+		}
+		var tags map[string]interface{}
+		if len(p.TagsJSON) > 0 {
+			if err := json.Unmarshal(p.TagsJSON, &tags); err != nil {
+				tags = nil
+			}
+		}
+		var changes ingest.TagChanges
+		if tags != nil {
+			changes = policy.Apply(p.Measurement, tags)
+			if b, err := json.Marshal(tags); err == nil {
+				p.TagsJSON = b
+			}
+		}
+
+		rejected := ""
+		evicted := false
+		if policy.MaxTagSets > 0 {
+			seriesKey := p.Measurement + "\x00" + p.Field
+			var ok bool
+			ok, evicted = h.tagSets.admit(p.ServerID, seriesKey, string(p.TagsJSON), policy.MaxTagSets, now)
+			if !ok {
+				rejected = p.Measurement + "/" + p.Field
+			}
+		}
+		h.tagPolicy.add(p.ServerID, changes, rejected, evicted)
+		if rejected != "" {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// AdminTags reports the active tag policy and, per server, how many tags were
+// removed, values truncated, points rejected for exceeding the tag set limit
+// and series evicted from the tag set tracker since startup.
+func (h *MetricsHandler) AdminTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var policy ingest.TagPolicy
+	if m := h.registry.Mapping(); m != nil {
+		policy = m.TagPolicy
+	}
+	var removed, truncated, rejected, evicted int64
+	servers := h.tagPolicy.snapshot()
+	for _, s := range servers {
+		removed += s.TagsRemoved
+		truncated += s.ValuesTruncated
+		rejected += s.PointsRejected
+		evicted += s.SeriesEvicted
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"policy":            policy,
+		"tag_set_window":    h.tagSets.window.String(),
+		"series_per_server": h.tagSets.maxSeries,
+		"tags_removed":      removed,
+		"values_truncated":  truncated,
+		"points_rejected":   rejected,
+		"series_evicted":    evicted,
+		"servers":           servers,
+	})
+}
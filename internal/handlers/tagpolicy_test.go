@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"metrics-api/internal/ingest"
+	"metrics-api/internal/models"
+)
+
+func TestTagSetTrackerSeriesCap(t *testing.T) {
+	tr := newTagSetTracker(time.Hour, 2)
+	now := time.Now()
+
+	admit := func(serverID, series, tagSet string) (bool, bool) {
+		return tr.admit(serverID, series, tagSet, 1, now)
+	}
+	if ok, evicted := admit("k1", "cpu", "a"); !ok || evicted {
+		t.Fatalf("first series: ok=%v evicted=%v", ok, evicted)
+	}
+	if ok, _ := admit("k1", "cpu", "b"); ok {
+		t.Fatal("second tag set admitted over max_tag_sets=1")
+	}
+	admit("k1", "mem", "a")
+	// cpu was used more recently than mem, so disk evicts mem.
+	admit("k1", "cpu", "a")
+	if ok, evicted := admit("k1", "disk", "a"); !ok || !evicted {
+		t.Fatalf("third series: ok=%v evicted=%v, want an eviction", ok, evicted)
+	}
+	if ok, _ := admit("k1", "cpu", "b"); ok {
+		t.Error("cpu was evicted instead of the least recently used series")
+	}
+	if ok, evicted := admit("k1", "mem", "b"); !ok || !evicted {
+		t.Errorf("evicted series came back with ok=%v evicted=%v", ok, evicted)
+	}
+
+	// The cap is per server.
+	if ok, evicted := admit("k2", "net", "a"); !ok || evicted {
+		t.Errorf("other server: ok=%v evicted=%v", ok, evicted)
+	}
+	if n := tr.servers["k1"].lru.Len(); n != 2 {
+		t.Errorf("k1 tracks %d series, want 2", n)
+	}
+}
+
+func newTagPolicyHandler(t *testing.T, policy ingest.TagPolicy) *MetricsHandler {
+	t.Helper()
+	m, err := ingest.DefaultMapping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.TagPolicy = policy
+	registry := ingest.NewDefaultRegistry()
+	registry.SetMapping(m)
+	return NewMetricsHandler(nil, make(chan models.SeriesPoint, 1), Config{
+		Registry:  registry,
+		Summaries: make(chan models.CleanMetric, 1),
+	})
+}
+
+func TestApplyTagPolicy(t *testing.T) {
+	const tags = `{"name":" Kiosk-1 ","interface":"WLAN0"}`
+	tests := []struct {
+		name     string
+		policy   ingest.TagPolicy
+		wantTags string
+	}{
+		{name: "disabled", wantTags: tags},
+		{name: "lowercase", policy: ingest.TagPolicy{Lowercase: true}, wantTags: `{"interface":"wlan0","name":"kiosk-1"}`},
+		{name: "tag sets only", policy: ingest.TagPolicy{MaxTagSets: 10}, wantTags: `{"interface":"WLAN0","name":"Kiosk-1"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTagPolicyHandler(t, tt.policy)
+			points := []models.SeriesPoint{
+				{ServerID: "kiosk-1", Measurement: "net", Field: "bytes_recv", TagsJSON: []byte(tags)},
+				{ServerID: "kiosk-1", Measurement: "net", Field: "bytes_sent", TagsJSON: []byte(tags)},
+			}
+			got := h.applyTagPolicy(points)
+			if len(got) != len(points) {
+				t.Fatalf("kept %d points, want %d", len(got), len(points))
+			}
+			for _, p := range got {
+				if string(p.TagsJSON) != tt.wantTags {
+					t.Errorf("%s tags = %s, want %s", p.Field, p.TagsJSON, tt.wantTags)
+				}
+			}
+			for _, p := range points {
+				if string(p.TagsJSON) != tags {
+					t.Errorf("caller's %s point changed to %s", p.Field, p.TagsJSON)
+				}
+			}
+		})
+	}
+}
+
+// Rejected points must not shift the caller's slice.
+func TestApplyTagPolicyKeepsCallerSlice(t *testing.T) {
+	h := newTagPolicyHandler(t, ingest.TagPolicy{MaxTagSets: 1})
+	points := []models.SeriesPoint{
+		{ServerID: "kiosk-1", Measurement: "net", Field: "bytes_recv", TagsJSON: []byte(`{"interface":"eth0"}`)},
+		{ServerID: "kiosk-1", Measurement: "net", Field: "bytes_recv", TagsJSON: []byte(`{"interface":"eth1"}`)},
+		{ServerID: "kiosk-1", Measurement: "net", Field: "bytes_sent", TagsJSON: []byte(`{"interface":"eth0"}`)},
+	}
+	got := h.applyTagPolicy(points)
+	if len(got) != 2 || got[1].Field != "bytes_sent" {
+		t.Fatalf("kept %+v, want bytes_recv and bytes_sent on eth0", got)
+	}
+	if string(points[1].TagsJSON) != `{"interface":"eth1"}` || points[2].Field != "bytes_sent" {
+		t.Errorf("caller's slice was overwritten: %+v", points)
+	}
+}
@@ -0,0 +1,109 @@
+package ingest
+
+import (
+	"math"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// Batch accumulates the summary row and series points built from one payload.
+type Batch struct {
+	Summary models.CleanMetric
+	Points  []models.SeriesPoint
+	// Notes collects diagnostics (missing or unusable measurements) that the
+	// HTTP layer prints when debug logging is on.
+	Notes []string
+
+	registry *Registry
+	mapping  *Mapping
+	handlers []Handler
+
+	// Report bookkeeping.
+	metrics   int
+	anonymous int
+	accepted  map[string]int
+	ignored   map[string]int
+	drops     map[dropKey]int
+	dropOrder []dropKey
+	// used holds the fields of the metric being added that a handler, the
+	// mapping or passthrough has consumed; skipReason is set when a handler
+	// consumed the metric but deliberately used none of its fields.
+	used       map[string]struct{}
+	skipReason string
+}
+
+// Build runs every metric through a new batch and finishes it.
+func (r *Registry) Build(metrics []models.Metric) *Batch {
+	b := r.NewBatch()
+	for _, m := range metrics {
+		b.Add(m)
+	}
+	b.Finish()
+	return b
+}
+
+// Add dispatches one metric to every matching handler and then emits the
+// series points declared in the mapping; metrics neither consumed go through
+// the mapping's passthrough patterns. A handler consumes a metric by reading
+// its fields or skipping it; matching a registration alone (like the kiosk_
+// location prefix) does not count. The first metric that
+// carries a server_id (or host) tag and a timestamp fixes the summary's
+// identity and time.
+func (b *Batch) Add(m models.Metric) {
+	if b.Summary.ServerID == "" {
+		b.Summary.ServerID = m.Tags["server_id"]
+		if b.Summary.ServerID == "" || b.Summary.ServerID == "$HOSTNAME" {
+			b.Summary.ServerID = m.Tags["host"]
+		}
+	}
+
+	t := MetricTime(m.Timestamp)
+	if b.Summary.Time.IsZero() && m.Timestamp > 0 {
+		b.Summary.Time = t
+	}
+
+	clear(b.used)
+	b.skipReason = ""
+	for i, reg := range b.registry.registrations {
+		if reg.matches(m.Name) {
+			b.handlers[i].Handle(b, m, t)
+		}
+	}
+	consumed := len(b.used) > 0 || b.skipReason != ""
+	if b.mapping.apply(b, m, t) {
+		consumed = true
+	}
+	if !consumed && b.mapping.passthrough(b, m, t) {
+		consumed = true
+	}
+	b.trackMetric(m, consumed)
+}
+
+// MetricTime converts a Telegraf timestamp in (possibly fractional) Unix
+// seconds, keeping the fraction to the microsecond; float64 cannot carry
+// nanoseconds for current dates.
+func MetricTime(ts float64) time.Time {
+	sec := math.Floor(ts)
+	usec := math.Round((ts - sec) * 1e6)
+	return time.Unix(int64(sec), int64(usec)*int64(time.Microsecond))
+}
+
+// Finish lets every handler fold its accumulated state into the batch.
+func (b *Batch) Finish() {
+	for _, h := range b.handlers {
+		if f, ok := h.(Finisher); ok {
+			f.Finish(b)
+		}
+	}
+}
+
+// AddPoints appends series points to the batch.
+func (b *Batch) AddPoints(points ...models.SeriesPoint) {
+	b.Points = append(b.Points, points...)
+}
+
+// Note records a diagnostic message for debug logging.
+func (b *Batch) Note(msg string) {
+	b.Notes = append(b.Notes, msg)
+}
@@ -0,0 +1,42 @@
+package ingest
+
+// NewDefaultRegistry returns a registry with the handlers for every
+// measurement the kiosk Telegraf config ships. Teams adding a helper script
+// register their handler here (or on their own registry) instead of touching
+// the HTTP layer. Measurements that only copy fields into series (swap,
+// diskio, processes, ...) live in the mapping; the registry starts with the
+// embedded default mapping.
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(stateless(cpuHandler{}), "cpu")
+	r.Register(newDisplayHandler, "kiosk_display")
+	r.Register(newPowerHandler, "kiosk_power")
+	r.Register(newInputHandler, "kiosk_input")
+	r.Register(newLinkHandler, "kiosk_link")
+	r.Register(newServiceHandler, "kiosk_service")
+	r.Register(newHotspotHandler, "kiosk_hotspot")
+	r.Register(stateless(memHandler{}), "mem")
+	r.Register(newDiskHandler, "disk")
+	r.Register(stateless(systemHandler{}), "system")
+	r.Register(newNetHandler, "net")
+	r.Register(newTemperatureHandler, "temperature", "sensors", "kiosk_temperature")
+	r.Register(newChassisHandler, "kiosk_chassis")
+	r.Register(newFanHandler, "kiosk_fan")
+	r.Register(newVnstatHandler("vnstat_daily"), "vnstat_daily")
+	r.Register(newVnstatHandler("vnstat_monthly"), "vnstat_monthly")
+	r.Register(newVolumeHandler, "kiosk_volume")
+	r.RegisterPrefix(stateless(locationHandler{}), "kiosk_")
+
+	m, err := DefaultMapping()
+	if err != nil {
+		panic("ingest: embedded mapping.yaml: " + err.Error())
+	}
+	r.SetMapping(m)
+	return r
+}
+
+// stateless wraps a handler without per-payload state so every batch can
+// share the same value.
+func stateless(h Handler) Factory {
+	return func() Handler { return h }
+}
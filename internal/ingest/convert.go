@@ -0,0 +1,160 @@
+package ingest
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// ToFloat64 converts a decoded field value (JSON number, line protocol
+// integer or numeric string) to float64. Booleans are not numbers here; see
+// flagInt64.
+func ToFloat64(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f, true
+		}
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// ToInt64 converts a decoded field value to int64, truncating floats.
+func ToInt64(val interface{}) (int64, bool) {
+	switch v := val.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	case float32:
+		return int64(v), true
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, true
+		}
+		// Payloads decoded with UseNumber keep "12.5" as is; truncate it
+		// like a float64.
+		if f, err := v.Float64(); err == nil {
+			return int64(f), true
+		}
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// flagInt64 reads an on/off field as 1 or 0: a number (non-zero is on) or a
+// line protocol boolean.
+func flagInt64(val interface{}) (int64, bool) {
+	if v, ok := val.(bool); ok {
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return ToInt64(val)
+}
+
+func mibFieldToBytes(fields map[string]interface{}, key string) (int64, bool) {
+	if fields == nil {
+		return 0, false
+	}
+	val, ok := fields[key]
+	if !ok {
+		return 0, false
+	}
+	var f float64
+	switch v := val.(type) {
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	case int:
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	case json.Number:
+		parsed, err := v.Float64()
+		if err != nil {
+			return 0, false
+		}
+		f = parsed
+	case string:
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+			f = parsed
+		} else {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	bytes := int64(f * 1024 * 1024)
+	if bytes < 0 {
+		bytes = 0
+	}
+	return bytes, true
+}
+
+// extractTemperature returns the first usable temperature reading and the
+// field it came from.
+func extractTemperature(fields map[string]interface{}) (float64, string, bool) {
+	if fields == nil {
+		return 0, "", false
+	}
+
+	getFloat := func(val interface{}) (float64, bool) {
+		switch v := val.(type) {
+		case float64:
+			return v, true
+		case int64:
+			return float64(v), true
+		case int:
+			return float64(v), true
+		case json.Number:
+			if parsed, err := v.Float64(); err == nil {
+				return parsed, true
+			}
+		case string:
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				return parsed, true
+			}
+		}
+		return 0, false
+	}
+
+	preferredKeys := []string{"temp_input", "temperature", "temp_c", "temp", "value", "current"}
+	for _, key := range preferredKeys {
+		if val, ok := fields[key]; ok {
+			if f, ok := getFloat(val); ok {
+				return f, key, true
+			}
+		}
+	}
+
+	for key, val := range fields {
+		lower := strings.ToLower(key)
+		if strings.Contains(lower, "temp") {
+			if f, ok := getFloat(val); ok {
+				return f, key, true
+			}
+		}
+	}
+
+	return 0, "", false
+}
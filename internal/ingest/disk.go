@@ -0,0 +1,71 @@
+package ingest
+
+import (
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// diskHandler sums capacity across real filesystems (each device/path once)
+// and emits the aggregated disk series when the payload is finished.
+type diskHandler struct {
+	totalBytes int64
+	usedBytes  int64
+	freeBytes  int64
+	seen       map[string]struct{}
+}
+
+func newDiskHandler() Handler {
+	return &diskHandler{seen: make(map[string]struct{})}
+}
+
+func (h *diskHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	switch m.Tags["fstype"] {
+	case "tmpfs", "devtmpfs", "overlay", "squashfs",
+		"proc", "sysfs", "cgroup", "cgroup2",
+		"nsfs", "rpc_pipefs", "devpts",
+		"securityfs", "pstore", "hugetlbfs",
+		"mqueue", "tracefs", "fusectl":
+		b.SkipMetric("pseudo filesystem")
+		return
+	}
+
+	key := m.Tags["device"] + "|" + m.Tags["path"]
+	if _, ok := h.seen[key]; ok {
+		b.SkipMetric("filesystem already counted")
+		return
+	}
+	h.seen[key] = struct{}{}
+
+	if v, ok := ToFloat64(b.Field(m, "total")); ok {
+		h.totalBytes += int64(v)
+	}
+	if v, ok := ToFloat64(b.Field(m, "used")); ok {
+		h.usedBytes += int64(v)
+	}
+	if v, ok := ToFloat64(b.Field(m, "free")); ok {
+		h.freeBytes += int64(v)
+	}
+}
+
+// Finish emits the aggregated disk series, but only when the batch carried
+// a real filesystem; a payload without disk metrics gets no zero points.
+func (h *diskHandler) Finish(b *Batch) {
+	if len(h.seen) == 0 {
+		return
+	}
+	cm := &b.Summary
+	if h.totalBytes > 0 {
+		cm.Disk = float64(h.usedBytes) * 100 / float64(h.totalBytes)
+		cm.DiskTotalBytes = h.totalBytes
+		cm.DiskUsedBytes = h.usedBytes
+		cm.DiskFreeBytes = h.freeBytes
+	}
+	total, used, free, usedPercent := cm.DiskTotalBytes, cm.DiskUsedBytes, cm.DiskFreeBytes, cm.Disk
+	b.AddPoints(
+		models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "disk", Field: "total", ValueInt: &total, TagsJSON: aggregatedTags},
+		models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "disk", Field: "used", ValueInt: &used, TagsJSON: aggregatedTags},
+		models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "disk", Field: "free", ValueInt: &free, TagsJSON: aggregatedTags},
+		models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "disk", Field: "used_percent", ValueDouble: &usedPercent, TagsJSON: aggregatedTags},
+	)
+}
@@ -0,0 +1,381 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"metrics-api/internal/models"
+)
+
+// loadPayload decodes a Telegraf JSON sample from testdata. useNumber decodes
+// numbers as json.Number, as the HTTP layer does.
+func loadPayload(t *testing.T, name string, useNumber bool) []models.Metric {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if useNumber {
+		dec.UseNumber()
+	}
+	var payload models.TelegrafPayload
+	if err := dec.Decode(&payload); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return payload.Metrics
+}
+
+// findPoint returns the first point of measurement/field whose tags contain
+// every key/value pair of tags.
+func findPoint(b *Batch, measurement, field string, tags map[string]interface{}) *models.SeriesPoint {
+	for i, p := range b.Points {
+		if p.Measurement != measurement || p.Field != field {
+			continue
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(p.TagsJSON, &got); err != nil {
+			continue
+		}
+		match := true
+		for k, v := range tags {
+			if got[k] != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			return &b.Points[i]
+		}
+	}
+	return nil
+}
+
+func pointValue(p *models.SeriesPoint) float64 {
+	switch {
+	case p.ValueInt != nil:
+		return float64(*p.ValueInt)
+	case p.ValueDouble != nil:
+		return *p.ValueDouble
+	}
+	return math.NaN()
+}
+
+type wantPoint struct {
+	measurement string
+	field       string
+	tags        map[string]interface{}
+	value       float64
+}
+
+var aggregated = map[string]interface{}{"aggregated": true}
+
+func TestHandlers(t *testing.T) {
+	tests := []struct {
+		file    string
+		summary func(t *testing.T, cm *models.CleanMetric)
+		points  []wantPoint
+		absent  []wantPoint
+	}{
+		{
+			file: "cpu.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				checkFloat(t, "CPU", cm.CPU, 12.5)
+			},
+			// No disk metrics, so no aggregated disk points.
+			absent: []wantPoint{
+				{"disk", "total", aggregated, 0},
+				{"disk", "used_percent", aggregated, 0},
+			},
+		},
+		{
+			file: "mem.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				checkFloat(t, "Memory", cm.Memory, 28.07)
+				checkInt(t, "MemoryTotalBytes", cm.MemoryTotalBytes, 8206647296)
+				checkInt(t, "MemoryUsedBytes", cm.MemoryUsedBytes, 2076491776)
+			},
+		},
+		{
+			// The root filesystem is reported twice and tmpfs is skipped.
+			file: "disk.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				checkInt(t, "DiskTotalBytes", cm.DiskTotalBytes, 125565071360)
+				checkInt(t, "DiskUsedBytes", cm.DiskUsedBytes, 22088134656)
+				checkInt(t, "DiskFreeBytes", cm.DiskFreeBytes, 97081393152)
+				checkFloat(t, "Disk", cm.Disk, 22088134656*100.0/125565071360)
+			},
+			points: []wantPoint{
+				{"disk", "used_percent", aggregated, 22088134656 * 100.0 / 125565071360},
+				{"disk", "total", aggregated, 125565071360},
+				{"disk", "used", aggregated, 22088134656},
+				{"disk", "free", aggregated, 97081393152},
+			},
+		},
+		{
+			file: "system.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				checkInt(t, "Uptime", cm.Uptime, 864123)
+			},
+		},
+		{
+			file: "net.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				checkInt(t, "NetBytesSent", cm.NetBytesSent, 1234569890)
+				checkInt(t, "NetBytesRecv", cm.NetBytesRecv, 9876544210)
+			},
+			points: []wantPoint{
+				{"net", "bytes_recv", map[string]interface{}{"interface": "eth0"}, 9876543210},
+				{"net", "bytes_sent_total", aggregated, 1234569890},
+				{"net", "bytes_recv_total", aggregated, 9876544210},
+			},
+			absent: []wantPoint{
+				{"net", "bytes_recv", map[string]interface{}{"interface": "lo"}, 0},
+			},
+		},
+		{
+			file: "temperature.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				checkFloat(t, "Temperature", cm.Temperature, 52.5)
+			},
+			points: []wantPoint{
+				{"environment", "temperature_c", map[string]interface{}{"chip": "coretemp-isa-0000"}, 52.5},
+				{"environment", "temperature_c", map[string]interface{}{"sensor": "acpitz"}, 49},
+			},
+		},
+		{
+			file: "vnstat.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				checkInt(t, "NetDailyRxBytes", cm.NetDailyRxBytes, 1608798699)
+				checkInt(t, "NetDailyTxBytes", cm.NetDailyTxBytes, 222822400)
+				checkInt(t, "NetMonthlyRxBytes", cm.NetMonthlyRxBytes, 42165338112)
+				checkInt(t, "NetMonthlyTxBytes", cm.NetMonthlyTxBytes, 5368709120)
+			},
+			points: []wantPoint{
+				{"vnstat_daily", "rx_bytes", nil, 1608798699},
+				{"vnstat_monthly", "tx_bytes", nil, 5368709120},
+			},
+		},
+		{
+			// The connected primary output wins over the disconnected one
+			// reported first; the kiosk_ tags set the location.
+			file: "kiosk_display.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				if !cm.DisplayConnected || !cm.DisplayPrimary || !cm.DisplayDpmsEnabled {
+					t.Errorf("display connected/primary/dpms = %v/%v/%v, want all true", cm.DisplayConnected, cm.DisplayPrimary, cm.DisplayDpmsEnabled)
+				}
+				checkInt(t, "DisplayWidth", cm.DisplayWidth, 1080)
+				checkInt(t, "DisplayHeight", cm.DisplayHeight, 1920)
+				checkInt(t, "DisplayRefreshHz", cm.DisplayRefreshHz, 60)
+				if cm.City != "blr" || cm.CityName != "Bengaluru" || cm.Region != "KA" || cm.RegionName != "Karnataka" {
+					t.Errorf("location = %q/%q/%q/%q", cm.City, cm.CityName, cm.Region, cm.RegionName)
+				}
+			},
+			points: []wantPoint{
+				{"kiosk_display", "width", map[string]interface{}{"output": "HDMI-1"}, 1080},
+			},
+		},
+		{
+			file: "kiosk_power.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				if !cm.PowerOnline || !cm.BatteryPresent {
+					t.Errorf("online/battery present = %v/%v, want true/true", cm.PowerOnline, cm.BatteryPresent)
+				}
+				checkInt(t, "BatteryChargePct", cm.BatteryChargePct, 87)
+				checkInt(t, "BatteryVoltageMV", cm.BatteryVoltageMV, 12480)
+				checkInt(t, "BatteryCurrentMA", cm.BatteryCurrentMA, -412)
+			},
+		},
+		{
+			file: "kiosk_input.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				checkInt(t, "InputDevicesHealthy", cm.InputDevicesHealthy, 1)
+				checkInt(t, "InputDevicesMissing", cm.InputDevicesMissing, 1)
+				if len(cm.InputDevices) != 2 {
+					t.Fatalf("InputDevices = %+v, want 2", cm.InputDevices)
+				}
+				if cm.InputDevices[0].Identifier != "ILITEK Multi-Touch" || cm.InputDevices[1].Identifier != "barcode-scanner" {
+					t.Errorf("identifiers = %q, %q", cm.InputDevices[0].Identifier, cm.InputDevices[1].Identifier)
+				}
+			},
+			points: []wantPoint{
+				{"kiosk_input", "present", map[string]interface{}{"identifier": "ILITEK Multi-Touch"}, 1},
+				{"kiosk_input", "present", map[string]interface{}{"identifier": "barcode-scanner"}, 0},
+			},
+		},
+		{
+			file: "kiosk_link.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				s := cm.LinkState
+				if s == nil {
+					t.Fatal("LinkState = nil")
+				}
+				if s.Interface != "eth0" || s.Type != "ethernet" || !s.LinkUp || !s.DuplexFull || !s.Autoneg {
+					t.Errorf("LinkState = %+v", *s)
+				}
+				checkInt(t, "SpeedMbps", s.SpeedMbps, 1000)
+				checkInt(t, "RxDropped", s.RxDropped, 3)
+			},
+			points: []wantPoint{
+				{"kiosk_link", "speed_mbps", map[string]interface{}{"interface": "eth0"}, 1000},
+			},
+		},
+		{
+			file: "kiosk_service.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				want := []models.ProcessStatus{
+					{Name: "card-reader"},
+					{Name: "kiosk-browser", Running: true, ProcessCount: 3},
+				}
+				if len(cm.ProcessStatuses) != len(want) {
+					t.Fatalf("ProcessStatuses = %+v, want %+v", cm.ProcessStatuses, want)
+				}
+				for i := range want {
+					if cm.ProcessStatuses[i] != want[i] {
+						t.Errorf("ProcessStatuses[%d] = %+v, want %+v", i, cm.ProcessStatuses[i], want[i])
+					}
+				}
+			},
+			points: []wantPoint{
+				{"kiosk_service", "process_count", map[string]interface{}{"name": "kiosk-browser"}, 3},
+			},
+		},
+		{
+			file: "kiosk_hotspot.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				checkFloat(t, "HotspotTemperature", cm.HotspotTemperature, 61.5)
+			},
+			points: []wantPoint{
+				{"kiosk_hotspot", "temp_c", nil, 61.5},
+				{"cpu", "usage_user", nil, 22.75},
+			},
+		},
+		{
+			file: "kiosk_chassis.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				checkFloat(t, "ChassisTemperature", cm.ChassisTemperature, 38.25)
+				checkInt(t, "FanRPM", cm.FanRPM, 2150)
+			},
+			points: []wantPoint{
+				{"kiosk_chassis", "temp_c", map[string]interface{}{"zone": "cabinet"}, 38.25},
+				{"kiosk_fan", "rpm", map[string]interface{}{"fan": "fan1"}, 2150},
+			},
+		},
+		{
+			file: "kiosk_volume.json",
+			summary: func(t *testing.T, cm *models.CleanMetric) {
+				checkInt(t, "SoundVolumePercent", cm.SoundVolumePercent, 65)
+				if !cm.SoundMuted {
+					t.Error("SoundMuted = false, want true")
+				}
+			},
+			points: []wantPoint{
+				{"kiosk_volume", "muted", nil, 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, useNumber := range []bool{true, false} {
+			name := tt.file
+			if useNumber {
+				name += "/json.Number"
+			} else {
+				name += "/float64"
+			}
+			t.Run(name, func(t *testing.T) {
+				b := NewDefaultRegistry().Build(loadPayload(t, tt.file, useNumber))
+				if b.Summary.ServerID != "kiosk-0042" {
+					t.Errorf("ServerID = %q, want kiosk-0042", b.Summary.ServerID)
+				}
+				if b.Summary.Time.Unix() != 1760000000 {
+					t.Errorf("Time = %v, want 1760000000", b.Summary.Time)
+				}
+				tt.summary(t, &b.Summary)
+				for _, w := range tt.points {
+					p := findPoint(b, w.measurement, w.field, w.tags)
+					if p == nil {
+						t.Errorf("no %s/%s point with tags %v", w.measurement, w.field, w.tags)
+						continue
+					}
+					if got := pointValue(p); math.Abs(got-w.value) > 1e-9 {
+						t.Errorf("%s/%s = %v, want %v", w.measurement, w.field, got, w.value)
+					}
+				}
+				for _, w := range tt.absent {
+					if p := findPoint(b, w.measurement, w.field, w.tags); p != nil {
+						t.Errorf("unexpected %s/%s point with tags %s", w.measurement, w.field, p.TagsJSON)
+					}
+				}
+			})
+		}
+	}
+}
+
+func checkInt(t *testing.T, name string, got, want int64) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s = %d, want %d", name, got, want)
+	}
+}
+
+func checkFloat(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s = %v, want %v", name, got, want)
+	}
+}
+
+// TestBooleanFields feeds line protocol booleans: they are not numbers, so
+// only the link and service flags become 1/0 series.
+func TestBooleanFields(t *testing.T) {
+	tags := func(extra ...string) map[string]string {
+		m := map[string]string{"server_id": "kiosk-0042"}
+		for i := 0; i+1 < len(extra); i += 2 {
+			m[extra[i]] = extra[i+1]
+		}
+		return m
+	}
+	b := NewDefaultRegistry().Build([]models.Metric{
+		{Name: "kiosk_display", Tags: tags(), Timestamp: 1760000000, Fields: map[string]interface{}{
+			"connected": true, "width": int64(1920),
+		}},
+		{Name: "kiosk_power", Tags: tags(), Timestamp: 1760000000, Fields: map[string]interface{}{
+			"present": true,
+		}},
+		{Name: "kiosk_link", Tags: tags("interface", "eth0"), Timestamp: 1760000000, Fields: map[string]interface{}{
+			"link_up": true, "duplex_full": false, "speed_mbps": int64(100),
+		}},
+		{Name: "kiosk_service", Tags: tags("name", "kiosk-browser"), Timestamp: 1760000000, Fields: map[string]interface{}{
+			"running": false, "process_count": int64(0),
+		}},
+	})
+
+	for _, w := range []wantPoint{
+		{"kiosk_display", "width", nil, 1920},
+		{"kiosk_link", "link_up", map[string]interface{}{"interface": "eth0"}, 1},
+		{"kiosk_link", "duplex_full", map[string]interface{}{"interface": "eth0"}, 0},
+		{"kiosk_service", "running", map[string]interface{}{"name": "kiosk-browser"}, 0},
+	} {
+		p := findPoint(b, w.measurement, w.field, w.tags)
+		if p == nil {
+			t.Errorf("no %s/%s point", w.measurement, w.field)
+			continue
+		}
+		if got := pointValue(p); got != w.value {
+			t.Errorf("%s/%s = %v, want %v", w.measurement, w.field, got, w.value)
+		}
+	}
+	for _, w := range []wantPoint{{"kiosk_display", "connected", nil, 0}, {"kiosk_power", "present", nil, 0}} {
+		if p := findPoint(b, w.measurement, w.field, w.tags); p != nil {
+			t.Errorf("boolean %s/%s stored as a point", w.measurement, w.field)
+		}
+	}
+	if s := b.Summary.LinkState; s == nil || !s.LinkUp || s.DuplexFull {
+		t.Errorf("LinkState = %+v, want link up, half duplex", s)
+	}
+}
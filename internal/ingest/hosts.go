@@ -0,0 +1,53 @@
+package ingest
+
+import "metrics-api/internal/models"
+
+// HostGroup is the part of a payload that belongs to one kiosk.
+type HostGroup struct {
+	ServerID string
+	Host     string
+	Metrics  []models.Metric
+}
+
+// Identity returns the kiosk a metric belongs to: its server_id tag, or its
+// host tag when server_id is missing or the unexpanded "$HOSTNAME".
+func Identity(m models.Metric) (serverID, host string) {
+	host = m.Tags["host"]
+	serverID = m.Tags["server_id"]
+	if serverID == "" || serverID == "$HOSTNAME" {
+		serverID = host
+	}
+	return serverID, host
+}
+
+// SplitHosts partitions a payload by kiosk, so a Telegraf relay can forward
+// several kiosks in one request. Groups are in order of first appearance.
+// Metrics without identity tags go to the first group, which matches what a
+// single-kiosk payload has always done with them.
+func SplitHosts(metrics []models.Metric) []HostGroup {
+	var groups []HostGroup
+	index := make(map[string]int)
+	var anonymous []models.Metric
+	for _, m := range metrics {
+		serverID, host := Identity(m)
+		if serverID == "" {
+			anonymous = append(anonymous, m)
+			continue
+		}
+		i, ok := index[serverID]
+		if !ok {
+			i = len(groups)
+			index[serverID] = i
+			groups = append(groups, HostGroup{ServerID: serverID, Host: host})
+		}
+		groups[i].Metrics = append(groups[i].Metrics, m)
+	}
+
+	if len(anonymous) > 0 {
+		if len(groups) == 0 {
+			return []HostGroup{{Metrics: anonymous}}
+		}
+		groups[0].Metrics = append(groups[0].Metrics, anonymous...)
+	}
+	return groups
+}
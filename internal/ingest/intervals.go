@@ -0,0 +1,62 @@
+package ingest
+
+import (
+	"sort"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// SplitIntervals groups a payload's metrics by collection interval. Telegraf
+// flushes every interval it has buffered in one request, so a kiosk that was
+// offline sends many intervals at once; each needs its own summary row.
+//
+// Timestamps within width of the earliest timestamp of a group belong to that
+// group, which absorbs collection jitter between inputs of the same interval.
+// Metrics without a timestamp join the latest group. Groups are returned
+// oldest first and keep the payload order of their metrics.
+func SplitIntervals(metrics []models.Metric, width time.Duration) [][]models.Metric {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	var stamps []int64
+	seen := make(map[int64]bool)
+	for _, m := range metrics {
+		ts := int64(m.Timestamp)
+		if ts > 0 && !seen[ts] {
+			seen[ts] = true
+			stamps = append(stamps, ts)
+		}
+	}
+	if len(stamps) <= 1 {
+		return [][]models.Metric{metrics}
+	}
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i] < stamps[j] })
+
+	widthSec := int64(width / time.Second)
+	if widthSec < 1 {
+		widthSec = 1
+	}
+	group := make(map[int64]int, len(stamps))
+	start := stamps[0]
+	n := 0
+	for _, ts := range stamps {
+		if ts-start >= widthSec {
+			start = ts
+			n++
+		}
+		group[ts] = n
+	}
+
+	out := make([][]models.Metric, n+1)
+	for _, m := range metrics {
+		ts := int64(m.Timestamp)
+		if ts <= 0 {
+			out[n] = append(out[n], m)
+			continue
+		}
+		out[group[ts]] = append(out[group[ts]], m)
+	}
+	return out
+}
@@ -0,0 +1,447 @@
+package ingest
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// locationHandler copies the kiosk location tags (set by kiosk-tags.conf on
+// every kiosk_* measurement) into the summary; the first non-empty value wins.
+type locationHandler struct{}
+
+func (locationHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	cm := &b.Summary
+	if cm.City == "" && m.Tags["city"] != "" {
+		cm.City = m.Tags["city"]
+	}
+	if cm.CityName == "" && m.Tags["city_full_name"] != "" {
+		cm.CityName = m.Tags["city_full_name"]
+	}
+	if cm.Region == "" && m.Tags["code"] != "" {
+		cm.Region = m.Tags["code"]
+	}
+	if cm.RegionName == "" && m.Tags["name"] != "" {
+		cm.RegionName = m.Tags["name"]
+	}
+}
+
+// addAllFields emits one point per numeric field, preferring integers, and
+// marks those fields as used.
+func addAllFields(b *Batch, m models.Metric, t time.Time) {
+	addFieldsTagged(b, m, t, m.Tags)
+}
+
+// addFieldsTagged is addAllFields with the metric's tags replaced by tags.
+func addFieldsTagged(b *Batch, m models.Metric, t time.Time, tags map[string]string) {
+	for fieldName, raw := range m.Fields {
+		if iv, ok := ToInt64(raw); ok {
+			b.AddPoints(IntPoint(t, b.Summary.ServerID, m.Name, fieldName, iv, tags))
+			b.UseField(fieldName)
+			continue
+		}
+		if fv, ok := ToFloat64(raw); ok {
+			b.AddPoints(FloatPoint(t, b.Summary.ServerID, m.Name, fieldName, fv, tags))
+			b.UseField(fieldName)
+		}
+	}
+}
+
+// addFlagFields emits the named fields that hold booleans as 1/0 integer
+// points; addAllFields skips booleans, so numeric flags are not doubled.
+func addFlagFields(b *Batch, m models.Metric, t time.Time, names ...string) {
+	for _, name := range names {
+		raw, ok := m.Fields[name].(bool)
+		if !ok {
+			continue
+		}
+		v, _ := flagInt64(raw)
+		b.AddPoints(IntPoint(t, b.Summary.ServerID, m.Name, name, v, m.Tags))
+		b.UseField(name)
+	}
+}
+
+// withTag returns a copy of tags with key set to value.
+func withTag(tags map[string]string, key, value string) map[string]string {
+	out := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
+
+// displayHandler reports the most relevant output in the summary: connected
+// and primary beats connected, which beats primary, which beats anything else.
+type displayHandler struct {
+	captured bool
+	bestRank int
+}
+
+func newDisplayHandler() Handler {
+	return &displayHandler{bestRank: -1}
+}
+
+func (h *displayHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	isPrimary := false
+	if primaryVal, ok := ToInt64(b.Field(m, "primary")); ok && primaryVal != 0 {
+		isPrimary = true
+	}
+
+	rank := 0
+	if connectedVal, ok := ToInt64(b.Field(m, "connected")); ok && connectedVal != 0 {
+		rank = 1
+		if isPrimary {
+			rank = 3
+		}
+	} else if isPrimary {
+		rank = 2
+	}
+
+	if !h.captured || rank > h.bestRank {
+		cm := &b.Summary
+		connectedVal, _ := ToInt64(b.Field(m, "connected"))
+		cm.DisplayConnected = connectedVal != 0
+		cm.DisplayWidth, _ = ToInt64(b.Field(m, "width"))
+		cm.DisplayHeight, _ = ToInt64(b.Field(m, "height"))
+		cm.DisplayRefreshHz, _ = ToInt64(b.Field(m, "refresh_hz"))
+		dpmsVal, _ := ToInt64(b.Field(m, "dpms_enabled"))
+		cm.DisplayDpmsEnabled = dpmsVal != 0
+		cm.DisplayPrimary = isPrimary
+		h.captured = true
+		h.bestRank = rank
+	}
+
+	addAllFields(b, m, t)
+}
+
+// powerHandler takes the first battery and the first mains supply reading.
+type powerHandler struct {
+	batteryCaptured bool
+	onlineCaptured  bool
+}
+
+func newPowerHandler() Handler {
+	return &powerHandler{}
+}
+
+func (h *powerHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	cm := &b.Summary
+	if strings.ToLower(m.Tags["type"]) == "battery" {
+		if h.batteryCaptured {
+			b.SkipMetric(skipDuplicate)
+			return
+		}
+		if presentVal, ok := ToInt64(b.Field(m, "present")); ok {
+			cm.BatteryPresent = presentVal != 0
+		}
+		if chargeVal, ok := ToInt64(b.Field(m, "charge_percent")); ok {
+			cm.BatteryChargePct = chargeVal
+		}
+		if voltageVal, ok := ToInt64(b.Field(m, "voltage_mv")); ok {
+			cm.BatteryVoltageMV = voltageVal
+		}
+		if currentVal, ok := ToInt64(b.Field(m, "current_ma")); ok {
+			cm.BatteryCurrentMA = currentVal
+		}
+		h.batteryCaptured = true
+	} else {
+		if h.onlineCaptured {
+			b.SkipMetric(skipDuplicate)
+			return
+		}
+		if onlineVal, ok := ToInt64(b.Field(m, "online")); ok {
+			cm.PowerOnline = onlineVal != 0
+			h.onlineCaptured = true
+		}
+	}
+
+	addAllFields(b, m, t)
+}
+
+// inputHandler collects the input device inventory and presence counts. Each
+// device's fields are also emitted as series tagged with its identifier, plus
+// a present (0/1) series when the script reports presence under another
+// field name.
+type inputHandler struct {
+	devices []models.InputDevice
+	healthy int64
+	missing int64
+}
+
+func newInputHandler() Handler {
+	return &inputHandler{}
+}
+
+func (h *inputHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	device := models.InputDevice{
+		Source:  m.Tags["source"],
+		Name:    m.Tags["name"],
+		Vendor:  m.Tags["vendor"],
+		Product: m.Tags["product"],
+		Bus:     m.Tags["bus"],
+		Device:  m.Tags["device"],
+		Target:  m.Tags["target"],
+	}
+	device.Identifier = firstNonEmpty(m.Tags["id"], m.Tags["identifier"], device.Name, device.Target, device.Device)
+
+	present := false
+	if presentVal, ok := ToInt64(b.Field(m, "present")); ok {
+		present = presentVal != 0
+	} else if eventVal, ok := ToInt64(b.Field(m, "event_present")); ok {
+		present = eventVal != 0
+	} else if linkVal, ok := ToInt64(b.Field(m, "link_present")); ok {
+		present = linkVal != 0
+	}
+	device.Present = present
+	if present {
+		h.healthy++
+	} else {
+		h.missing++
+	}
+	h.devices = append(h.devices, device)
+
+	tags := m.Tags
+	if device.Identifier != "" {
+		tags = withTag(m.Tags, "identifier", device.Identifier)
+	}
+	addFieldsTagged(b, m, t, tags)
+	if _, ok := m.Fields["present"]; !ok {
+		var presentVal int64
+		if present {
+			presentVal = 1
+		}
+		b.AddPoints(IntPoint(t, b.Summary.ServerID, m.Name, "present", presentVal, tags))
+	}
+}
+
+func (h *inputHandler) Finish(b *Batch) {
+	b.Summary.InputDevicesHealthy = h.healthy
+	b.Summary.InputDevicesMissing = h.missing
+	if len(h.devices) > 0 {
+		b.Summary.InputDevices = h.devices
+	} else {
+		b.Summary.InputDevices = nil
+	}
+}
+
+// linkHandler merges every kiosk_link metric into a single link state and
+// emits each metric's fields as series tagged by interface and type.
+type linkHandler struct {
+	state *models.LinkState
+}
+
+func newLinkHandler() Handler {
+	return &linkHandler{}
+}
+
+func (h *linkHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	if h.state == nil {
+		h.state = &models.LinkState{}
+	}
+	s := h.state
+	if iface := m.Tags["interface"]; iface != "" {
+		s.Interface = iface
+	}
+	if lt := m.Tags["type"]; lt != "" {
+		s.Type = lt
+	}
+	if v, ok := flagInt64(b.Field(m, "link_up")); ok {
+		s.LinkUp = v != 0
+	}
+	if v, ok := ToInt64(b.Field(m, "speed_mbps")); ok {
+		s.SpeedMbps = v
+	}
+	if v, ok := flagInt64(b.Field(m, "duplex_full")); ok {
+		s.DuplexFull = v != 0
+	}
+	if v, ok := flagInt64(b.Field(m, "autoneg")); ok {
+		s.Autoneg = v != 0
+	}
+	if v, ok := ToInt64(b.Field(m, "rx_errors")); ok {
+		s.RxErrors = v
+	}
+	if v, ok := ToInt64(b.Field(m, "tx_errors")); ok {
+		s.TxErrors = v
+	}
+	if v, ok := ToInt64(b.Field(m, "rx_dropped")); ok {
+		s.RxDropped = v
+	}
+	if v, ok := ToInt64(b.Field(m, "tx_dropped")); ok {
+		s.TxDropped = v
+	}
+	if v, ok := ToInt64(b.Field(m, "signal_dbm")); ok {
+		s.SignalDbm = v
+	}
+	if v, ok := ToInt64(b.Field(m, "tx_bitrate_mbps")); ok {
+		s.TxBitrateMbps = v
+	}
+	if v, ok := ToInt64(b.Field(m, "rx_bitrate_mbps")); ok {
+		s.RxBitrateMbps = v
+	}
+
+	addFlagFields(b, m, t, "link_up", "duplex_full", "autoneg")
+	addAllFields(b, m, t)
+}
+
+func (h *linkHandler) Finish(b *Batch) {
+	b.Summary.LinkState = h.state
+}
+
+// serviceHandler tracks the watchdog's per-service status, keyed by name, and
+// emits running and process_count as series tagged by the service name.
+type serviceHandler struct {
+	statuses map[string]models.ProcessStatus
+}
+
+func newServiceHandler() Handler {
+	return &serviceHandler{statuses: make(map[string]models.ProcessStatus)}
+}
+
+func (h *serviceHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	name := strings.TrimSpace(m.Tags["name"])
+	if name == "" {
+		b.SkipMetric("no name tag")
+		return
+	}
+	status := h.statuses[name]
+	status.Name = name
+	if v, ok := flagInt64(b.Field(m, "running")); ok {
+		status.Running = v != 0
+	}
+	if v, ok := ToInt64(b.Field(m, "process_count")); ok {
+		status.ProcessCount = v
+	}
+	h.statuses[name] = status
+
+	addFlagFields(b, m, t, "running")
+	addAllFields(b, m, t)
+}
+
+func (h *serviceHandler) Finish(b *Batch) {
+	if len(h.statuses) == 0 {
+		return
+	}
+	names := make([]string, 0, len(h.statuses))
+	for name := range h.statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.Summary.ProcessStatuses = append(b.Summary.ProcessStatuses, h.statuses[name])
+	}
+}
+
+type hotspotHandler struct {
+	captured bool
+}
+
+func newHotspotHandler() Handler {
+	return &hotspotHandler{}
+}
+
+func (h *hotspotHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	if h.captured {
+		b.SkipMetric(skipDuplicate)
+		return
+	}
+	serverID := b.Summary.ServerID
+	if tempVal, ok := ToFloat64(b.Field(m, "temp_c")); ok {
+		b.Summary.HotspotTemperature = tempVal
+		h.captured = true
+		b.AddPoints(FloatPoint(t, serverID, "kiosk_hotspot", "temp_c", tempVal, m.Tags))
+	}
+
+	b.AddPoints(
+		seriesPointFloat(t, serverID, "cpu", "usage_user", b.Field(m, "usage_user"), m.Tags),
+		seriesPointFloat(t, serverID, "cpu", "usage_system", b.Field(m, "usage_system"), m.Tags),
+		seriesPointFloat(t, serverID, "cpu", "usage_iowait", b.Field(m, "usage_iowait"), m.Tags),
+		seriesPointFloat(t, serverID, "cpu", "usage_steal", b.Field(m, "usage_steal"), m.Tags),
+	)
+}
+
+type chassisHandler struct {
+	captured bool
+}
+
+func newChassisHandler() Handler {
+	return &chassisHandler{}
+}
+
+func (h *chassisHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	if h.captured {
+		b.SkipMetric(skipDuplicate)
+		return
+	}
+	if tempVal, ok := ToFloat64(b.Field(m, "temp_c")); ok {
+		b.Summary.ChassisTemperature = tempVal
+		h.captured = true
+		b.AddPoints(FloatPoint(t, b.Summary.ServerID, "kiosk_chassis", "temp_c", tempVal, m.Tags))
+	}
+}
+
+type fanHandler struct {
+	captured bool
+}
+
+func newFanHandler() Handler {
+	return &fanHandler{}
+}
+
+func (h *fanHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	if h.captured {
+		b.SkipMetric(skipDuplicate)
+		return
+	}
+	if rpmVal, ok := ToInt64(b.Field(m, "rpm")); ok {
+		b.Summary.FanRPM = rpmVal
+		h.captured = true
+		b.AddPoints(IntPoint(t, b.Summary.ServerID, "kiosk_fan", "rpm", rpmVal, m.Tags))
+	}
+}
+
+type volumeHandler struct {
+	captured bool
+}
+
+func newVolumeHandler() Handler {
+	return &volumeHandler{}
+}
+
+func (h *volumeHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	if h.captured {
+		b.SkipMetric(skipDuplicate)
+		return
+	}
+	level, ok := ToInt64(b.Field(m, "level_percent"))
+	if !ok {
+		return
+	}
+
+	cm := &b.Summary
+	cm.SoundVolumePercent = level
+	h.captured = true
+	if mutedVal, ok := ToInt64(b.Field(m, "muted")); ok {
+		cm.SoundMuted = mutedVal != 0
+	}
+
+	mutedInt := int64(0)
+	if cm.SoundMuted {
+		mutedInt = 1
+	}
+	b.AddPoints(
+		IntPoint(t, cm.ServerID, "kiosk_volume", "level_percent", level, m.Tags),
+		IntPoint(t, cm.ServerID, "kiosk_volume", "muted", mutedInt, m.Tags),
+	)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,275 @@
+package ingest
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"metrics-api/internal/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultMappingYAML is used when SERIES_MAPPING_FILE is not set.
+//
+//go:embed mapping.yaml
+var defaultMappingYAML []byte
+
+// Mapping declares which plain Telegraf fields become series points. It covers
+// the measurements whose fields are copied through one-to-one; measurements
+// that feed the summary row or need aggregation keep their Handler.
+type Mapping struct {
+	Measurements map[string]MeasurementMapping `yaml:"measurements" json:"measurements"`
+	// Passthrough stores the fields of measurements that have neither a
+	// Handler nor a Measurements entry.
+	Passthrough Passthrough `yaml:"passthrough" json:"passthrough"`
+	// TagPolicy limits the tags of every stored series point.
+	TagPolicy TagPolicy `yaml:"tag_policy" json:"tag_policy"`
+	// Counters lists the series that are cumulative counters, as
+	// "measurement/field" globs. Only these can be queried with fn=rate.
+	Counters []string `yaml:"counters" json:"counters"`
+}
+
+// Passthrough selects fields of otherwise unknown measurements by glob
+// pattern. A pattern is "measurement" (every field) or "measurement/field",
+// matched with path.Match, so "nginx" and "nginx/*" are equivalent and
+// "*/*_bytes" matches a field suffix in any measurement. A field is stored
+// when it matches an Allow pattern and no Deny pattern; with no Allow
+// patterns nothing passes through.
+type Passthrough struct {
+	Allow []string `yaml:"allow" json:"allow"`
+	Deny  []string `yaml:"deny" json:"deny"`
+}
+
+// MeasurementMapping lists the series fields for one measurement and the tags
+// stored with them. A nil Tags list keeps every tag; an empty list keeps none.
+type MeasurementMapping struct {
+	Fields []FieldMapping `yaml:"fields" json:"fields"`
+	Tags   []string       `yaml:"tags" json:"tags"`
+}
+
+// FieldMapping turns one Telegraf field into a series field.
+type FieldMapping struct {
+	// Field is the Telegraf field name.
+	Field string `yaml:"field" json:"field"`
+	// As renames the series field; defaults to Field.
+	As string `yaml:"as" json:"as"`
+	// Type is "float" (default) or "int".
+	Type string `yaml:"type" json:"type"`
+	// Scale multiplies the value before storing, e.g. 1048576 for MiB to bytes.
+	Scale float64 `yaml:"scale" json:"scale"`
+}
+
+// DefaultMapping returns the mapping compiled into the binary.
+func DefaultMapping() (*Mapping, error) {
+	return parseMapping(defaultMappingYAML, false)
+}
+
+// LoadMapping reads a mapping file. Files ending in .json are decoded as JSON,
+// everything else as YAML.
+func LoadMapping(path string) (*Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseMapping(data, strings.EqualFold(filepath.Ext(path), ".json"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func parseMapping(data []byte, isJSON bool) (*Mapping, error) {
+	var m Mapping
+	var err error
+	if isJSON {
+		err = json.Unmarshal(data, &m)
+	} else {
+		err = yaml.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *Mapping) validate() error {
+	if err := m.TagPolicy.validate(); err != nil {
+		return err
+	}
+	for _, list := range [][]string{m.Passthrough.Allow, m.Passthrough.Deny} {
+		for _, pattern := range list {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("passthrough pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	for _, pattern := range m.Counters {
+		if !strings.Contains(pattern, "/") {
+			return fmt.Errorf("counter pattern %q: want measurement/field", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("counter pattern %q: %w", pattern, err)
+		}
+	}
+	for name, mm := range m.Measurements {
+		for i, f := range mm.Fields {
+			if f.Field == "" {
+				return fmt.Errorf("measurement %q: field #%d has no name", name, i+1)
+			}
+			switch f.Type {
+			case "", "float", "int":
+			default:
+				return fmt.Errorf("measurement %q field %q: unknown type %q (want int or float)", name, f.Field, f.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// apply emits the mapped series points for one metric and reports whether the
+// mapping covers the measurement.
+func (m *Mapping) apply(b *Batch, metric models.Metric, t time.Time) bool {
+	if m == nil {
+		return false
+	}
+	mm, ok := m.Measurements[metric.Name]
+	if !ok {
+		return false
+	}
+
+	tags := metric.Tags
+	if mm.Tags != nil {
+		tags = make(map[string]string, len(mm.Tags))
+		for _, k := range mm.Tags {
+			if v, ok := metric.Tags[k]; ok {
+				tags[k] = v
+			}
+		}
+	}
+
+	for _, f := range mm.Fields {
+		raw, ok := metric.Fields[f.Field]
+		if !ok {
+			continue
+		}
+		b.UseField(f.Field)
+		name := f.As
+		if name == "" {
+			name = f.Field
+		}
+
+		if f.Type == "int" {
+			if f.Scale == 0 {
+				if v, ok := ToInt64(raw); ok {
+					b.AddPoints(IntPoint(t, b.Summary.ServerID, metric.Name, name, v, tags))
+				}
+				continue
+			}
+			if v, ok := ToFloat64(raw); ok {
+				b.AddPoints(IntPoint(t, b.Summary.ServerID, metric.Name, name, int64(math.Round(v*f.Scale)), tags))
+			}
+			continue
+		}
+
+		if v, ok := ToFloat64(raw); ok {
+			if f.Scale != 0 {
+				v *= f.Scale
+			}
+			b.AddPoints(FloatPoint(t, b.Summary.ServerID, metric.Name, name, v, tags))
+		}
+	}
+	return true
+}
+
+// passthrough emits the fields of an unhandled metric that the Passthrough
+// patterns allow, keeping every tag and the value's type: integers (line
+// protocol "i"/"u" fields, JSON numbers without a fraction or exponent) are
+// stored as value_int, everything else numeric as value_double. Booleans and
+// strings are skipped. It reports whether any point was emitted; the numeric
+// fields left out by the patterns are then recorded as dropped, and the
+// report lists the skipped booleans and strings.
+func (m *Mapping) passthrough(b *Batch, metric models.Metric, t time.Time) bool {
+	if m == nil || len(m.Passthrough.Allow) == 0 {
+		return false
+	}
+
+	type skipped struct{ field, reason string }
+	var skips []skipped
+	emitted := false
+	for field, raw := range metric.Fields {
+		allowed := matchAny(m.Passthrough.Allow, metric.Name, field)
+		denied := allowed && matchAny(m.Passthrough.Deny, metric.Name, field)
+		if !allowed || denied {
+			if _, ok := ToFloat64(raw); ok {
+				reason := "not in passthrough allowlist"
+				if denied {
+					reason = "passthrough denylist"
+				}
+				skips = append(skips, skipped{field, reason})
+				b.UseField(field)
+			}
+			continue
+		}
+
+		var p models.SeriesPoint
+		switch v := raw.(type) {
+		case int64:
+			p = IntPoint(t, b.Summary.ServerID, metric.Name, field, v, metric.Tags)
+		case int:
+			p = IntPoint(t, b.Summary.ServerID, metric.Name, field, int64(v), metric.Tags)
+		case json.Number:
+			if iv, err := v.Int64(); err == nil && !strings.ContainsAny(string(v), ".eE") {
+				p = IntPoint(t, b.Summary.ServerID, metric.Name, field, iv, metric.Tags)
+			} else if fv, err := v.Float64(); err == nil {
+				p = FloatPoint(t, b.Summary.ServerID, metric.Name, field, fv, metric.Tags)
+			} else {
+				continue
+			}
+		case float64:
+			p = FloatPoint(t, b.Summary.ServerID, metric.Name, field, v, metric.Tags)
+		case float32:
+			p = FloatPoint(t, b.Summary.ServerID, metric.Name, field, float64(v), metric.Tags)
+		default:
+			continue
+		}
+		b.AddPoints(p)
+		b.UseField(field)
+		emitted = true
+	}
+
+	if emitted {
+		for _, s := range skips {
+			b.DropField(metric.Name, s.field, s.reason)
+		}
+	}
+	return emitted
+}
+
+// matchAny reports whether measurement/field matches one of patterns.
+// IsCounter reports whether the series is declared a cumulative counter.
+func (m *Mapping) IsCounter(measurement, field string) bool {
+	return m != nil && matchAny(m.Counters, measurement, field)
+}
+
+func matchAny(patterns []string, measurement, field string) bool {
+	for _, pattern := range patterns {
+		name := measurement
+		if strings.Contains(pattern, "/") {
+			name = measurement + "/" + field
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package ingest
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The embedded mapping must not change what existing deployments store, so
+// it ships without tag limits.
+func TestDefaultMappingTagPolicyDisabled(t *testing.T) {
+	m, err := DefaultMapping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.TagPolicy, TagPolicy{}) {
+		t.Errorf("default TagPolicy = %+v, want every limit disabled", m.TagPolicy)
+	}
+	if m.TagPolicy.Active() {
+		t.Error("default TagPolicy is active")
+	}
+	tags := map[string]interface{}{"host": " Kiosk-1 ", "interface": "WLAN0"}
+	if c := m.TagPolicy.Apply("net", tags); c != (TagChanges{}) {
+		t.Errorf("Apply changes = %+v, want none", c)
+	}
+	want := map[string]interface{}{"host": " Kiosk-1 ", "interface": "WLAN0"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("Apply changed tags to %v, want %v", tags, want)
+	}
+}
+
+func TestDefaultMappingCounters(t *testing.T) {
+	m, err := DefaultMapping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		measurement, field string
+		want               bool
+	}{
+		{"net", "bytes_recv", true},
+		{"net", "bytes_sent", true},
+		{"diskio", "write_bytes", true},
+		{"swap", "in", true},
+		{"mem", "used_percent", false},
+		{"diskio", "io_util", false},
+		{"vnstat_daily", "rx_bytes", false},
+		{"environment", "temperature_c", false},
+	}
+	for _, tt := range tests {
+		if got := m.IsCounter(tt.measurement, tt.field); got != tt.want {
+			t.Errorf("IsCounter(%s, %s) = %v, want %v", tt.measurement, tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestMappingCounterPatterns(t *testing.T) {
+	for _, data := range []string{"counters: [net]", "counters: [\"net/[\"]"} {
+		if _, err := parseMapping([]byte(data), false); err == nil {
+			t.Errorf("parseMapping(%q) accepted an invalid counter pattern", data)
+		}
+	}
+	m, err := parseMapping([]byte(`counters: ["nginx/*_total"]`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsCounter("nginx", "requests_total") || m.IsCounter("nginx", "active") {
+		t.Error("counter glob did not match by field suffix")
+	}
+}
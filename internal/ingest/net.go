@@ -0,0 +1,104 @@
+package ingest
+
+import (
+	"strings"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// netHandler keeps per-interface byte counters (loopback excluded) and sums
+// them into the summary totals.
+type netHandler struct {
+	saw       bool
+	bytesSent int64
+	bytesRecv int64
+}
+
+func newNetHandler() Handler {
+	return &netHandler{}
+}
+
+func (h *netHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	h.saw = true
+	iface := m.Tags["interface"]
+	if iface == "" || strings.HasPrefix(iface, "lo") {
+		b.SkipMetric("loopback or unnamed interface")
+		return
+	}
+
+	serverID := b.Summary.ServerID
+	if v, ok := ToFloat64(b.Field(m, "bytes_sent")); ok {
+		value := int64(v)
+		h.bytesSent += value
+		b.AddPoints(IntPoint(t, serverID, "net", "bytes_sent", value, m.Tags))
+	}
+	if v, ok := ToFloat64(b.Field(m, "bytes_recv")); ok {
+		value := int64(v)
+		h.bytesRecv += value
+		b.AddPoints(IntPoint(t, serverID, "net", "bytes_recv", value, m.Tags))
+	}
+}
+
+func (h *netHandler) Finish(b *Batch) {
+	cm := &b.Summary
+	cm.NetBytesSent = h.bytesSent
+	cm.NetBytesRecv = h.bytesRecv
+	if h.bytesSent > 0 || h.bytesRecv > 0 {
+		ns := h.bytesSent
+		nr := h.bytesRecv
+		b.AddPoints(
+			models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "net", Field: "bytes_sent_total", ValueInt: &ns, TagsJSON: aggregatedTags},
+			models.SeriesPoint{Time: cm.Time, ServerID: cm.ServerID, Measurement: "net", Field: "bytes_recv_total", ValueInt: &nr, TagsJSON: aggregatedTags},
+		)
+	}
+
+	if !h.saw {
+		b.Note("net measurement missing")
+	} else if h.bytesSent == 0 && h.bytesRecv == 0 {
+		b.Note("net measurement reported zero bytes (check interfaces)")
+	}
+}
+
+// vnstatHandler converts the vnstat helper's MiB totals into bytes for either
+// the daily or the monthly summary columns; the first usable metric wins.
+type vnstatHandler struct {
+	measurement string
+	captured    bool
+}
+
+func newVnstatHandler(measurement string) Factory {
+	return func() Handler {
+		return &vnstatHandler{measurement: measurement}
+	}
+}
+
+func (h *vnstatHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	if h.captured {
+		b.SkipMetric(skipDuplicate)
+		return
+	}
+	b.UseField("rx_mib", "tx_mib")
+	rxBytes, rxOK := mibFieldToBytes(m.Fields, "rx_mib")
+	txBytes, txOK := mibFieldToBytes(m.Fields, "tx_mib")
+	if !rxOK && !txOK {
+		return
+	}
+
+	cm := &b.Summary
+	rx, tx := &cm.NetDailyRxBytes, &cm.NetDailyTxBytes
+	if h.measurement == "vnstat_monthly" {
+		rx, tx = &cm.NetMonthlyRxBytes, &cm.NetMonthlyTxBytes
+	}
+	if rxOK {
+		*rx = rxBytes
+	}
+	if txOK {
+		*tx = txBytes
+	}
+	h.captured = true
+	b.AddPoints(
+		IntPoint(t, cm.ServerID, h.measurement, "rx_bytes", *rx, m.Tags),
+		IntPoint(t, cm.ServerID, h.measurement, "tx_bytes", *tx, m.Tags),
+	)
+}
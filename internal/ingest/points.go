@@ -0,0 +1,44 @@
+package ingest
+
+import (
+	"encoding/json"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// aggregatedTags marks summary series computed across devices/interfaces.
+var aggregatedTags = []byte(`{"aggregated":true}`)
+
+func seriesPointFloat(t time.Time, serverID, measurement, field string, raw interface{}, tags map[string]string) models.SeriesPoint {
+	var vPtr *float64
+	if v, ok := ToFloat64(raw); ok {
+		vv := v
+		vPtr = &vv
+	}
+	jb, _ := json.Marshal(tags)
+	return models.SeriesPoint{Time: t, ServerID: serverID, Measurement: measurement, Field: field, ValueDouble: vPtr, TagsJSON: jb}
+}
+
+// IntPoint builds an integer series point.
+func IntPoint(t time.Time, serverID, measurement, field string, value int64, tags map[string]string) models.SeriesPoint {
+	vv := value
+	return models.SeriesPoint{Time: t, ServerID: serverID, Measurement: measurement, Field: field, ValueInt: &vv, TagsJSON: MustJSON(tags)}
+}
+
+// FloatPoint builds a floating point series point.
+func FloatPoint(t time.Time, serverID, measurement, field string, value float64, tags map[string]string) models.SeriesPoint {
+	vv := value
+	return models.SeriesPoint{Time: t, ServerID: serverID, Measurement: measurement, Field: field, ValueDouble: &vv, TagsJSON: MustJSON(tags)}
+}
+
+// MustJSON encodes a tag map, falling back to an empty object.
+func MustJSON(tags map[string]string) []byte {
+	if tags == nil {
+		return []byte(`{}`)
+	}
+	if jb, err := json.Marshal(tags); err == nil {
+		return jb
+	}
+	return []byte(`{}`)
+}
@@ -0,0 +1,113 @@
+// Package ingest turns a Telegraf metric stream into the server_metrics
+// summary row and the metric_points series for one payload.
+//
+// Each measurement family is handled by a Handler registered by exact
+// measurement name or by name prefix. A fresh set of handlers is created for
+// every payload, so handlers may keep per-payload state (first-wins flags,
+// running totals) in their own fields and fold it into the summary in Finish.
+package ingest
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// Handler consumes the metrics of one measurement family. t is the metric
+// timestamp the handler should use for any series points it emits.
+type Handler interface {
+	Handle(b *Batch, m models.Metric, t time.Time)
+}
+
+// Finisher is implemented by handlers that need to fold accumulated state
+// into the batch once every metric of the payload has been handled.
+type Finisher interface {
+	Finish(b *Batch)
+}
+
+// Factory creates the per-payload instance of a handler.
+type Factory func() Handler
+
+type registration struct {
+	names   []string
+	prefix  bool
+	factory Factory
+}
+
+func (r registration) matches(name string) bool {
+	for _, n := range r.names {
+		if r.prefix && strings.HasPrefix(name, n) {
+			return true
+		}
+		if !r.prefix && n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry maps measurement names onto handler factories. Every matching
+// registration runs, in registration order, so a prefix handler can sit next
+// to exact-name handlers for the same measurement. The series mapping is
+// applied after the handlers and can be swapped while payloads are in flight.
+type Registry struct {
+	registrations []registration
+	mapping       atomic.Pointer[Mapping]
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a handler for the measurements with exactly these names. A
+// single handler instance per payload serves all of them.
+func (r *Registry) Register(factory Factory, names ...string) {
+	r.registrations = append(r.registrations, registration{names: names, factory: factory})
+}
+
+// RegisterPrefix adds a handler for every measurement starting with one of
+// the prefixes.
+func (r *Registry) RegisterPrefix(factory Factory, prefixes ...string) {
+	r.registrations = append(r.registrations, registration{names: prefixes, prefix: true, factory: factory})
+}
+
+// SetMapping replaces the series mapping. Batches already started keep the
+// mapping they were created with.
+func (r *Registry) SetMapping(m *Mapping) {
+	r.mapping.Store(m)
+}
+
+// Mapping returns the current series mapping, or nil if none is set.
+func (r *Registry) Mapping() *Mapping {
+	return r.mapping.Load()
+}
+
+// Handles reports whether any registration or the series mapping covers the
+// measurement name.
+func (r *Registry) Handles(name string) bool {
+	for _, reg := range r.registrations {
+		if reg.matches(name) {
+			return true
+		}
+	}
+	if m := r.mapping.Load(); m != nil {
+		_, ok := m.Measurements[name]
+		return ok
+	}
+	return false
+}
+
+// NewBatch returns an empty batch with fresh handler instances.
+func (r *Registry) NewBatch() *Batch {
+	b := &Batch{
+		registry: r,
+		mapping:  r.mapping.Load(),
+		handlers: make([]Handler, len(r.registrations)),
+	}
+	for i, reg := range r.registrations {
+		b.handlers[i] = reg.factory()
+	}
+	return b
+}
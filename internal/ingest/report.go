@@ -0,0 +1,210 @@
+package ingest
+
+import (
+	"fmt"
+	"sort"
+
+	"metrics-api/internal/models"
+)
+
+// Report summarises what happened to one payload. Ingest returns it when the
+// client asks for it, so a freshly installed kiosk can be checked with curl.
+type Report struct {
+	ServerID string `json:"server_id"`
+	Metrics  int    `json:"metrics"`
+	// Intervals is the number of summary rows the payload produced.
+	Intervals int `json:"intervals"`
+	// Accepted and Ignored count metrics per measurement name. Ignored
+	// metrics were consumed by no handler, mapping entry or passthrough
+	// pattern.
+	Accepted      map[string]int `json:"accepted_measurements"`
+	Ignored       map[string]int `json:"ignored_measurements"`
+	DroppedFields []DroppedField `json:"dropped_fields"`
+	Warnings      []string       `json:"warnings"`
+	PointsBuilt   int            `json:"points_built"`
+	// PointsQueued and PointsDropped are filled in by the HTTP layer once the
+	// points have been handed to the writer.
+	PointsQueued  int `json:"points_queued"`
+	PointsDropped int `json:"points_dropped"`
+}
+
+// DroppedField is a field of an accepted measurement that could not be used.
+type DroppedField struct {
+	Measurement string `json:"measurement"`
+	Field       string `json:"field"`
+	Reason      string `json:"reason"`
+	Count       int    `json:"count"`
+}
+
+type dropKey struct {
+	measurement, field, reason string
+}
+
+// DropField records that a field of measurement was not used. Repeated drops
+// of the same field for the same reason are counted, not listed twice.
+func (b *Batch) DropField(measurement, field, reason string) {
+	if b.drops == nil {
+		b.drops = make(map[dropKey]int)
+	}
+	key := dropKey{measurement, field, reason}
+	if _, ok := b.drops[key]; !ok {
+		b.dropOrder = append(b.dropOrder, key)
+	}
+	b.drops[key]++
+}
+
+// Field returns a field of the metric being added and marks it as used, so
+// the report does not list it as dropped. Handlers read fields through it.
+func (b *Batch) Field(m models.Metric, name string) interface{} {
+	b.UseField(name)
+	return m.Fields[name]
+}
+
+// SkipMetric records that a handler consumed the metric being added but
+// deliberately used none of its fields, such as a repeated reading after the
+// first one was kept. Fields nothing else used are dropped with reason.
+func (b *Batch) SkipMetric(reason string) {
+	b.skipReason = reason
+}
+
+// skipDuplicate is the skip reason of first-wins handlers.
+const skipDuplicate = "duplicate reading, the first one in the interval is kept"
+
+// UseField marks fields of the metric being added as used.
+func (b *Batch) UseField(names ...string) {
+	if b.used == nil {
+		b.used = make(map[string]struct{})
+	}
+	for _, name := range names {
+		b.used[name] = struct{}{}
+	}
+}
+
+// trackMetric records whether a metric was accepted and drops the fields of
+// an accepted metric that nothing consumed: with the handler's skip reason,
+// as non-numeric values, which nothing downstream can store, or as numeric
+// fields no handler or mapping used.
+func (b *Batch) trackMetric(m models.Metric, consumed bool) {
+	b.metrics++
+	if m.Tags["host"] == "" && (m.Tags["server_id"] == "" || m.Tags["server_id"] == "$HOSTNAME") {
+		b.anonymous++
+	}
+
+	if b.accepted == nil {
+		b.accepted = make(map[string]int)
+		b.ignored = make(map[string]int)
+	}
+	if !consumed {
+		b.ignored[m.Name]++
+		return
+	}
+	b.accepted[m.Name]++
+	for field, raw := range m.Fields {
+		if _, ok := b.used[field]; ok {
+			continue
+		}
+		if b.skipReason != "" {
+			b.DropField(m.Name, field, b.skipReason)
+			continue
+		}
+		if _, ok := ToFloat64(raw); !ok {
+			b.DropField(m.Name, field, fmt.Sprintf("non-numeric value (%T)", raw))
+			continue
+		}
+		b.DropField(m.Name, field, "not used by any handler or mapping")
+	}
+}
+
+// Report returns the report for the metrics added so far. Call it after
+// Finish so handler notes are included.
+func (b *Batch) Report() *Report {
+	rep := &Report{
+		ServerID:      b.Summary.ServerID,
+		Metrics:       b.metrics,
+		Intervals:     1,
+		Accepted:      b.accepted,
+		Ignored:       b.ignored,
+		DroppedFields: []DroppedField{},
+		Warnings:      append([]string{}, b.Notes...),
+		PointsBuilt:   len(b.Points),
+	}
+	if rep.Accepted == nil {
+		rep.Accepted = map[string]int{}
+	}
+	if rep.Ignored == nil {
+		rep.Ignored = map[string]int{}
+	}
+	for _, key := range b.dropOrder {
+		rep.DroppedFields = append(rep.DroppedFields, DroppedField{
+			Measurement: key.measurement,
+			Field:       key.field,
+			Reason:      key.reason,
+			Count:       b.drops[key],
+		})
+	}
+	sortDroppedFields(rep.DroppedFields)
+
+	if b.Summary.ServerID == "" {
+		rep.Warnings = append(rep.Warnings, "no metric carries a server_id or host tag; nothing can be attributed to a kiosk")
+	} else if b.anonymous > 0 {
+		rep.Warnings = append(rep.Warnings, fmt.Sprintf("metrics without a server_id or host tag: %d", b.anonymous))
+	}
+	return rep
+}
+
+// Merge folds the report of another interval of the same payload into r.
+func (r *Report) Merge(o *Report) {
+	if r.ServerID == "" {
+		r.ServerID = o.ServerID
+	}
+	r.Metrics += o.Metrics
+	r.Intervals += o.Intervals
+	for name, n := range o.Accepted {
+		r.Accepted[name] += n
+	}
+	for name, n := range o.Ignored {
+		r.Ignored[name] += n
+	}
+
+	for _, df := range o.DroppedFields {
+		merged := false
+		for i := range r.DroppedFields {
+			cur := &r.DroppedFields[i]
+			if cur.Measurement == df.Measurement && cur.Field == df.Field && cur.Reason == df.Reason {
+				cur.Count += df.Count
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			r.DroppedFields = append(r.DroppedFields, df)
+		}
+	}
+	sortDroppedFields(r.DroppedFields)
+
+	for _, w := range o.Warnings {
+		dup := false
+		for _, cur := range r.Warnings {
+			if cur == w {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			r.Warnings = append(r.Warnings, w)
+		}
+	}
+
+	r.PointsBuilt += o.PointsBuilt
+	r.PointsQueued += o.PointsQueued
+	r.PointsDropped += o.PointsDropped
+}
+
+func sortDroppedFields(fields []DroppedField) {
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].Measurement != fields[j].Measurement {
+			return fields[i].Measurement < fields[j].Measurement
+		}
+		return fields[i].Field < fields[j].Field
+	})
+}
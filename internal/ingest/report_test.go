@@ -0,0 +1,113 @@
+package ingest
+
+import (
+	"reflect"
+	"testing"
+
+	"metrics-api/internal/models"
+)
+
+func TestReportDroppedFields(t *testing.T) {
+	tags := map[string]string{"server_id": "kiosk-0042"}
+	metrics := []models.Metric{
+		// The handler uses temp_input; temp_crit and temp_max are not stored.
+		{Name: "sensors", Tags: tags, Timestamp: 1760000000, Fields: map[string]interface{}{
+			"temp_input": 52.5, "temp_crit": 100.0, "temp_max": 84.0,
+		}},
+		// total and used feed both the summary and the mapping, available
+		// only neither.
+		{Name: "mem", Tags: tags, Timestamp: 1760000000, Fields: map[string]interface{}{
+			"total": 4.0e9, "used": 1.0e9, "used_percent": 25.0, "available": 3.0e9,
+		}},
+		// addAllFields stores every numeric field; strings are dropped.
+		{Name: "kiosk_display", Tags: tags, Timestamp: 1760000000, Fields: map[string]interface{}{
+			"connected": 1.0, "width": 1920.0, "output": "HDMI-1",
+		}},
+		// Per-core cpu metrics are neither summarised nor mapped.
+		{Name: "cpu", Tags: map[string]string{"server_id": "kiosk-0042", "cpu": "cpu0"}, Timestamp: 1760000000, Fields: map[string]interface{}{
+			"usage_idle": 90.0,
+		}},
+		{Name: "cpu", Tags: map[string]string{"server_id": "kiosk-0042", "cpu": "cpu-total"}, Timestamp: 1760000000, Fields: map[string]interface{}{
+			"usage_idle": 80.0,
+		}},
+	}
+
+	rep := NewDefaultRegistry().Build(metrics).Report()
+	want := []DroppedField{
+		{Measurement: "cpu", Field: "usage_idle", Reason: "per-core cpu, only cpu-total is summarised", Count: 1},
+		{Measurement: "kiosk_display", Field: "output", Reason: "non-numeric value (string)", Count: 1},
+		{Measurement: "mem", Field: "available", Reason: "not used by any handler or mapping", Count: 1},
+		{Measurement: "sensors", Field: "temp_crit", Reason: "not used by any handler or mapping", Count: 1},
+		{Measurement: "sensors", Field: "temp_max", Reason: "not used by any handler or mapping", Count: 1},
+	}
+	if !reflect.DeepEqual(rep.DroppedFields, want) {
+		t.Errorf("DroppedFields =\n%+v\nwant\n%+v", rep.DroppedFields, want)
+	}
+}
+
+// TestReportSkippedMetrics checks that metrics a handler consumed but skipped
+// report the handler's reason, and that matching only the kiosk_ location
+// prefix does not make a measurement accepted.
+func TestReportSkippedMetrics(t *testing.T) {
+	tags := map[string]string{"server_id": "kiosk-0042", "city": "nyc"}
+	hotspot := func(temp float64) models.Metric {
+		return models.Metric{Name: "kiosk_hotspot", Tags: tags, Timestamp: 1760000000, Fields: map[string]interface{}{
+			"temp_c": temp, "usage_user": 10.0,
+		}}
+	}
+	rep := NewDefaultRegistry().Build([]models.Metric{
+		hotspot(61.5),
+		hotspot(62.0),
+		{Name: "kiosk_fan", Tags: tags, Timestamp: 1760000000, Fields: map[string]interface{}{"rpm": 2100.0}},
+		{Name: "kiosk_fan", Tags: tags, Timestamp: 1760000000, Fields: map[string]interface{}{"rpm": 2200.0}},
+		{Name: "kiosk_unknown", Tags: tags, Timestamp: 1760000000, Fields: map[string]interface{}{"value": 1.0}},
+		{Name: "net", Tags: map[string]string{"server_id": "kiosk-0042", "interface": "lo"}, Timestamp: 1760000000, Fields: map[string]interface{}{
+			"bytes_recv": 10.0,
+		}},
+	}).Report()
+
+	wantAccepted := map[string]int{"kiosk_hotspot": 2, "kiosk_fan": 2, "net": 1}
+	if !reflect.DeepEqual(rep.Accepted, wantAccepted) {
+		t.Errorf("Accepted = %v, want %v", rep.Accepted, wantAccepted)
+	}
+	if want := map[string]int{"kiosk_unknown": 1}; !reflect.DeepEqual(rep.Ignored, want) {
+		t.Errorf("Ignored = %v, want %v", rep.Ignored, want)
+	}
+	want := []DroppedField{
+		{Measurement: "kiosk_fan", Field: "rpm", Reason: skipDuplicate, Count: 1},
+		{Measurement: "kiosk_hotspot", Field: "temp_c", Reason: skipDuplicate, Count: 1},
+		{Measurement: "kiosk_hotspot", Field: "usage_user", Reason: skipDuplicate, Count: 1},
+		{Measurement: "net", Field: "bytes_recv", Reason: "loopback or unnamed interface", Count: 1},
+	}
+	if !reflect.DeepEqual(rep.DroppedFields, want) {
+		t.Errorf("DroppedFields =\n%+v\nwant\n%+v", rep.DroppedFields, want)
+	}
+}
+
+func TestReportPassthroughDrops(t *testing.T) {
+	m, err := parseMapping([]byte(`passthrough:
+  allow: [nginx]
+  deny: ["nginx/*_ts"]
+`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	r.SetMapping(m)
+
+	rep := r.Build([]models.Metric{{
+		Name:      "nginx",
+		Tags:      map[string]string{"server_id": "kiosk-0042"},
+		Timestamp: 1760000000,
+		Fields:    map[string]interface{}{"requests": int64(10), "start_ts": int64(1), "version": "1.25", "state": "up"},
+	}}).Report()
+	want := []DroppedField{
+		{Measurement: "nginx", Field: "start_ts", Reason: "passthrough denylist", Count: 1},
+		{Measurement: "nginx", Field: "state", Reason: "non-numeric value (string)", Count: 1},
+		// Passthrough stores numbers only, not numeric strings.
+		{Measurement: "nginx", Field: "version", Reason: "not used by any handler or mapping", Count: 1},
+	}
+	if !reflect.DeepEqual(rep.DroppedFields, want) {
+		t.Errorf("DroppedFields =\n%+v\nwant\n%+v", rep.DroppedFields, want)
+	}
+}
@@ -0,0 +1,50 @@
+package ingest
+
+import (
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// cpuHandler derives summary CPU usage from the cpu-total series.
+type cpuHandler struct{}
+
+func (cpuHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	if m.Tags["cpu"] != "cpu-total" {
+		b.SkipMetric("per-core cpu, only cpu-total is summarised")
+		return
+	}
+	if b.Summary.CPU != 0 {
+		b.SkipMetric(skipDuplicate)
+		return
+	}
+	if v, ok := ToFloat64(b.Field(m, "usage_idle")); ok {
+		b.Summary.CPU = 100 - v
+	}
+}
+
+// memHandler fills the summary memory columns; the mem series come from the
+// mapping.
+type memHandler struct{}
+
+func (memHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	cm := &b.Summary
+	if v, ok := ToFloat64(b.Field(m, "available_percent")); ok {
+		cm.Memory = 100 - v
+	}
+	if v, ok := ToFloat64(b.Field(m, "total")); ok {
+		cm.MemoryTotalBytes = int64(v)
+	}
+	if v, ok := ToFloat64(b.Field(m, "used")); ok {
+		cm.MemoryUsedBytes = int64(v)
+	}
+}
+
+// systemHandler fills the summary uptime.
+type systemHandler struct{}
+
+func (systemHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	if v, ok := ToFloat64(b.Field(m, "uptime")); ok {
+		b.Summary.Uptime = int64(v)
+	}
+}
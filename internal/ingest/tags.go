@@ -0,0 +1,102 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// TagPolicy bounds the tags stored with series points. It is part of the
+// mapping, so it reloads with it on SIGHUP. Zero values disable a limit.
+type TagPolicy struct {
+	// Lowercase lowercases tag values. While any part of the policy is
+	// set, values are also trimmed.
+	Lowercase bool `yaml:"lowercase" json:"lowercase"`
+	// MaxValueLength truncates longer tag values (in characters).
+	MaxValueLength int `yaml:"max_value_length" json:"max_value_length"`
+	// MaxTagSets is how many distinct tag sets one server may store per
+	// series (measurement and field); points with further tag sets are
+	// rejected.
+	MaxTagSets int `yaml:"max_tag_sets" json:"max_tag_sets"`
+	// Tags lists the tags kept per measurement; other tags are removed.
+	// Measurements not listed keep all their tags.
+	Tags map[string][]string `yaml:"tags" json:"tags"`
+}
+
+// TagChanges counts what Apply did to one tag set. Trimming and lowercasing
+// are normalisation and not counted.
+type TagChanges struct {
+	Removed   int
+	Truncated int
+}
+
+// Active reports whether any part of the policy is set. An inactive policy
+// leaves tags exactly as received.
+func (p *TagPolicy) Active() bool {
+	return p != nil && (p.Lowercase || p.MaxValueLength > 0 || p.MaxTagSets > 0 || len(p.Tags) > 0)
+}
+
+func (p TagPolicy) validate() error {
+	if p.MaxValueLength < 0 {
+		return fmt.Errorf("tag_policy: max_value_length must not be negative")
+	}
+	if p.MaxTagSets < 0 {
+		return fmt.Errorf("tag_policy: max_tag_sets must not be negative")
+	}
+	return nil
+}
+
+// Apply enforces the policy on the tags of one point of measurement, editing
+// tags in place. Only string values are normalised; others (such as
+// "aggregated": true) are kept as they are.
+func (p *TagPolicy) Apply(measurement string, tags map[string]interface{}) TagChanges {
+	var c TagChanges
+	if !p.Active() {
+		return c
+	}
+	if allowed, ok := p.Tags[measurement]; ok {
+		for k := range tags {
+			if !containsString(allowed, k) {
+				delete(tags, k)
+				c.Removed++
+			}
+		}
+	}
+	for k, raw := range tags {
+		v, ok := raw.(string)
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if p.Lowercase {
+			v = strings.ToLower(v)
+		}
+		if p.MaxValueLength > 0 && utf8.RuneCountInString(v) > p.MaxValueLength {
+			v = truncateRunes(v, p.MaxValueLength)
+			c.Truncated++
+		}
+		tags[k] = v
+	}
+	return c
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateRunes cuts s to at most n characters without splitting one.
+func truncateRunes(s string, n int) string {
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos]
+		}
+		i++
+	}
+	return s
+}
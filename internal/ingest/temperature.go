@@ -0,0 +1,41 @@
+package ingest
+
+import (
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// temperatureHandler covers the generic temperature/sensors inputs and the
+// kiosk helper. Every reading becomes an environment point; the first one
+// also becomes the summary temperature.
+type temperatureHandler struct {
+	saw      bool
+	captured bool
+}
+
+func newTemperatureHandler() Handler {
+	return &temperatureHandler{}
+}
+
+func (h *temperatureHandler) Handle(b *Batch, m models.Metric, t time.Time) {
+	h.saw = true
+	tempValue, key, ok := extractTemperature(m.Fields)
+	if !ok {
+		return
+	}
+	b.UseField(key)
+	if !h.captured {
+		b.Summary.Temperature = tempValue
+		h.captured = true
+	}
+	b.AddPoints(FloatPoint(t, b.Summary.ServerID, "environment", "temperature_c", tempValue, m.Tags))
+}
+
+func (h *temperatureHandler) Finish(b *Batch) {
+	if !h.saw {
+		b.Note("temperature measurement missing")
+	} else if !h.captured {
+		b.Note("temperature measurement present but unusable fields")
+	}
+}
@@ -0,0 +1,263 @@
+// Package lineprotocol parses InfluxDB line protocol into the same
+// models.Metric stream the Telegraf JSON ingest produces.
+package lineprotocol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// maxLineBytes bounds a single line so a malformed body without newlines
+// cannot grow the scanner buffer without limit.
+const maxLineBytes = 1 << 20
+
+// PrecisionDivisor returns how many timestamp units make up one second for the
+// given precision (ns, us/u, ms, s). An empty precision means nanoseconds.
+func PrecisionDivisor(precision string) (int64, error) {
+	switch strings.ToLower(strings.TrimSpace(precision)) {
+	case "", "n", "ns":
+		return int64(time.Second / time.Nanosecond), nil
+	case "u", "us", "µs":
+		return int64(time.Second / time.Microsecond), nil
+	case "ms":
+		return int64(time.Second / time.Millisecond), nil
+	case "s":
+		return 1, nil
+	}
+	return 0, fmt.Errorf("unsupported precision %q", precision)
+}
+
+// Parse reads every line from r. Lines without a timestamp use now.
+func Parse(r io.Reader, precision string, now time.Time) ([]models.Metric, error) {
+	divisor, err := PrecisionDivisor(precision)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+
+	var out []models.Metric
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m, err := parseLine(line, divisor, now)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		out = append(out, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func parseLine(line string, divisor int64, now time.Time) (models.Metric, error) {
+	m := models.Metric{
+		Tags:   map[string]string{},
+		Fields: map[string]interface{}{},
+	}
+
+	seriesEnd := indexUnescaped(line, 0, ' ', false)
+	if seriesEnd < 0 {
+		return m, fmt.Errorf("missing fields")
+	}
+	if err := parseSeries(line[:seriesEnd], &m); err != nil {
+		return m, err
+	}
+
+	rest := strings.TrimLeft(line[seriesEnd:], " ")
+	fieldsEnd := indexUnescaped(rest, 0, ' ', true)
+	fieldSet := rest
+	tsRaw := ""
+	if fieldsEnd >= 0 {
+		fieldSet = rest[:fieldsEnd]
+		tsRaw = strings.TrimSpace(rest[fieldsEnd:])
+	}
+	if err := parseFields(fieldSet, &m); err != nil {
+		return m, err
+	}
+
+	if tsRaw == "" {
+		m.Timestamp = unixSeconds(now.UnixNano(), int64(time.Second))
+		return m, nil
+	}
+	ts, err := strconv.ParseInt(tsRaw, 10, 64)
+	if err != nil {
+		return m, fmt.Errorf("invalid timestamp %q", tsRaw)
+	}
+	m.Timestamp = unixSeconds(ts, divisor)
+	return m, nil
+}
+
+func parseSeries(series string, m *models.Metric) error {
+	parts := splitUnescaped(series, ',')
+	name := unescape(parts[0])
+	if name == "" {
+		return fmt.Errorf("missing measurement")
+	}
+	m.Name = name
+
+	for _, part := range parts[1:] {
+		eq := indexUnescaped(part, 0, '=', false)
+		if eq <= 0 {
+			return fmt.Errorf("invalid tag %q", part)
+		}
+		key := unescape(part[:eq])
+		value := unescape(part[eq+1:])
+		if value == "" {
+			return fmt.Errorf("empty value for tag %q", key)
+		}
+		m.Tags[key] = value
+	}
+	return nil
+}
+
+func parseFields(fieldSet string, m *models.Metric) error {
+	if fieldSet == "" {
+		return fmt.Errorf("missing fields")
+	}
+
+	pos := 0
+	for pos < len(fieldSet) {
+		eq := indexUnescaped(fieldSet, pos, '=', false)
+		if eq <= pos {
+			return fmt.Errorf("invalid field near %q", fieldSet[pos:])
+		}
+		key := unescape(fieldSet[pos:eq])
+
+		end := indexUnescaped(fieldSet, eq+1, ',', true)
+		if end < 0 {
+			end = len(fieldSet)
+		}
+		raw := fieldSet[eq+1 : end]
+		value, err := parseFieldValue(raw)
+		if err != nil {
+			return fmt.Errorf("field %q: %w", key, err)
+		}
+		m.Fields[key] = value
+		pos = end + 1
+	}
+	return nil
+}
+
+func parseFieldValue(raw string) (interface{}, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	if raw[0] == '"' {
+		if len(raw) < 2 || raw[len(raw)-1] != '"' {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		s := raw[1 : len(raw)-1]
+		s = strings.ReplaceAll(s, `\"`, `"`)
+		s = strings.ReplaceAll(s, `\\`, `\`)
+		return s, nil
+	}
+
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+
+	switch raw[len(raw)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", raw)
+		}
+		return v, nil
+	case 'u':
+		v, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unsigned integer %q", raw)
+		}
+		if v > math.MaxInt64 {
+			return float64(v), nil
+		}
+		return int64(v), nil
+	}
+
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid float %q", raw)
+	}
+	return v, nil
+}
+
+// unixSeconds converts a timestamp expressed in 1/divisor second units into
+// the float seconds used by models.Metric.
+func unixSeconds(ts, divisor int64) float64 {
+	sec := ts / divisor
+	frac := ts % divisor
+	return float64(sec) + float64(frac)/float64(divisor)
+}
+
+// indexUnescaped returns the index of the first sep at or after start that is
+// not escaped with a backslash. When quoted is true, separators inside double
+// quoted strings are skipped as well.
+func indexUnescaped(s string, start int, sep byte, quoted bool) int {
+	inQuotes := false
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' {
+			i++
+			continue
+		}
+		if quoted && c == '"' {
+			inQuotes = !inQuotes
+			continue
+		}
+		if c == sep && !inQuotes {
+			return i
+		}
+	}
+	return -1
+}
+
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for {
+		idx := indexUnescaped(s, start, sep, false)
+		if idx < 0 {
+			parts = append(parts, s[start:])
+			return parts
+		}
+		parts = append(parts, s[start:idx])
+		start = idx + 1
+	}
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case ',', '=', ' ', '"', '\\':
+				i++
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,120 @@
+package lineprotocol
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Unix(1760000000, 250000000)
+
+	tests := []struct {
+		name      string
+		input     string
+		precision string
+		want      []models.Metric
+	}{
+		{
+			name:  "telegraf cpu line",
+			input: "cpu,cpu=cpu-total,host=kiosk-0042 usage_idle=87.5,usage_user=9 1760000000000000000\n",
+			want: []models.Metric{{
+				Name:      "cpu",
+				Tags:      map[string]string{"cpu": "cpu-total", "host": "kiosk-0042"},
+				Fields:    map[string]interface{}{"usage_idle": 87.5, "usage_user": 9.0},
+				Timestamp: 1760000000,
+			}},
+		},
+		{
+			name:  "field types",
+			input: `kiosk_service,name=kiosk-browser running=true,stopped=F,process_count=3i,restarts=7u,huge=18446744073709551615u,load=-1.5e2,state="active (running)"`,
+			want: []models.Metric{{
+				Name: "kiosk_service",
+				Tags: map[string]string{"name": "kiosk-browser"},
+				Fields: map[string]interface{}{
+					"running":       true,
+					"stopped":       false,
+					"process_count": int64(3),
+					"restarts":      int64(7),
+					"huge":          float64(18446744073709551615),
+					"load":          -150.0,
+					"state":         "active (running)",
+				},
+				Timestamp: 1760000000.25,
+			}},
+		},
+		{
+			name:      "escaped measurement, tags and field keys",
+			input:     `disk\ usage,path=C:\\Program\ Files,label=a\,b\=c free\ bytes=1i,used\,pct=2.5,a\=b=3i 1760000000`,
+			precision: "s",
+			want: []models.Metric{{
+				Name:      "disk usage",
+				Tags:      map[string]string{"path": `C:\Program Files`, "label": "a,b=c"},
+				Fields:    map[string]interface{}{"free bytes": int64(1), "used,pct": 2.5, "a=b": int64(3)},
+				Timestamp: 1760000000,
+			}},
+		},
+		{
+			name:      "string fields with separators and escapes",
+			input:     `log msg="a, b=c d",quote="say \"hi\"",path="C:\\tmp\\" 1760000000123`,
+			precision: "ms",
+			want: []models.Metric{{
+				Name:      "log",
+				Tags:      map[string]string{},
+				Fields:    map[string]interface{}{"msg": "a, b=c d", "quote": `say "hi"`, "path": `C:\tmp\`},
+				Timestamp: 1760000000.123,
+			}},
+		},
+		{
+			name:      "precisions, comments and blank lines",
+			input:     "# comment\n\nmem used=1i 1760000000500000\n  \nmem used=2i 1760000001\n",
+			precision: "us",
+			want: []models.Metric{
+				{Name: "mem", Tags: map[string]string{}, Fields: map[string]interface{}{"used": int64(1)}, Timestamp: 1760000000.5},
+				{Name: "mem", Tags: map[string]string{}, Fields: map[string]interface{}{"used": int64(2)}, Timestamp: 1760.000001},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input), tt.precision, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Parse =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"no fields", "cpu", "line 1: missing fields"},
+		{"empty tag value", "cpu,host= usage=1", `line 1: empty value for tag "host"`},
+		{"line number", "mem used=1i\ncpu", "line 2: missing fields"},
+		{"bad integer value", "mem used=1.5i", `line 1: field "used": invalid integer "1.5i"`},
+		{"unterminated string", `log msg="abc`, `line 1: field "msg": unterminated string`},
+		{"bad timestamp", "mem used=1i 17e9", `line 1: invalid timestamp "17e9"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.input), "", time.Now())
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("Parse error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := Parse(strings.NewReader("mem used=1i"), "h", time.Now()); err == nil {
+		t.Error("Parse accepted precision h")
+	}
+}
@@ -91,11 +91,14 @@ type CleanMetric struct {
 	Region             string
 	RegionName         string
 	Time               time.Time
+	// ReceivedAt is when the API received the payload.
+	ReceivedAt time.Time
 }
 
 type LatestMetric struct {
 	ServerID           string    `json:"server_id"`
 	Time               time.Time `json:"time"`
+	ReceivedAt         *time.Time `json:"received_at,omitempty"`
 	CPU                float64   `json:"cpu"`
 	Memory             float64   `json:"memory"`
 	Temperature        float64   `json:"temperature"`
@@ -141,6 +144,7 @@ type LatestMetric struct {
 
 type HistoryMetric struct {
 	Time               time.Time `json:"time"`
+	ReceivedAt         *time.Time `json:"received_at,omitempty"`
 	CPU                float64   `json:"cpu"`
 	Memory             float64   `json:"memory"`
 	Temperature        float64   `json:"temperature"`
@@ -185,8 +189,9 @@ type HistoryMetric struct {
 }
 
 type SeriesMeta struct {
-	Measurement string `json:"measurement"`
-	Field       string `json:"field"`
+	Measurement string                 `json:"measurement"`
+	Field       string                 `json:"field"`
+	Tags        map[string]interface{} `json:"tags,omitempty"`
 }
 
 type SeriesPointResponse struct {
@@ -197,6 +202,7 @@ type SeriesPointResponse struct {
 	ValueDouble *float64               `json:"value_double,omitempty"`
 	ValueInt    *int64                 `json:"value_int,omitempty"`
 	Tags        map[string]interface{} `json:"tags"`
+	ReceivedAt  *time.Time             `json:"received_at,omitempty"`
 }
 
 type ServerStatus struct {
@@ -226,4 +232,70 @@ type SeriesPoint struct {
 	ValueDouble *float64
 	ValueInt    *int64
 	TagsJSON    []byte
+	ReceivedAt  time.Time
+	// WALSegment is the write-ahead log segment holding this point, or 0 when
+	// the point is not in the WAL.
+	WALSegment uint64
+	// WALOffset is the offset of the point's record within WALSegment.
+	WALOffset int64
+	// Source is the ingest path that produced the point, one of the Source
+	// constants.
+	Source string
+}
+
+// Ingest sources stored with each series point. The raw payload archive holds
+// what the Telegraf paths received, so reprocess jobs only delete and rebuild
+// the points of ArchivedSources.
+const (
+	// SourceTelegraf is /api/metrics and /api/write.
+	SourceTelegraf    = "telegraf"
+	SourceImport      = "import"
+	SourceReprocess   = "reprocess"
+	SourceRemoteWrite = "remote_write"
+	SourceOTLP        = "otlp"
+)
+
+// ArchivedSources are the sources whose points the archive can rebuild.
+var ArchivedSources = []string{SourceTelegraf, SourceImport, SourceReprocess}
+
+// IngestToken describes a kiosk ingest token; the token itself is only
+// returned once, when it is issued.
+type IngestToken struct {
+	ID         int64      `json:"id"`
+	ServerID   string     `json:"server_id"`
+	Token      string     `json:"token,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// IngestSecret describes a kiosk HMAC signing secret; the secret itself is
+// only returned when it is generated.
+type IngestSecret struct {
+	ServerID  string    `json:"server_id"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RawPayload is one kiosk's part of an ingest payload as received, archived
+// so it can be parsed again. Payload is the TelegrafPayload JSON; MinTime and
+// MaxTime bound its metric timestamps.
+type RawPayload struct {
+	ID         int64
+	ReceivedAt time.Time
+	ServerID   string
+	MinTime    time.Time
+	MaxTime    time.Time
+	Payload    []byte
+}
+
+// ArchiveGap covers payloads of one kiosk that never reached the raw payload
+// archive; reprocess jobs refuse windows overlapping it. MinTime and MaxTime
+// bound the metric timestamps of the Payloads missing payloads.
+type ArchiveGap struct {
+	ServerID string    `json:"server_id"`
+	MinTime  time.Time `json:"min_time"`
+	MaxTime  time.Time `json:"max_time"`
+	Payloads int       `json:"payloads"`
 }
@@ -0,0 +1,382 @@
+package otlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DecodeJSON parses an OTLP/JSON ExportMetricsServiceRequest.
+func DecodeJSON(r io.Reader) (*ExportRequest, error) {
+	var req ExportRequest
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// UnmarshalProto parses a protobuf ExportMetricsServiceRequest.
+func UnmarshalProto(b []byte) (*ExportRequest, error) {
+	var req ExportRequest
+	err := walk(b, func(f field) error {
+		if f.num == 1 && f.typ == protowire.BytesType {
+			rm, err := unmarshalResourceMetrics(f.bytes)
+			if err != nil {
+				return fmt.Errorf("resource_metrics: %w", err)
+			}
+			req.ResourceMetrics = append(req.ResourceMetrics, rm)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func unmarshalResourceMetrics(b []byte) (ResourceMetrics, error) {
+	var rm ResourceMetrics
+	err := walk(b, func(f field) error {
+		if f.typ != protowire.BytesType {
+			return nil
+		}
+		switch f.num {
+		case 1:
+			return walk(f.bytes, func(rf field) error {
+				if rf.num == 1 && rf.typ == protowire.BytesType {
+					kv, err := unmarshalKeyValue(rf.bytes)
+					if err != nil {
+						return err
+					}
+					rm.Resource.Attributes = append(rm.Resource.Attributes, kv)
+				}
+				return nil
+			})
+		case 2:
+			sm, err := unmarshalScopeMetrics(f.bytes)
+			if err != nil {
+				return err
+			}
+			rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+		}
+		return nil
+	})
+	return rm, err
+}
+
+func unmarshalScopeMetrics(b []byte) (ScopeMetrics, error) {
+	var sm ScopeMetrics
+	err := walk(b, func(f field) error {
+		if f.typ != protowire.BytesType {
+			return nil
+		}
+		switch f.num {
+		case 1:
+			return walk(f.bytes, func(sf field) error {
+				switch {
+				case sf.num == 1 && sf.typ == protowire.BytesType:
+					sm.Scope.Name = string(sf.bytes)
+				case sf.num == 2 && sf.typ == protowire.BytesType:
+					sm.Scope.Version = string(sf.bytes)
+				}
+				return nil
+			})
+		case 2:
+			m, err := unmarshalMetric(f.bytes)
+			if err != nil {
+				return err
+			}
+			sm.Metrics = append(sm.Metrics, m)
+		}
+		return nil
+	})
+	return sm, err
+}
+
+func unmarshalMetric(b []byte) (Metric, error) {
+	var m Metric
+	err := walk(b, func(f field) error {
+		if f.typ != protowire.BytesType {
+			return nil
+		}
+		switch f.num {
+		case 1:
+			m.Name = string(f.bytes)
+		case 2:
+			m.Description = string(f.bytes)
+		case 3:
+			m.Unit = string(f.bytes)
+		case 5:
+			m.Gauge = &Gauge{}
+			return walk(f.bytes, func(gf field) error {
+				if gf.num == 1 && gf.typ == protowire.BytesType {
+					dp, err := unmarshalNumberDataPoint(gf.bytes)
+					if err != nil {
+						return err
+					}
+					m.Gauge.DataPoints = append(m.Gauge.DataPoints, dp)
+				}
+				return nil
+			})
+		case 7:
+			m.Sum = &Sum{}
+			return walk(f.bytes, func(sf field) error {
+				switch {
+				case sf.num == 1 && sf.typ == protowire.BytesType:
+					dp, err := unmarshalNumberDataPoint(sf.bytes)
+					if err != nil {
+						return err
+					}
+					m.Sum.DataPoints = append(m.Sum.DataPoints, dp)
+				case sf.num == 2 && sf.typ == protowire.VarintType:
+					m.Sum.AggregationTemporality = Temporality(sf.varint)
+				case sf.num == 3 && sf.typ == protowire.VarintType:
+					m.Sum.IsMonotonic = sf.varint != 0
+				}
+				return nil
+			})
+		case 9:
+			m.Histogram = &Histogram{}
+			return walk(f.bytes, func(hf field) error {
+				switch {
+				case hf.num == 1 && hf.typ == protowire.BytesType:
+					dp, err := unmarshalHistogramDataPoint(hf.bytes)
+					if err != nil {
+						return err
+					}
+					m.Histogram.DataPoints = append(m.Histogram.DataPoints, dp)
+				case hf.num == 2 && hf.typ == protowire.VarintType:
+					m.Histogram.AggregationTemporality = Temporality(hf.varint)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return m, err
+}
+
+func unmarshalNumberDataPoint(b []byte) (NumberDataPoint, error) {
+	var dp NumberDataPoint
+	err := walk(b, func(f field) error {
+		switch {
+		case f.num == 7 && f.typ == protowire.BytesType:
+			kv, err := unmarshalKeyValue(f.bytes)
+			if err != nil {
+				return err
+			}
+			dp.Attributes = append(dp.Attributes, kv)
+		case f.num == 2 && f.typ == protowire.Fixed64Type:
+			dp.StartTimeUnixNano = Uint64(f.fixed64)
+		case f.num == 3 && f.typ == protowire.Fixed64Type:
+			dp.TimeUnixNano = Uint64(f.fixed64)
+		case f.num == 4 && f.typ == protowire.Fixed64Type:
+			v := math.Float64frombits(f.fixed64)
+			dp.AsDouble = &v
+		case f.num == 6 && f.typ == protowire.Fixed64Type:
+			v := Int64(int64(f.fixed64))
+			dp.AsInt = &v
+		}
+		return nil
+	})
+	return dp, err
+}
+
+func unmarshalHistogramDataPoint(b []byte) (HistogramDataPoint, error) {
+	var dp HistogramDataPoint
+	err := walk(b, func(f field) error {
+		switch {
+		case f.num == 9 && f.typ == protowire.BytesType:
+			kv, err := unmarshalKeyValue(f.bytes)
+			if err != nil {
+				return err
+			}
+			dp.Attributes = append(dp.Attributes, kv)
+		case f.num == 2 && f.typ == protowire.Fixed64Type:
+			dp.StartTimeUnixNano = Uint64(f.fixed64)
+		case f.num == 3 && f.typ == protowire.Fixed64Type:
+			dp.TimeUnixNano = Uint64(f.fixed64)
+		case f.num == 4 && f.typ == protowire.Fixed64Type:
+			dp.Count = Uint64(f.fixed64)
+		case f.num == 5 && f.typ == protowire.Fixed64Type:
+			v := math.Float64frombits(f.fixed64)
+			dp.Sum = &v
+		case f.num == 6 && f.typ == protowire.Fixed64Type:
+			dp.BucketCounts = append(dp.BucketCounts, Uint64(f.fixed64))
+		case f.num == 6 && f.typ == protowire.BytesType:
+			vals, err := packedFixed64(f.bytes)
+			if err != nil {
+				return err
+			}
+			for _, v := range vals {
+				dp.BucketCounts = append(dp.BucketCounts, Uint64(v))
+			}
+		case f.num == 7 && f.typ == protowire.Fixed64Type:
+			dp.ExplicitBounds = append(dp.ExplicitBounds, math.Float64frombits(f.fixed64))
+		case f.num == 7 && f.typ == protowire.BytesType:
+			vals, err := packedFixed64(f.bytes)
+			if err != nil {
+				return err
+			}
+			for _, v := range vals {
+				dp.ExplicitBounds = append(dp.ExplicitBounds, math.Float64frombits(v))
+			}
+		case f.num == 11 && f.typ == protowire.Fixed64Type:
+			v := math.Float64frombits(f.fixed64)
+			dp.Min = &v
+		case f.num == 12 && f.typ == protowire.Fixed64Type:
+			v := math.Float64frombits(f.fixed64)
+			dp.Max = &v
+		}
+		return nil
+	})
+	return dp, err
+}
+
+func unmarshalKeyValue(b []byte) (KeyValue, error) {
+	var kv KeyValue
+	err := walk(b, func(f field) error {
+		if f.typ != protowire.BytesType {
+			return nil
+		}
+		switch f.num {
+		case 1:
+			kv.Key = string(f.bytes)
+		case 2:
+			v, err := unmarshalAnyValue(f.bytes)
+			if err != nil {
+				return err
+			}
+			kv.Value = v
+		}
+		return nil
+	})
+	return kv, err
+}
+
+func unmarshalAnyValue(b []byte) (AnyValue, error) {
+	var v AnyValue
+	err := walk(b, func(f field) error {
+		switch {
+		case f.num == 1 && f.typ == protowire.BytesType:
+			s := string(f.bytes)
+			v.StringValue = &s
+		case f.num == 2 && f.typ == protowire.VarintType:
+			bv := f.varint != 0
+			v.BoolValue = &bv
+		case f.num == 3 && f.typ == protowire.VarintType:
+			iv := Int64(int64(f.varint))
+			v.IntValue = &iv
+		case f.num == 4 && f.typ == protowire.Fixed64Type:
+			dv := math.Float64frombits(f.fixed64)
+			v.DoubleValue = &dv
+		case f.num == 5 && f.typ == protowire.BytesType:
+			arr := &ArrayValue{}
+			if err := walk(f.bytes, func(af field) error {
+				if af.num == 1 && af.typ == protowire.BytesType {
+					item, err := unmarshalAnyValue(af.bytes)
+					if err != nil {
+						return err
+					}
+					arr.Values = append(arr.Values, item)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			v.ArrayValue = arr
+		case f.num == 6 && f.typ == protowire.BytesType:
+			list := &KeyValueList{}
+			if err := walk(f.bytes, func(lf field) error {
+				if lf.num == 1 && lf.typ == protowire.BytesType {
+					kv, err := unmarshalKeyValue(lf.bytes)
+					if err != nil {
+						return err
+					}
+					list.Values = append(list.Values, kv)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			v.KvlistValue = list
+		case f.num == 7 && f.typ == protowire.BytesType:
+			v.BytesValue = append([]byte{}, f.bytes...)
+		}
+		return nil
+	})
+	return v, err
+}
+
+func packedFixed64(b []byte) ([]uint64, error) {
+	if len(b)%8 != 0 {
+		return nil, fmt.Errorf("packed fixed64 length %d", len(b))
+	}
+	out := make([]uint64, 0, len(b)/8)
+	for len(b) > 0 {
+		v, n := protowire.ConsumeFixed64(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		out = append(out, v)
+		b = b[n:]
+	}
+	return out, nil
+}
+
+// MarshalPartialSuccess encodes an ExportMetricsServiceResponse whose
+// partial_success reports rejected data points.
+func MarshalPartialSuccess(rejected int64, message string) []byte {
+	var ps []byte
+	ps = protowire.AppendTag(ps, 1, protowire.VarintType)
+	ps = protowire.AppendVarint(ps, uint64(rejected))
+	ps = protowire.AppendTag(ps, 2, protowire.BytesType)
+	ps = protowire.AppendString(ps, message)
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, ps)
+}
+
+// field is one decoded protobuf field; only the member matching typ is set.
+type field struct {
+	num     protowire.Number
+	typ     protowire.Type
+	bytes   []byte
+	varint  uint64
+	fixed64 uint64
+}
+
+func walk(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{num: num, typ: typ}
+		switch typ {
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.fixed64, n = protowire.ConsumeFixed64(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package otlp
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// goldenProto is an ExportMetricsServiceRequest encoded by hand with the
+// field numbers of opentelemetry/proto/metrics/v1: one resource (host.name,
+// service.name), a scope, and a gauge, a cumulative monotonic sum, a
+// histogram with packed buckets and bounds, and a delta sum whose attributes
+// use the array, kvlist and bytes values. goldenJSON is the same request in
+// OTLP/JSON.
+const goldenProto = "0ab6040a3a0a190a09686f73742e6e616d65120c0a0a6b696f736b2d303034320a1d0a0c736572766963652e6e616d65" +
+	"120d0a0b6b696f736b2d6167656e7412ce030a120a0874656c65677261661206312e33302e30127a0a116b696f736b2e" +
+	"74656d7065726174757265120f4350552074656d70657261747572651a0343656c2a4f0a4d3a0f0a0673656e736f7212" +
+	"050a036370751915cd0bdcacc66c18210000000000c047403a080a026f6b120210013a0a0a04636f7265120218033a12" +
+	"0a057363616c65120921000000000000e03f124b0a0f6b696f736b2e6e65742e62797465731a0242793a340a2e110060" +
+	"3d8694bd6c181915cd0bdcacc66c183140e20100000000003a110a09646972656374696f6e12040a0272781002180112" +
+	"8b010a0d687474702e6475726174696f6e1a01734a770a731100603d8694bd6c181915cd0bdcacc66c18210600000000" +
+	"00000029000000000000f43f32180100000000000000030000000000000002000000000000003a109a9999999999b93f" +
+	"000000000000e03f4a0f0a05726f75746512060a042f617069597b14ae47e17a843f61cdccccccccccec3f100212610a" +
+	"0e6b696f736b2e72657175657374733a4f0a491915cd0bdcacc66c182100000000000024403a130a046c697374120b2a" +
+	"090a030a01610a0218023a130a036d6170120c320a0a080a016b12030a01763a0b0a0372617712043a0201ff10011801" +
+	"1a2768747470733a2f2f6f70656e74656c656d657472792e696f2f736368656d61732f312e32342e30"
+
+const goldenJSON = `{"resourceMetrics":[{
+  "resource":{"attributes":[
+    {"key":"host.name","value":{"stringValue":"kiosk-0042"}},
+    {"key":"service.name","value":{"stringValue":"kiosk-agent"}}]},
+  "scopeMetrics":[{
+    "scope":{"name":"telegraf","version":"1.30.0"},
+    "metrics":[
+      {"name":"kiosk.temperature","description":"CPU temperature","unit":"Cel","gauge":{"dataPoints":[
+        {"attributes":[
+          {"key":"sensor","value":{"stringValue":"cpu"}},
+          {"key":"ok","value":{"boolValue":true}},
+          {"key":"core","value":{"intValue":"3"}},
+          {"key":"scale","value":{"doubleValue":0.5}}],
+         "timeUnixNano":"1760000000123456789","asDouble":47.5}]}},
+      {"name":"kiosk.net.bytes","unit":"By","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[
+        {"attributes":[{"key":"direction","value":{"stringValue":"rx"}}],
+         "startTimeUnixNano":"1759990000000000000","timeUnixNano":"1760000000123456789","asInt":"123456"}]}},
+      {"name":"http.duration","unit":"s","histogram":{"aggregationTemporality":"AGGREGATION_TEMPORALITY_CUMULATIVE","dataPoints":[
+        {"attributes":[{"key":"route","value":{"stringValue":"/api"}}],
+         "startTimeUnixNano":1759990000000000000,"timeUnixNano":1760000000123456789,
+         "count":"6","sum":1.25,"bucketCounts":["1","3",2],"explicitBounds":[0.1,0.5],"min":0.01,"max":0.9}]}},
+      {"name":"kiosk.requests","sum":{"aggregationTemporality":"AGGREGATION_TEMPORALITY_DELTA","isMonotonic":true,"dataPoints":[
+        {"attributes":[
+          {"key":"list","value":{"arrayValue":{"values":[{"stringValue":"a"},{"intValue":2}]}}},
+          {"key":"map","value":{"kvlistValue":{"values":[{"key":"k","value":{"stringValue":"v"}}]}}},
+          {"key":"raw","value":{"bytesValue":"Af8="}}],
+         "timeUnixNano":"1760000000123456789","asDouble":10}]}}]}]}]}`
+
+func ptr[T any](v T) *T { return &v }
+
+func strValue(s string) AnyValue { return AnyValue{StringValue: ptr(s)} }
+
+func goldenRequest() *ExportRequest {
+	const start, now = Uint64(1759990000000000000), Uint64(1760000000123456789)
+	return &ExportRequest{ResourceMetrics: []ResourceMetrics{{
+		Resource: Resource{Attributes: []KeyValue{
+			{Key: "host.name", Value: strValue("kiosk-0042")},
+			{Key: "service.name", Value: strValue("kiosk-agent")},
+		}},
+		ScopeMetrics: []ScopeMetrics{{
+			Scope: Scope{Name: "telegraf", Version: "1.30.0"},
+			Metrics: []Metric{
+				{
+					Name: "kiosk.temperature", Description: "CPU temperature", Unit: "Cel",
+					Gauge: &Gauge{DataPoints: []NumberDataPoint{{
+						Attributes: []KeyValue{
+							{Key: "sensor", Value: strValue("cpu")},
+							{Key: "ok", Value: AnyValue{BoolValue: ptr(true)}},
+							{Key: "core", Value: AnyValue{IntValue: ptr(Int64(3))}},
+							{Key: "scale", Value: AnyValue{DoubleValue: ptr(0.5)}},
+						},
+						TimeUnixNano: now,
+						AsDouble:     ptr(47.5),
+					}}},
+				},
+				{
+					Name: "kiosk.net.bytes", Unit: "By",
+					Sum: &Sum{
+						AggregationTemporality: TemporalityCumulative,
+						IsMonotonic:            true,
+						DataPoints: []NumberDataPoint{{
+							Attributes:        []KeyValue{{Key: "direction", Value: strValue("rx")}},
+							StartTimeUnixNano: start,
+							TimeUnixNano:      now,
+							AsInt:             ptr(Int64(123456)),
+						}},
+					},
+				},
+				{
+					Name: "http.duration", Unit: "s",
+					Histogram: &Histogram{
+						AggregationTemporality: TemporalityCumulative,
+						DataPoints: []HistogramDataPoint{{
+							Attributes:        []KeyValue{{Key: "route", Value: strValue("/api")}},
+							StartTimeUnixNano: start,
+							TimeUnixNano:      now,
+							Count:             6,
+							Sum:               ptr(1.25),
+							BucketCounts:      []Uint64{1, 3, 2},
+							ExplicitBounds:    []float64{0.1, 0.5},
+							Min:               ptr(0.01),
+							Max:               ptr(0.9),
+						}},
+					},
+				},
+				{
+					Name: "kiosk.requests",
+					Sum: &Sum{
+						AggregationTemporality: TemporalityDelta,
+						IsMonotonic:            true,
+						DataPoints: []NumberDataPoint{{
+							Attributes: []KeyValue{
+								{Key: "list", Value: AnyValue{ArrayValue: &ArrayValue{Values: []AnyValue{strValue("a"), {IntValue: ptr(Int64(2))}}}}},
+								{Key: "map", Value: AnyValue{KvlistValue: &KeyValueList{Values: []KeyValue{{Key: "k", Value: strValue("v")}}}}},
+								{Key: "raw", Value: AnyValue{BytesValue: []byte{0x01, 0xff}}},
+							},
+							TimeUnixNano: now,
+							AsDouble:     ptr(10.0),
+						}},
+					},
+				},
+			},
+		}},
+	}}}
+}
+
+func TestUnmarshalProtoGolden(t *testing.T) {
+	b, err := hex.DecodeString(goldenProto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalProto(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := goldenRequest(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("UnmarshalProto =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDecodeJSONGolden(t *testing.T) {
+	got, err := DecodeJSON(strings.NewReader(goldenJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := goldenRequest(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("DecodeJSON =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestAnyValueString(t *testing.T) {
+	req := goldenRequest()
+	attrs := req.ResourceMetrics[0].ScopeMetrics[0].Metrics[3].Sum.DataPoints[0].Attributes
+	want := map[string]string{"list": "a,2", "map": "k=v", "raw": "01ff"}
+	for key, w := range want {
+		if got, ok := Attribute(attrs, key); !ok || got != w {
+			t.Errorf("Attribute(%q) = %q, %v; want %q", key, got, ok, w)
+		}
+	}
+	if _, ok := Attribute(attrs, "missing"); ok {
+		t.Error("Attribute found a missing key")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{"truncated resource_metrics", "0a05" + "0a03"},
+		{"packed bounds not a multiple of 8", "0a" + "14" + "12" + "12" + "12" + "10" + "4a" + "0e" + "0a" + "0c" + "3a" + "0a" + "00000000000000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := hex.DecodeString(tt.hex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := UnmarshalProto(b); err == nil {
+				t.Fatal("UnmarshalProto accepted a malformed request")
+			}
+		})
+	}
+
+	if _, err := DecodeJSON(strings.NewReader(`{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"sum":{"aggregationTemporality":"DELTA"}}]}]}]}`)); err == nil {
+		t.Error("DecodeJSON accepted an unknown temporality name")
+	}
+}
+
+func TestMarshalPartialSuccess(t *testing.T) {
+	b := MarshalPartialSuccess(3, "no deltas")
+	num, typ, n := protowire.ConsumeTag(b)
+	if num != 1 || typ != protowire.BytesType {
+		t.Fatalf("field %d type %d, want partial_success (1, bytes)", num, typ)
+	}
+	ps, m := protowire.ConsumeBytes(b[n:])
+	if m < 0 || n+m != len(b) {
+		t.Fatalf("partial_success not the only field: %x", b)
+	}
+	var rejected uint64
+	var message string
+	if err := walk(ps, func(f field) error {
+		switch f.num {
+		case 1:
+			rejected = f.varint
+		case 2:
+			message = string(f.bytes)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if rejected != 3 || message != "no deltas" {
+		t.Errorf("partial_success = (%d, %q), want (3, %q)", rejected, message, "no deltas")
+	}
+}
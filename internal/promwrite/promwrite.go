@@ -0,0 +1,184 @@
+// Package promwrite decodes Prometheus remote_write requests (snappy block
+// compressed prometheus.WriteRequest protobuf messages).
+package promwrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ErrTooLarge is returned when the decompressed body would exceed the limit
+// passed to Decode.
+var ErrTooLarge = errors.New("remote_write body too large")
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value       float64
+	TimestampMs int64
+}
+
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Name returns the value of the __name__ label.
+func (ts TimeSeries) Name() string {
+	return ts.Label("__name__")
+}
+
+// Label returns the value of the named label or "" when it is absent.
+func (ts TimeSeries) Label(name string) string {
+	for _, l := range ts.Labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// Decode snappy-decompresses body and parses the WriteRequest it contains.
+// Metadata entries are ignored. limit caps the decompressed size.
+func Decode(body []byte, limit int) ([]TimeSeries, error) {
+	n, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("snappy: %w", err)
+	}
+	if limit > 0 && n > limit {
+		return nil, ErrTooLarge
+	}
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("snappy: %w", err)
+	}
+	return Unmarshal(raw)
+}
+
+// Unmarshal parses an uncompressed WriteRequest message.
+func Unmarshal(b []byte) ([]TimeSeries, error) {
+	var out []TimeSeries
+	err := walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		ts, err := unmarshalTimeSeries(v)
+		if err != nil {
+			return fmt.Errorf("timeseries: %w", err)
+		}
+		out = append(out, ts)
+		return nil
+	})
+	return out, err
+}
+
+func unmarshalTimeSeries(b []byte) (TimeSeries, error) {
+	var ts TimeSeries
+	err := walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			l, err := unmarshalLabel(v)
+			if err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, l)
+		case 2:
+			s, err := unmarshalSample(v)
+			if err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+func unmarshalLabel(b []byte) (Label, error) {
+	var l Label
+	err := walk(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			l.Name = string(v)
+		case 2:
+			l.Value = string(v)
+		}
+		return nil
+	})
+	return l, err
+}
+
+func unmarshalSample(b []byte) (Sample, error) {
+	var s Sample
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return s, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			s.Value = math.Float64frombits(v)
+			b = b[n:]
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			s.TimestampMs = int64(v)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return s, nil
+}
+
+// walk iterates over the fields of a message. Length-delimited values are
+// passed through as their payload; other wire types get a nil slice.
+func walk(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v []byte
+		if typ == protowire.BytesType {
+			v, n = protowire.ConsumeBytes(b)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package promwrite
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// goldenWriteRequest is a prometheus.WriteRequest encoded by hand: two
+// timeseries (field 1) and a metadata entry (field 3) that Decode ignores.
+//
+//	timeseries {
+//	  labels { name: "__name__" value: "node_network_receive_bytes_total" }
+//	  labels { name: "device" value: "eth0" }
+//	  labels { name: "instance" value: "kiosk-0042:9100" }
+//	  samples { value: 9876543210 timestamp: 1760000000000 }
+//	  samples { value: 9876600000 timestamp: 1760000015000 }
+//	}
+//	timeseries {
+//	  labels { name: "__name__" value: "up" }
+//	  samples { value: 1 timestamp: 1760000000123 }
+//	}
+//	metadata { type: COUNTER metric_family_name: "up" }
+const goldenWriteRequest = "0a7f0a2c0a085f5f6e616d655f5f12206e6f64655f6e6574776f726b5f726563656976655f62797465735f746f74616c" +
+	"0a0e0a066465766963651204657468300a1b0a08696e7374616e6365120f6b696f736b2d303034323a39313030" +
+	"121009000050b780650242108080b3c19c33121009000000a6876502421098f5b3c19c33" +
+	"0a220a0e0a085f5f6e616d655f5f12027570121009000000000000f03f10fb80b3c19c33" +
+	"1a06080112027570"
+
+// goldenSnappyBlock is goldenWriteRequest as a snappy block: the varint
+// decoded length (173) followed by a single literal.
+const goldenSnappyBlock = "ad01f0ac" + goldenWriteRequest
+
+var goldenSeries = []TimeSeries{
+	{
+		Labels: []Label{
+			{Name: "__name__", Value: "node_network_receive_bytes_total"},
+			{Name: "device", Value: "eth0"},
+			{Name: "instance", Value: "kiosk-0042:9100"},
+		},
+		Samples: []Sample{
+			{Value: 9876543210, TimestampMs: 1760000000000},
+			{Value: 9876600000, TimestampMs: 1760000015000},
+		},
+	},
+	{
+		Labels:  []Label{{Name: "__name__", Value: "up"}},
+		Samples: []Sample{{Value: 1, TimestampMs: 1760000000123}},
+	},
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecode(t *testing.T) {
+	raw := mustHex(t, goldenWriteRequest)
+	var framed bytes.Buffer
+	w := snappy.NewBufferedWriter(&framed)
+	w.Write(raw)
+	w.Close()
+
+	tests := []struct {
+		name    string
+		body    []byte
+		limit   int
+		want    []TimeSeries
+		wantErr error
+	}{
+		{name: "golden block", body: mustHex(t, goldenSnappyBlock), limit: 1 << 20, want: goldenSeries},
+		{name: "encoder block", body: snappy.Encode(nil, raw), want: goldenSeries},
+		{name: "decoded size over limit", body: mustHex(t, goldenSnappyBlock), limit: 172, wantErr: ErrTooLarge},
+		{name: "stream framing is not a block", body: framed.Bytes()},
+		{name: "uncompressed protobuf", body: raw},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.body, tt.limit)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("Decode = %+v, want an error", got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Decode error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Decode =\n%+v\nwant\n%+v", got, tt.want)
+			}
+			if got[0].Name() != "node_network_receive_bytes_total" || got[0].Label("device") != "eth0" || got[0].Label("missing") != "" {
+				t.Errorf("label lookup on %+v", got[0].Labels)
+			}
+		})
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		hex     string
+		want    []TimeSeries
+		wantErr bool
+	}{
+		{
+			// Negative timestamps are int64 varints in two's complement.
+			name: "negative timestamp",
+			hex:  "0a16" + "1214" + "09000000000000f0bf" + "10" + "ffffffffffffffffff01",
+			want: []TimeSeries{{Samples: []Sample{{Value: -1, TimestampMs: -1}}}},
+		},
+		{
+			// Exemplars (field 3) and histograms (field 4) are skipped.
+			name: "unknown timeseries fields",
+			hex:  "0a" + "0c" + "0a060a0161120162" + "1a00" + "2200",
+			want: []TimeSeries{{Labels: []Label{{Name: "a", Value: "b"}}}},
+		},
+		{name: "truncated length", hex: "0a05" + "0a03", wantErr: true},
+		{name: "truncated sample value", hex: "0a05" + "1203" + "090000", wantErr: true},
+		{name: "empty request", hex: "", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unmarshal(mustHex(t, tt.hex))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Unmarshal = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Unmarshal =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"metrics-api/internal/models"
+)
+
+// ErrSecretNotFound is returned when a kiosk has no signing secret.
+var ErrSecretNotFound = errors.New("ingest secret not found")
+
+// IngestSecret returns the HMAC signing secret of serverID.
+func (r *MetricsRepository) IngestSecret(ctx context.Context, serverID string) (string, error) {
+	var secret string
+	err := r.db.QueryRowContext(ctx, `SELECT secret FROM ingest_secrets WHERE server_id = $1`, serverID).Scan(&secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrSecretNotFound
+	}
+	return secret, err
+}
+
+// SetIngestSecret stores (or replaces) the signing secret of serverID.
+func (r *MetricsRepository) SetIngestSecret(ctx context.Context, serverID, secret string) (models.IngestSecret, error) {
+	s := models.IngestSecret{ServerID: serverID}
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO ingest_secrets(server_id, secret) VALUES ($1, $2)
+         ON CONFLICT (server_id) DO UPDATE SET secret = EXCLUDED.secret, updated_at = now()
+         RETURNING created_at, updated_at`,
+		serverID, secret,
+	).Scan(&s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+// DeleteIngestSecret removes the signing secret of serverID and reports
+// whether one existed.
+func (r *MetricsRepository) DeleteIngestSecret(ctx context.Context, serverID string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM ingest_secrets WHERE server_id = $1`, serverID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListIngestSecrets lists the kiosks that have a signing secret, without the
+// secrets themselves.
+func (r *MetricsRepository) ListIngestSecrets(ctx context.Context, limit, offset int) ([]models.IngestSecret, bool, error) {
+	limitPlusOne := limit + 1
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT server_id, created_at, updated_at
+         FROM ingest_secrets
+         ORDER BY server_id
+         LIMIT $1 OFFSET $2`,
+		limitPlusOne, offset,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var out []models.IngestSecret
+	for rows.Next() {
+		var s models.IngestSecret
+		if err := rows.Scan(&s.ServerID, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, false, err
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := false
+	if len(out) > limit {
+		hasMore = true
+		out = out[:limit]
+	}
+	return out, hasMore, nil
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// ErrTokenNotFound is returned when a token hash does not match an active token.
+var ErrTokenNotFound = errors.New("ingest token not found")
+
+// CreateIngestToken stores a new token hash for serverID.
+func (r *MetricsRepository) CreateIngestToken(ctx context.Context, serverID, tokenHash string) (models.IngestToken, error) {
+	t := models.IngestToken{ServerID: serverID}
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO ingest_tokens(server_id, token_hash) VALUES ($1, $2) RETURNING id, created_at`,
+		serverID, tokenHash,
+	).Scan(&t.ID, &t.CreatedAt)
+	return t, err
+}
+
+// RotateIngestToken stores a new token hash for serverID and schedules every
+// other active token of that server to expire after grace.
+func (r *MetricsRepository) RotateIngestToken(ctx context.Context, serverID, tokenHash string, grace time.Duration) (models.IngestToken, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.IngestToken{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE ingest_tokens
+         SET revoked_at = now() + make_interval(secs => $2)
+         WHERE server_id = $1 AND (revoked_at IS NULL OR revoked_at > now() + make_interval(secs => $2))`,
+		serverID, grace.Seconds(),
+	); err != nil {
+		return models.IngestToken{}, err
+	}
+
+	t := models.IngestToken{ServerID: serverID}
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO ingest_tokens(server_id, token_hash) VALUES ($1, $2) RETURNING id, created_at`,
+		serverID, tokenHash,
+	).Scan(&t.ID, &t.CreatedAt); err != nil {
+		return models.IngestToken{}, err
+	}
+
+	return t, tx.Commit()
+}
+
+// RevokeIngestToken revokes a single token by id and reports whether it was
+// active.
+func (r *MetricsRepository) RevokeIngestToken(ctx context.Context, id int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE ingest_tokens SET revoked_at = now() WHERE id = $1 AND (revoked_at IS NULL OR revoked_at > now())`,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeIngestTokens revokes every active token of serverID and returns how
+// many were revoked.
+func (r *MetricsRepository) RevokeIngestTokens(ctx context.Context, serverID string) (int64, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE ingest_tokens SET revoked_at = now() WHERE server_id = $1 AND (revoked_at IS NULL OR revoked_at > now())`,
+		serverID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// LookupIngestToken returns the server_id bound to an active token hash and
+// refreshes its last_used_at (at most every five minutes).
+func (r *MetricsRepository) LookupIngestToken(ctx context.Context, tokenHash string) (string, error) {
+	var (
+		id       int64
+		serverID string
+		lastUsed sql.NullTime
+	)
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, server_id, last_used_at
+         FROM ingest_tokens
+         WHERE token_hash = $1 AND (revoked_at IS NULL OR revoked_at > now())`,
+		tokenHash,
+	).Scan(&id, &serverID, &lastUsed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrTokenNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !lastUsed.Valid || time.Since(lastUsed.Time) > 5*time.Minute {
+		_, _ = r.db.ExecContext(ctx, `UPDATE ingest_tokens SET last_used_at = now() WHERE id = $1`, id)
+	}
+	return serverID, nil
+}
+
+// ListIngestTokens lists tokens, optionally for one server, newest first.
+// Revoked tokens are only included when includeRevoked is set.
+func (r *MetricsRepository) ListIngestTokens(ctx context.Context, serverID string, includeRevoked bool, limit, offset int) ([]models.IngestToken, bool, error) {
+	limitPlusOne := limit + 1
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, server_id, created_at, revoked_at, last_used_at
+         FROM ingest_tokens
+         WHERE ($1 = '' OR server_id = $1)
+           AND ($2 OR revoked_at IS NULL OR revoked_at > now())
+         ORDER BY created_at DESC, id DESC
+         LIMIT $3 OFFSET $4`,
+		serverID, includeRevoked, limitPlusOne, offset,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var out []models.IngestToken
+	for rows.Next() {
+		var (
+			t        models.IngestToken
+			revoked  sql.NullTime
+			lastUsed sql.NullTime
+		)
+		if err := rows.Scan(&t.ID, &t.ServerID, &t.CreatedAt, &revoked, &lastUsed); err != nil {
+			return nil, false, err
+		}
+		if revoked.Valid {
+			t.RevokedAt = &revoked.Time
+		}
+		if lastUsed.Valid {
+			t.LastUsedAt = &lastUsed.Time
+		}
+		out = append(out, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := false
+	if len(out) > limit {
+		hasMore = true
+		out = out[:limit]
+	}
+	return out, hasMore, nil
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestRotateIngestTokenGrace rotates a kiosk's token twice: with a grace
+// period the old token keeps working, without one it stops at once. It needs
+// TEST_DATABASE_URL (see series_points_test.go).
+func TestRotateIngestTokenGrace(t *testing.T) {
+	repo, serverID := testRepository(t)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		repo.db.Exec(`DELETE FROM ingest_tokens WHERE server_id = $1`, serverID)
+	})
+
+	hash := func(s string) string { return serverID + "-" + s }
+	if _, err := repo.CreateIngestToken(ctx, serverID, hash("first")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.RotateIngestToken(ctx, serverID, hash("second"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"first", "second"} {
+		if got, err := repo.LookupIngestToken(ctx, hash(token)); err != nil || got != serverID {
+			t.Errorf("lookup %s within grace = %q, %v; want %q", token, got, err, serverID)
+		}
+	}
+
+	if _, err := repo.RotateIngestToken(ctx, serverID, hash("third"), 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"first", "second"} {
+		if _, err := repo.LookupIngestToken(ctx, hash(token)); !errors.Is(err, ErrTokenNotFound) {
+			t.Errorf("lookup %s after a rotation without grace: err = %v, want ErrTokenNotFound", token, err)
+		}
+	}
+	if got, err := repo.LookupIngestToken(ctx, hash("third")); err != nil || got != serverID {
+		t.Errorf("lookup third = %q, %v; want %q", got, err, serverID)
+	}
+}
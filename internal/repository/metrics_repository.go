@@ -21,33 +21,12 @@ func NewMetricsRepository(db *sql.DB) *MetricsRepository {
 }
 
 func (r *MetricsRepository) SaveMetric(ctx context.Context, m models.CleanMetric) error {
-	devicesJSON, err := json.Marshal(m.InputDevices)
-	if err != nil {
-		return err
-	}
-
-	var linkStateValue interface{}
-	if m.LinkState != nil {
-		linkStateJSON, err := json.Marshal(m.LinkState)
-		if err != nil {
-			return err
-		}
-		linkStateValue = string(linkStateJSON)
-	}
+	return r.SaveMetrics(ctx, []models.CleanMetric{m})
+}
 
-	var processStatusesValue interface{}
-	if len(m.ProcessStatuses) > 0 {
-		processStatusesJSON, err := json.Marshal(m.ProcessStatuses)
-		if err != nil {
-			return err
-		}
-		processStatusesValue = string(processStatusesJSON)
-	}
+const serverMetricsColumns = `time, server_id, cpu, memory, temperature, chassis_temperature, hotspot_temperature, power_online, battery_present, battery_charge_pct, battery_voltage_mv, battery_current_ma, sound_volume_percent, sound_muted, display_connected, display_width, display_height, display_refresh_hz, display_primary, display_dpms_enabled, fan_rpm, memory_total_bytes, memory_used_bytes, disk, disk_total_bytes, disk_used_bytes, disk_free_bytes, net_bytes_sent, net_bytes_recv, net_daily_rx_bytes, net_daily_tx_bytes, net_monthly_rx_bytes, net_monthly_tx_bytes, input_devices_healthy, input_devices_missing, input_devices, link_state, process_statuses, uptime, city, city_name, region, region_name, received_at`
 
-	_, err = r.db.ExecContext(
-		ctx,
-		`INSERT INTO server_metrics(time, server_id, cpu, memory, temperature, chassis_temperature, hotspot_temperature, power_online, battery_present, battery_charge_pct, battery_voltage_mv, battery_current_ma, sound_volume_percent, sound_muted, display_connected, display_width, display_height, display_refresh_hz, display_primary, display_dpms_enabled, fan_rpm, memory_total_bytes, memory_used_bytes, disk, disk_total_bytes, disk_used_bytes, disk_free_bytes, net_bytes_sent, net_bytes_recv, net_daily_rx_bytes, net_daily_tx_bytes, net_monthly_rx_bytes, net_monthly_tx_bytes, input_devices_healthy, input_devices_missing, input_devices, link_state, process_statuses, uptime, city, city_name, region, region_name)
-         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $34, $35, $36, $37, $38, $39, $40, $41, $42, $43)
+const serverMetricsUpsert = `
          ON CONFLICT (server_id, time) DO UPDATE
          SET cpu = EXCLUDED.cpu,
              memory = EXCLUDED.memory,
@@ -89,29 +68,127 @@ func (r *MetricsRepository) SaveMetric(ctx context.Context, m models.CleanMetric
              city = EXCLUDED.city,
              city_name = EXCLUDED.city_name,
              region = EXCLUDED.region,
-             region_name = EXCLUDED.region_name`,
-		m.Time, m.ServerID, m.CPU, m.Memory, m.Temperature, m.ChassisTemperature, m.HotspotTemperature, m.PowerOnline, m.BatteryPresent, m.BatteryChargePct, m.BatteryVoltageMV, m.BatteryCurrentMA, m.SoundVolumePercent, m.SoundMuted, m.DisplayConnected, m.DisplayWidth, m.DisplayHeight, m.DisplayRefreshHz, m.DisplayPrimary, m.DisplayDpmsEnabled, m.FanRPM, m.MemoryTotalBytes, m.MemoryUsedBytes, m.Disk, m.DiskTotalBytes, m.DiskUsedBytes, m.DiskFreeBytes, m.NetBytesSent, m.NetBytesRecv, m.NetDailyRxBytes, m.NetDailyTxBytes, m.NetMonthlyRxBytes, m.NetMonthlyTxBytes, m.InputDevicesHealthy, m.InputDevicesMissing, devicesJSON, linkStateValue, processStatusesValue, m.Uptime, m.City, m.CityName, m.Region, m.RegionName,
-	)
-	return err
+             region_name = EXCLUDED.region_name,
+             received_at = COALESCE(server_metrics.received_at, EXCLUDED.received_at)`
+
+// serverMetricsColumnCount must match serverMetricsColumns and metricArgs.
+const serverMetricsColumnCount = 44
+
+// maxMetricsPerStatement keeps a multi-row upsert under Postgres' limit of
+// 65535 bind parameters.
+const maxMetricsPerStatement = 65535 / serverMetricsColumnCount
+
+// SaveMetrics upserts summary rows with multi-row statements. Rows for the
+// same (server_id, time) are collapsed to the last one first, since a single
+// upsert cannot touch a row twice.
+func (r *MetricsRepository) SaveMetrics(ctx context.Context, metrics []models.CleanMetric) error {
+	metrics = dedupeMetrics(metrics)
+	for len(metrics) > 0 {
+		n := len(metrics)
+		if n > maxMetricsPerStatement {
+			n = maxMetricsPerStatement
+		}
+		if err := r.upsertMetrics(ctx, metrics[:n]); err != nil {
+			return err
+		}
+		metrics = metrics[n:]
+	}
+	return nil
 }
 
-func (r *MetricsRepository) SaveSeriesPoints(ctx context.Context, points []models.SeriesPoint) error {
-	if len(points) == 0 {
-		return nil
+func (r *MetricsRepository) upsertMetrics(ctx context.Context, metrics []models.CleanMetric) error {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO server_metrics(" + serverMetricsColumns + ")\n         VALUES ")
+	args := make([]interface{}, 0, len(metrics)*serverMetricsColumnCount)
+	for i, m := range metrics {
+		rowArgs, err := metricArgs(m)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteByte('(')
+		for j := range rowArgs {
+			if j > 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(&sb, "$%d", len(args)+j+1)
+		}
+		sb.WriteByte(')')
+		args = append(args, rowArgs...)
 	}
+	sb.WriteString(serverMetricsUpsert)
 
-	tx, err := r.db.BeginTx(ctx, nil)
+	_, err := r.db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+// metricArgs returns the bind values for one row in serverMetricsColumns
+// order.
+func metricArgs(m models.CleanMetric) ([]interface{}, error) {
+	devicesJSON, err := json.Marshal(m.InputDevices)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer tx.Rollback()
 
-	stmt, err := tx.PrepareContext(ctx, `INSERT INTO metric_points(time, server_id, measurement, field, value_double, value_int, tags) VALUES ($1, $2, $3, $4, $5, $6, $7)`)
-	if err != nil {
-		return err
+	var linkStateValue interface{}
+	if m.LinkState != nil {
+		linkStateJSON, err := json.Marshal(m.LinkState)
+		if err != nil {
+			return nil, err
+		}
+		linkStateValue = string(linkStateJSON)
+	}
+
+	var processStatusesValue interface{}
+	if len(m.ProcessStatuses) > 0 {
+		processStatusesJSON, err := json.Marshal(m.ProcessStatuses)
+		if err != nil {
+			return nil, err
+		}
+		processStatusesValue = string(processStatusesJSON)
 	}
-	defer stmt.Close()
 
+	return []interface{}{
+		m.Time, m.ServerID, m.CPU, m.Memory, m.Temperature, m.ChassisTemperature, m.HotspotTemperature, m.PowerOnline, m.BatteryPresent, m.BatteryChargePct, m.BatteryVoltageMV, m.BatteryCurrentMA, m.SoundVolumePercent, m.SoundMuted, m.DisplayConnected, m.DisplayWidth, m.DisplayHeight, m.DisplayRefreshHz, m.DisplayPrimary, m.DisplayDpmsEnabled, m.FanRPM, m.MemoryTotalBytes, m.MemoryUsedBytes, m.Disk, m.DiskTotalBytes, m.DiskUsedBytes, m.DiskFreeBytes, m.NetBytesSent, m.NetBytesRecv, m.NetDailyRxBytes, m.NetDailyTxBytes, m.NetMonthlyRxBytes, m.NetMonthlyTxBytes, m.InputDevicesHealthy, m.InputDevicesMissing, devicesJSON, linkStateValue, processStatusesValue, m.Uptime, m.City, m.CityName, m.Region, m.RegionName, nullTime(m.ReceivedAt),
+	}, nil
+}
+
+// nullTime stores a zero time as NULL.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// dedupeMetrics keeps the last row for each (server_id, time), preserving the
+// order in which keys first appeared.
+func dedupeMetrics(metrics []models.CleanMetric) []models.CleanMetric {
+	type key struct {
+		serverID string
+		time     time.Time
+	}
+	index := make(map[key]int, len(metrics))
+	out := make([]models.CleanMetric, 0, len(metrics))
+	for _, m := range metrics {
+		k := key{m.ServerID, m.Time.UTC()}
+		if i, ok := index[k]; ok {
+			out[i] = m
+			continue
+		}
+		index[k] = len(out)
+		out = append(out, m)
+	}
+	return out
+}
+
+// SaveSeriesPoints inserts points in one statement: a multi-row unnest insert
+// for small batches and COPY for batches of copyMinPoints or more. Points
+// without a server_id, and points already stored, are skipped.
+func (r *MetricsRepository) SaveSeriesPoints(ctx context.Context, points []models.SeriesPoint) error {
+	rows := make([]models.SeriesPoint, 0, len(points))
 	for _, p := range points {
 		if p.ServerID == "" {
 			continue
@@ -119,24 +196,49 @@ func (r *MetricsRepository) SaveSeriesPoints(ctx context.Context, points []model
 		if p.TagsJSON == nil {
 			p.TagsJSON = []byte(`{}`)
 		}
-		if _, err := stmt.ExecContext(ctx, p.Time, p.ServerID, p.Measurement, p.Field, p.ValueDouble, p.ValueInt, p.TagsJSON); err != nil {
-			return err
-		}
+		rows = append(rows, p)
+	}
+	if len(rows) == 0 {
+		return nil
 	}
 
-	return tx.Commit()
+	if len(rows) >= copyMinPoints {
+		return r.copySeriesPoints(ctx, rows)
+	}
+	return r.insertSeriesPoints(ctx, rows)
 }
 
-func (r *MetricsRepository) ListSeriesMeta(ctx context.Context, serverID string, limit, offset int) ([]models.SeriesMeta, bool, error) {
+// ListSeriesMeta lists the (measurement, field) pairs stored for a server.
+// With a measurement it lists that measurement's series instead, one entry
+// per field and tag set, so per-interface, per-device and per-service series
+// can be found.
+func (r *MetricsRepository) ListSeriesMeta(ctx context.Context, serverID, measurement string, limit, offset int) ([]models.SeriesMeta, bool, error) {
 	limitPlusOne := limit + 1
-	rows, err := r.db.QueryContext(ctx,
-		`SELECT DISTINCT measurement, field
+	var rows *sql.Rows
+	var err error
+	if measurement == "" {
+		rows, err = r.db.QueryContext(ctx,
+			`SELECT DISTINCT measurement, field, NULL::jsonb
          FROM metric_points
          WHERE server_id = $1
          ORDER BY measurement, field
          LIMIT $2 OFFSET $3`,
-		serverID, limitPlusOne, offset,
-	)
+			serverID, limitPlusOne, offset,
+		)
+	} else {
+		rows, err = r.db.QueryContext(ctx,
+			`SELECT measurement, field, tags
+         FROM (
+           SELECT DISTINCT ON (field, tags_hash) measurement, field, tags
+           FROM metric_points
+           WHERE server_id = $1 AND measurement = $2
+           ORDER BY field, tags_hash
+         ) s
+         ORDER BY field, tags::text
+         LIMIT $3 OFFSET $4`,
+			serverID, measurement, limitPlusOne, offset,
+		)
+	}
 	if err != nil {
 		return nil, false, err
 	}
@@ -145,9 +247,13 @@ func (r *MetricsRepository) ListSeriesMeta(ctx context.Context, serverID string,
 	var out []models.SeriesMeta
 	for rows.Next() {
 		var m models.SeriesMeta
-		if err := rows.Scan(&m.Measurement, &m.Field); err != nil {
+		var tagsRaw []byte
+		if err := rows.Scan(&m.Measurement, &m.Field, &tagsRaw); err != nil {
 			return nil, false, err
 		}
+		if len(tagsRaw) > 0 {
+			_ = json.Unmarshal(tagsRaw, &m.Tags)
+		}
 		out = append(out, m)
 	}
 	if err := rows.Err(); err != nil {
@@ -166,13 +272,13 @@ func (r *MetricsRepository) SeriesLatest(ctx context.Context, serverID, measurem
 	var resp models.SeriesPointResponse
 	var tagsRaw []byte
 	err := r.db.QueryRowContext(ctx,
-		`SELECT time, server_id, measurement, field, value_double, value_int, tags
+		`SELECT time, server_id, measurement, field, value_double, value_int, tags, received_at
          FROM metric_points
          WHERE server_id = $1 AND measurement = $2 AND field = $3 AND tags @> $4::jsonb
          ORDER BY time DESC
          LIMIT 1`,
 		serverID, measurement, field, tagFilter,
-	).Scan(&resp.Time, &resp.ServerID, &resp.Measurement, &resp.Field, &resp.ValueDouble, &resp.ValueInt, &tagsRaw)
+	).Scan(&resp.Time, &resp.ServerID, &resp.Measurement, &resp.Field, &resp.ValueDouble, &resp.ValueInt, &tagsRaw, &resp.ReceivedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -185,7 +291,7 @@ func (r *MetricsRepository) SeriesLatest(ctx context.Context, serverID, measurem
 func (r *MetricsRepository) SeriesQuery(ctx context.Context, serverID, measurement, field, rng, tagFilter string, limit, offset int) ([]models.SeriesPointResponse, bool, error) {
 	limitPlusOne := limit + 1
 	rows, err := r.db.QueryContext(ctx,
-		`SELECT time, server_id, measurement, field, value_double, value_int, tags
+		`SELECT time, server_id, measurement, field, value_double, value_int, tags, received_at
          FROM metric_points
          WHERE server_id = $1 AND measurement = $2 AND field = $3
            AND time > now() - $4::interval AND tags @> $5::jsonb
@@ -196,13 +302,55 @@ func (r *MetricsRepository) SeriesQuery(ctx context.Context, serverID, measureme
 	if err != nil {
 		return nil, false, err
 	}
+	return scanSeriesPoints(rows, limit)
+}
+
+// SeriesRate returns the per-second rate of a cumulative counter series
+// (net bytes_sent/bytes_recv, diskio read_bytes/write_bytes, swap in/out)
+// over the range, one point per stored point after the first of each tag
+// set. A value lower than the previous one means the counter was reset, e.g.
+// by a kiosk reboot; the rate is then taken from the value itself, as the
+// counter started again from zero. Callers only pass series the mapping
+// declares as counters.
+func (r *MetricsRepository) SeriesRate(ctx context.Context, serverID, measurement, field, rng, tagFilter string, limit, offset int) ([]models.SeriesPointResponse, bool, error) {
+	limitPlusOne := limit + 1
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT time, server_id, measurement, field,
+                (CASE WHEN v >= prev_v THEN v - prev_v ELSE v END) / EXTRACT(EPOCH FROM time - prev_time),
+                NULL::bigint, tags, received_at
+         FROM (
+           SELECT time, server_id, measurement, field, tags, received_at,
+                  COALESCE(value_double, value_int::double precision) AS v,
+                  LAG(COALESCE(value_double, value_int::double precision)) OVER w AS prev_v,
+                  LAG(time) OVER w AS prev_time
+           FROM metric_points
+           WHERE server_id = $1 AND measurement = $2 AND field = $3
+             AND time > now() - $4::interval AND tags @> $5::jsonb
+             AND (value_double IS NOT NULL OR value_int IS NOT NULL)
+           WINDOW w AS (PARTITION BY tags_hash ORDER BY time)
+         ) d
+         WHERE prev_time IS NOT NULL AND time > prev_time
+         ORDER BY time
+         LIMIT $6 OFFSET $7`,
+		serverID, measurement, field, rng, tagFilter, limitPlusOne, offset,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	return scanSeriesPoints(rows, limit)
+}
+
+// scanSeriesPoints reads and closes rows of (time, server_id, measurement,
+// field, value_double, value_int, tags, received_at), trimming the extra row
+// fetched to detect another page.
+func scanSeriesPoints(rows *sql.Rows, limit int) ([]models.SeriesPointResponse, bool, error) {
 	defer rows.Close()
 
 	var out []models.SeriesPointResponse
 	for rows.Next() {
 		var resp models.SeriesPointResponse
 		var tagsRaw []byte
-		if err := rows.Scan(&resp.Time, &resp.ServerID, &resp.Measurement, &resp.Field, &resp.ValueDouble, &resp.ValueInt, &tagsRaw); err != nil {
+		if err := rows.Scan(&resp.Time, &resp.ServerID, &resp.Measurement, &resp.Field, &resp.ValueDouble, &resp.ValueInt, &tagsRaw, &resp.ReceivedAt); err != nil {
 			return nil, false, err
 		}
 		var tags map[string]interface{}
@@ -369,7 +517,7 @@ func (r *MetricsRepository) LatestMetrics(ctx context.Context, limit, offset int
 	limitPlusOne := limit + 1
 	rows, err := r.db.QueryContext(ctx, `
         SELECT DISTINCT ON (server_id)
-            server_id, time, cpu, memory, temperature, chassis_temperature, hotspot_temperature,
+            server_id, time, received_at, cpu, memory, temperature, chassis_temperature, hotspot_temperature,
             power_online, battery_present, battery_charge_pct, battery_voltage_mv, battery_current_ma,
             sound_volume_percent, sound_muted,
             display_connected, display_width, display_height, display_refresh_hz, display_primary, display_dpms_enabled,
@@ -398,6 +546,7 @@ func (r *MetricsRepository) LatestMetrics(ctx context.Context, limit, offset int
 		if err := rows.Scan(
 			&m.ServerID,
 			&m.Time,
+			&m.ReceivedAt,
 			&m.CPU,
 			&m.Memory,
 			&m.Temperature,
@@ -474,7 +623,7 @@ func (r *MetricsRepository) LatestMetrics(ctx context.Context, limit, offset int
 func (r *MetricsRepository) HistoryMetrics(ctx context.Context, serverID, rng string, limit, offset int) ([]models.HistoryMetric, bool, error) {
 	limitPlusOne := limit + 1
 	rows, err := r.db.QueryContext(ctx, `
-        SELECT time, cpu, memory, temperature, chassis_temperature, hotspot_temperature,
+        SELECT time, received_at, cpu, memory, temperature, chassis_temperature, hotspot_temperature,
                power_online, battery_present, battery_charge_pct, battery_voltage_mv, battery_current_ma,
                sound_volume_percent, sound_muted,
                display_connected, display_width, display_height, display_refresh_hz, display_primary, display_dpms_enabled,
@@ -503,6 +652,7 @@ func (r *MetricsRepository) HistoryMetrics(ctx context.Context, serverID, rng st
 		var processStatusesJSON []byte
 		if err := rows.Scan(
 			&m.Time,
+			&m.ReceivedAt,
 			&m.CPU,
 			&m.Memory,
 			&m.Temperature,
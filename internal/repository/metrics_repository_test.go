@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// TestSeriesRate stores two counter series, one of them reset by a reboot,
+// and checks the per-second rates SeriesRate derives from them. It needs
+// TEST_DATABASE_URL (see series_points_test.go).
+func TestSeriesRate(t *testing.T) {
+	repo, serverID := testRepository(t)
+	ctx := context.Background()
+	base := time.Now().Add(-10 * time.Minute).Truncate(time.Second).UTC()
+
+	counter := func(offset time.Duration, iface string, value float64, asInt bool) models.SeriesPoint {
+		p := models.SeriesPoint{
+			Time:        base.Add(offset),
+			ServerID:    serverID,
+			Measurement: "net",
+			Field:       "bytes_recv",
+			TagsJSON:    []byte(`{"interface":"` + iface + `"}`),
+		}
+		if asInt {
+			v := int64(value)
+			p.ValueInt = &v
+		} else {
+			p.ValueDouble = &value
+		}
+		return p
+	}
+	points := []models.SeriesPoint{
+		counter(0, "eth0", 1000, true),
+		counter(10*time.Second, "eth0", 2000, true),
+		counter(20*time.Second, "eth0", 2500, true),
+		counter(30*time.Second, "eth0", 300, true), // reboot: counter restarts from zero
+		counter(40*time.Second, "eth0", 800, true),
+		counter(5*time.Second, "wlan0", 10, false),
+		counter(15*time.Second, "wlan0", 30, false),
+	}
+	if err := repo.SaveSeriesPoints(ctx, points); err != nil {
+		t.Fatal(err)
+	}
+
+	type rate struct {
+		offset time.Duration
+		iface  string
+		value  float64
+	}
+	tests := []struct {
+		name     string
+		tags     string
+		limit    int
+		offset   int
+		want     []rate
+		wantMore bool
+	}{
+		{
+			name:  "every tag set",
+			tags:  `{}`,
+			limit: 100,
+			want: []rate{
+				{10 * time.Second, "eth0", 100},
+				{15 * time.Second, "wlan0", 2},
+				{20 * time.Second, "eth0", 50},
+				{30 * time.Second, "eth0", 30},
+				{40 * time.Second, "eth0", 50},
+			},
+		},
+		{
+			name:  "one interface",
+			tags:  `{"interface":"wlan0"}`,
+			limit: 100,
+			want:  []rate{{15 * time.Second, "wlan0", 2}},
+		},
+		{
+			name:     "paged",
+			tags:     `{}`,
+			limit:    2,
+			offset:   1,
+			want:     []rate{{15 * time.Second, "wlan0", 2}, {20 * time.Second, "eth0", 50}},
+			wantMore: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, hasMore, err := repo.SeriesRate(ctx, serverID, "net", "bytes_recv", "1h", tt.tags, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasMore != tt.wantMore {
+				t.Errorf("hasMore = %v, want %v", hasMore, tt.wantMore)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d rates, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, w := range tt.want {
+				g := got[i]
+				if !g.Time.Equal(base.Add(w.offset)) || g.Tags["interface"] != w.iface {
+					t.Errorf("rate %d at %v for %v, want %v for %s", i, g.Time, g.Tags, base.Add(w.offset), w.iface)
+				}
+				if g.ValueDouble == nil || math.Abs(*g.ValueDouble-w.value) > 1e-9 {
+					t.Errorf("rate %d = %v, want %v", i, g.ValueDouble, w.value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"io"
+	"time"
+
+	"metrics-api/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// SaveRawPayloads gzips each payload and archives the batch in one INSERT.
+func (r *MetricsRepository) SaveRawPayloads(ctx context.Context, payloads []models.RawPayload) error {
+	if len(payloads) == 0 {
+		return nil
+	}
+
+	received := make([]string, len(payloads))
+	serverIDs := make([]string, len(payloads))
+	minTimes := make([]sql.NullString, len(payloads))
+	maxTimes := make([]sql.NullString, len(payloads))
+	bodies := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		received[i] = p.ReceivedAt.UTC().Format(time.RFC3339Nano)
+		serverIDs[i] = p.ServerID
+		if !p.MinTime.IsZero() {
+			minTimes[i] = sql.NullString{String: p.MinTime.UTC().Format(time.RFC3339Nano), Valid: true}
+		}
+		if !p.MaxTime.IsZero() {
+			maxTimes[i] = sql.NullString{String: p.MaxTime.UTC().Format(time.RFC3339Nano), Valid: true}
+		}
+		body, err := gzipBytes(p.Payload)
+		if err != nil {
+			return err
+		}
+		bodies[i] = body
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO raw_payloads(received_at, server_id, min_time, max_time, payload)
+         SELECT * FROM unnest($1::timestamptz[], $2::text[], $3::timestamptz[], $4::timestamptz[], $5::bytea[])`,
+		pq.Array(received), pq.Array(serverIDs), pq.Array(minTimes), pq.Array(maxTimes), pq.Array(bodies),
+	)
+	return err
+}
+
+// OldestRawPayload returns the receive time of the oldest archived payload.
+// ok is false when the archive is empty.
+func (r *MetricsRepository) OldestRawPayload(ctx context.Context) (time.Time, bool, error) {
+	var oldest sql.NullTime
+	if err := r.db.QueryRowContext(ctx, `SELECT min(received_at) FROM raw_payloads`).Scan(&oldest); err != nil {
+		return time.Time{}, false, err
+	}
+	return oldest.Time, oldest.Valid, nil
+}
+
+// RawPayloadServers lists the servers with archived metrics in [from, to).
+func (r *MetricsRepository) RawPayloadServers(ctx context.Context, from, to time.Time) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT DISTINCT server_id
+         FROM raw_payloads
+         WHERE max_time >= $1 AND min_time < $2
+         ORDER BY server_id`,
+		from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var serverID string
+		if err := rows.Scan(&serverID); err != nil {
+			return nil, err
+		}
+		out = append(out, serverID)
+	}
+	return out, rows.Err()
+}
+
+// ListRawPayloads returns up to limit archived payloads of serverIDs holding
+// metrics in [from, to), in (received_at, id) order after the given cursor,
+// with the payload decompressed.
+func (r *MetricsRepository) ListRawPayloads(ctx context.Context, serverIDs []string, from, to, afterReceived time.Time, afterID int64, limit int) ([]models.RawPayload, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, received_at, server_id, min_time, max_time, payload
+         FROM raw_payloads
+         WHERE server_id = ANY($1) AND max_time >= $2 AND min_time < $3
+           AND (received_at, id) > ($4, $5)
+         ORDER BY received_at, id
+         LIMIT $6`,
+		pq.Array(serverIDs), from, to, afterReceived, afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.RawPayload
+	for rows.Next() {
+		var p models.RawPayload
+		var minTime, maxTime sql.NullTime
+		var body []byte
+		if err := rows.Scan(&p.ID, &p.ReceivedAt, &p.ServerID, &minTime, &maxTime, &body); err != nil {
+			return nil, err
+		}
+		p.MinTime = minTime.Time
+		p.MaxTime = maxTime.Time
+		if p.Payload, err = gunzipBytes(body); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// DeleteMetricsWindow removes the summary rows and series points of serverIDs
+// with time in [from, to) and returns how many of each were deleted. Only
+// points from models.ArchivedSources are deleted: remote write and OTLP
+// points, and points stored before their source was recorded, cannot be
+// rebuilt from the archive. Summary rows only come from archived sources.
+func (r *MetricsRepository) DeleteMetricsWindow(ctx context.Context, serverIDs []string, from, to time.Time) (int64, int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM server_metrics WHERE server_id = ANY($1) AND time >= $2 AND time < $3`,
+		pq.Array(serverIDs), from, to,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	summaries, _ := res.RowsAffected()
+
+	res, err = tx.ExecContext(ctx,
+		`DELETE FROM metric_points WHERE server_id = ANY($1) AND time >= $2 AND time < $3 AND source = ANY($4)`,
+		pq.Array(serverIDs), from, to, pq.Array(models.ArchivedSources),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	points, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return summaries, points, nil
+}
+
+// SaveArchiveGaps stores gaps in the raw payload archive.
+func (r *MetricsRepository) SaveArchiveGaps(ctx context.Context, gaps []models.ArchiveGap) error {
+	if len(gaps) == 0 {
+		return nil
+	}
+
+	serverIDs := make([]string, len(gaps))
+	minTimes := make([]string, len(gaps))
+	maxTimes := make([]string, len(gaps))
+	payloads := make([]int64, len(gaps))
+	for i, g := range gaps {
+		serverIDs[i] = g.ServerID
+		minTimes[i] = g.MinTime.UTC().Format(time.RFC3339Nano)
+		maxTimes[i] = g.MaxTime.UTC().Format(time.RFC3339Nano)
+		payloads[i] = int64(g.Payloads)
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO archive_gaps(server_id, min_time, max_time, payloads)
+         SELECT * FROM unnest($1::text[], $2::timestamptz[], $3::timestamptz[], $4::integer[])`,
+		pq.Array(serverIDs), pq.Array(minTimes), pq.Array(maxTimes), pq.Array(payloads),
+	)
+	return err
+}
+
+// ArchiveGaps returns the stored archive gaps of serverIDs overlapping
+// [from, to), oldest first.
+func (r *MetricsRepository) ArchiveGaps(ctx context.Context, serverIDs []string, from, to time.Time) ([]models.ArchiveGap, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT server_id, min_time, max_time, payloads
+         FROM archive_gaps
+         WHERE server_id = ANY($1) AND max_time >= $2 AND min_time < $3
+         ORDER BY min_time, server_id`,
+		pq.Array(serverIDs), from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.ArchiveGap
+	for rows.Next() {
+		var g models.ArchiveGap
+		if err := rows.Scan(&g.ServerID, &g.MinTime, &g.MaxTime, &g.Payloads); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipBytes(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+// TestDeleteMetricsWindowKeepsUnarchivedSources checks that a reprocess
+// delete removes only points the raw payload archive can rebuild. It needs
+// TEST_DATABASE_URL (see series_points_test.go).
+func TestDeleteMetricsWindowKeepsUnarchivedSources(t *testing.T) {
+	repo, serverID := testRepository(t)
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+
+	sources := []string{"", models.SourceTelegraf, models.SourceImport, models.SourceReprocess, models.SourceRemoteWrite, models.SourceOTLP}
+	points := make([]models.SeriesPoint, len(sources))
+	for i, source := range sources {
+		v := float64(i)
+		points[i] = models.SeriesPoint{
+			Time:        base.Add(time.Duration(i) * time.Second),
+			ServerID:    serverID,
+			Measurement: "cpu",
+			Field:       "usage_idle",
+			ValueDouble: &v,
+			TagsJSON:    []byte(`{}`),
+			Source:      source,
+		}
+	}
+	if err := repo.SaveSeriesPoints(ctx, points); err != nil {
+		t.Fatal(err)
+	}
+
+	_, deleted, err := repo.DeleteMetricsWindow(ctx, []string{serverID}, base, base.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 3 {
+		t.Errorf("deleted %d points, want the 3 archived ones", deleted)
+	}
+
+	var kept []string
+	rows, err := repo.db.QueryContext(ctx,
+		`SELECT COALESCE(source, '') FROM metric_points WHERE server_id = $1 ORDER BY time`, serverID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		kept = append(kept, s)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", models.SourceRemoteWrite, models.SourceOTLP}
+	if len(kept) != len(want) || kept[0] != want[0] || kept[1] != want[1] || kept[2] != want[2] {
+		t.Errorf("kept sources %q, want %q", kept, want)
+	}
+}
+
+func TestArchiveGapsOverlap(t *testing.T) {
+	repo, serverID := testRepository(t)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		repo.db.Exec(`DELETE FROM archive_gaps WHERE server_id = $1`, serverID)
+	})
+	base := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+
+	gap := models.ArchiveGap{ServerID: serverID, MinTime: base, MaxTime: base.Add(time.Minute), Payloads: 4}
+	if err := repo.SaveArchiveGaps(ctx, []models.ArchiveGap{gap}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     int
+	}{
+		{"covers the gap", base.Add(-time.Hour), base.Add(time.Hour), 1},
+		{"starts at the gap end", base.Add(time.Minute), base.Add(time.Hour), 1},
+		{"ends at the gap start", base.Add(-time.Hour), base, 0},
+		{"after the gap", base.Add(2 * time.Minute), base.Add(time.Hour), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.ArchiveGaps(ctx, []string{serverID}, tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want {
+				t.Fatalf("%d gaps, want %d", len(got), tt.want)
+			}
+			if tt.want == 1 && (got[0].Payloads != 4 || !got[0].MinTime.Equal(gap.MinTime)) {
+				t.Errorf("gap = %+v, want %+v", got[0], gap)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"metrics-api/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// copyMinPoints is the batch size from which SaveSeriesPoints switches to
+// COPY. COPY needs its own round-trips to open a transaction, stage the rows
+// and move them over, so small batches go in a single INSERT carrying every
+// row as arrays instead. BenchmarkSaveSeriesPointsUnnest and
+// BenchmarkSaveSeriesPointsCopy measure both paths per batch size; re-check
+// the threshold with them against a real database before changing it.
+const copyMinPoints = 200
+
+// seriesPointsOnConflict skips points that are already stored, so a retried
+// batch (Telegraf resend, dead-letter retry, WAL replay) is a no-op. The
+// series identity includes md5(tags::text); jsonb prints keys in a fixed
+// order, so equal tag sets hash the same.
+const seriesPointsOnConflict = `ON CONFLICT (server_id, measurement, field, tags_hash, time) DO NOTHING`
+
+// insertSeriesPoints sends every row in one INSERT ... SELECT FROM unnest(...)
+// statement, so a batch costs one round-trip regardless of its size. Times
+// travel as text because pq cannot encode time.Time array elements.
+func (r *MetricsRepository) insertSeriesPoints(ctx context.Context, points []models.SeriesPoint) error {
+	times := make([]string, len(points))
+	serverIDs := make([]string, len(points))
+	measurements := make([]string, len(points))
+	fields := make([]string, len(points))
+	doubles := make([]sql.NullFloat64, len(points))
+	ints := make([]sql.NullInt64, len(points))
+	tags := make([]string, len(points))
+	received := make([]sql.NullString, len(points))
+	sources := make([]sql.NullString, len(points))
+	for i, p := range points {
+		times[i] = p.Time.UTC().Format(time.RFC3339Nano)
+		serverIDs[i] = p.ServerID
+		measurements[i] = p.Measurement
+		fields[i] = p.Field
+		if p.ValueDouble != nil {
+			doubles[i] = sql.NullFloat64{Float64: *p.ValueDouble, Valid: true}
+		}
+		if p.ValueInt != nil {
+			ints[i] = sql.NullInt64{Int64: *p.ValueInt, Valid: true}
+		}
+		tags[i] = string(p.TagsJSON)
+		if !p.ReceivedAt.IsZero() {
+			received[i] = sql.NullString{String: p.ReceivedAt.UTC().Format(time.RFC3339Nano), Valid: true}
+		}
+		if p.Source != "" {
+			sources[i] = sql.NullString{String: p.Source, Valid: true}
+		}
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO metric_points(time, server_id, measurement, field, value_double, value_int, tags, received_at, source, tags_hash)
+         SELECT t.*, md5(t.tags::text)
+         FROM unnest($1::timestamptz[], $2::text[], $3::text[], $4::text[], $5::double precision[], $6::bigint[], $7::jsonb[], $8::timestamptz[], $9::text[])
+           AS t(time, server_id, measurement, field, value_double, value_int, tags, received_at, source)
+         `+seriesPointsOnConflict,
+		pq.Array(times), pq.Array(serverIDs), pq.Array(measurements), pq.Array(fields),
+		pq.Array(doubles), pq.Array(ints), pq.Array(tags), pq.Array(received), pq.Array(sources),
+	)
+	return err
+}
+
+// copySeriesPoints streams the rows with COPY FROM STDIN into a temporary
+// staging table and moves them into metric_points in one statement, since
+// COPY itself cannot skip rows that are already stored.
+func (r *MetricsRepository) copySeriesPoints(ctx context.Context, points []models.SeriesPoint) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`CREATE TEMP TABLE metric_points_stage (LIKE metric_points INCLUDING DEFAULTS) ON COMMIT DROP`,
+	); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("metric_points_stage", "time", "server_id", "measurement", "field", "value_double", "value_int", "tags", "received_at", "source"))
+	if err != nil {
+		return err
+	}
+
+	for _, p := range points {
+		var double, integer, received, source interface{}
+		if p.ValueDouble != nil {
+			double = *p.ValueDouble
+		}
+		if p.ValueInt != nil {
+			integer = *p.ValueInt
+		}
+		if !p.ReceivedAt.IsZero() {
+			received = p.ReceivedAt
+		}
+		if p.Source != "" {
+			source = p.Source
+		}
+		if _, err := stmt.ExecContext(ctx, p.Time, p.ServerID, p.Measurement, p.Field, double, integer, string(p.TagsJSON), received, source); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	// The final Exec flushes the buffered rows and completes the COPY.
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO metric_points(time, server_id, measurement, field, value_double, value_int, tags, received_at, source, tags_hash)
+         SELECT time, server_id, measurement, field, value_double, value_int, tags, received_at, source, md5(tags::text)
+         FROM metric_points_stage
+         `+seriesPointsOnConflict,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
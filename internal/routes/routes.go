@@ -5,16 +5,32 @@ import "net/http"
 type Middleware func(http.HandlerFunc) http.HandlerFunc
 
 type Handlers struct {
-	Root              http.HandlerFunc
-	Ingest            http.HandlerFunc
-	Servers           http.HandlerFunc
-	ServersStatus     http.HandlerFunc
-	ServersStatusCity http.HandlerFunc
-	MetricsLatest     http.HandlerFunc
-	MetricsHistory    http.HandlerFunc
-	SeriesList        http.HandlerFunc
-	SeriesLatest      http.HandlerFunc
-	SeriesQuery       http.HandlerFunc
+	Root                  http.HandlerFunc
+	Ingest                http.HandlerFunc
+	Import                http.HandlerFunc
+	Write                 http.HandlerFunc
+	RemoteWrite           http.HandlerFunc
+	OTLPMetrics           http.HandlerFunc
+	Servers               http.HandlerFunc
+	ServersStatus         http.HandlerFunc
+	ServersStatusCity     http.HandlerFunc
+	ServersClockSkew      http.HandlerFunc
+	MetricsLatest         http.HandlerFunc
+	MetricsHistory        http.HandlerFunc
+	SeriesList            http.HandlerFunc
+	SeriesLatest          http.HandlerFunc
+	SeriesQuery           http.HandlerFunc
+	AdminTokens           http.HandlerFunc
+	AdminTokensRotate     http.HandlerFunc
+	AdminTokensRevoke     http.HandlerFunc
+	AdminSecrets          http.HandlerFunc
+	AdminSecretsRevoke    http.HandlerFunc
+	AdminDeadLetters      http.HandlerFunc
+	AdminDeadLettersRetry http.HandlerFunc
+	AdminDeadLettersPurge http.HandlerFunc
+	AdminQueue            http.HandlerFunc
+	AdminReprocess        http.HandlerFunc
+	AdminTags             http.HandlerFunc
 }
 
 func Register(mux *http.ServeMux, mw Middleware, handlers Handlers) {
@@ -34,12 +50,28 @@ func Register(mux *http.ServeMux, mw Middleware, handlers Handlers) {
 
 	add("/", handlers.Root)
 	add("/api/metrics", handlers.Ingest)
+	add("/api/metrics/import", handlers.Import)
+	add("/api/write", handlers.Write)
+	add("/api/v1/write", handlers.RemoteWrite)
+	add("/v1/metrics", handlers.OTLPMetrics)
 	add("/api/servers", handlers.Servers)
 	add("/api/servers/status", handlers.ServersStatus)
 	add("/api/servers/status/city", handlers.ServersStatusCity)
+	add("/api/servers/clock-skew", handlers.ServersClockSkew)
 	add("/api/metrics/latest", handlers.MetricsLatest)
 	add("/api/metrics/history", handlers.MetricsHistory)
 	add("/api/series", handlers.SeriesList)
 	add("/api/series/latest", handlers.SeriesLatest)
 	add("/api/series/query", handlers.SeriesQuery)
+	add("/api/admin/tokens", handlers.AdminTokens)
+	add("/api/admin/tokens/rotate", handlers.AdminTokensRotate)
+	add("/api/admin/tokens/revoke", handlers.AdminTokensRevoke)
+	add("/api/admin/secrets", handlers.AdminSecrets)
+	add("/api/admin/secrets/revoke", handlers.AdminSecretsRevoke)
+	add("/api/admin/deadletter", handlers.AdminDeadLetters)
+	add("/api/admin/deadletter/retry", handlers.AdminDeadLettersRetry)
+	add("/api/admin/deadletter/purge", handlers.AdminDeadLettersPurge)
+	add("/api/admin/queue", handlers.AdminQueue)
+	add("/api/admin/reprocess", handlers.AdminReprocess)
+	add("/api/admin/tags", handlers.AdminTags)
 }
@@ -0,0 +1,549 @@
+// Package wal is a segmented write-ahead log for series points waiting in
+// the in-memory writer queue. Ingest appends a request's points (and fsyncs)
+// before acknowledging it; the writers release points once SaveSeriesPoints
+// has committed them, and a segment file is deleted when it is sealed and
+// none of its points are pending. Each segment has a checkpoint file with the
+// offset before which every record is released. Segments left over from a
+// previous run are replayed on startup from their checkpoint. A segment with
+// corrupt records is replayed around them and then kept aside with a
+// .corrupt suffix instead of being deleted.
+package wal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+const (
+	defaultSegmentBytes = 64 << 20
+	segmentSuffix       = ".wal"
+	corruptSuffix       = ".corrupt"
+	checkpointSuffix    = ".ckpt"
+	headerSize          = 8
+	// maxRecordBytes guards replay against a corrupt length prefix.
+	maxRecordBytes = 256 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options tunes a WAL. Zero values fall back to defaults.
+type Options struct {
+	// SegmentBytes is the size after which the active segment is sealed and
+	// a new one started.
+	SegmentBytes int64
+}
+
+type segment struct {
+	pending int
+	sealed  bool
+	// records are the records appended or replayed that are not behind the
+	// checkpoint yet, oldest first; end is the offset after the last one.
+	records []walRecord
+	end     int64
+	// checkpoint is the offset last written to the checkpoint file.
+	checkpoint int64
+}
+
+type walRecord struct {
+	offset  int64
+	pending int
+}
+
+type recordKey struct {
+	segment uint64
+	offset  int64
+}
+
+// WAL is a directory of numbered segment files. Each record is a 4-byte
+// length, a 4-byte CRC-32C and a JSON array of points.
+type WAL struct {
+	dir          string
+	segmentBytes int64
+
+	mu         sync.Mutex
+	active     *os.File
+	activeID   uint64
+	activeSize int64
+	segments   map[uint64]*segment
+	replay     []uint64
+
+	corruptRecords int
+	skippedBytes   int64
+	quarantined    int
+}
+
+// Stats describes the WAL. CorruptRecords and SkippedBytes count what replay
+// had to skip since startup; Quarantined is the number of .corrupt segment
+// files in the directory.
+type Stats struct {
+	Segments       int   `json:"segments"`
+	Pending        int   `json:"pending"`
+	CorruptRecords int   `json:"corrupt_records"`
+	SkippedBytes   int64 `json:"skipped_bytes"`
+	Quarantined    int   `json:"quarantined_segments"`
+}
+
+// Open creates dir if needed and starts a new active segment after any
+// segments left from a previous run; those are kept for Replay.
+func Open(dir string, opts Options) (*WAL, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = defaultSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	w := &WAL{dir: dir, segmentBytes: opts.SegmentBytes, segments: make(map[uint64]*segment)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var last uint64
+	for _, de := range entries {
+		if strings.HasSuffix(de.Name(), segmentSuffix+corruptSuffix) {
+			w.quarantined++
+			continue
+		}
+		id, ok := parseSegmentName(de.Name())
+		if !ok {
+			continue
+		}
+		// Held open (pending 1) until Replay has queued its points.
+		w.segments[id] = &segment{pending: 1, sealed: true}
+		w.replay = append(w.replay, id)
+		if id > last {
+			last = id
+		}
+	}
+	sort.Slice(w.replay, func(i, j int) bool { return w.replay[i] < w.replay[j] })
+
+	if err := w.openSegment(last + 1); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Append writes points as one record, syncs it to disk, and marks each point
+// with its segment. The points stay pending until Release.
+func (w *WAL) Append(points []models.SeriesPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(encodePoints(points))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	copy(buf[headerSize:], payload)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.activeSize > 0 && w.activeSize+int64(len(buf)) > w.segmentBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := w.active.Write(buf); err != nil {
+		return err
+	}
+	if err := w.active.Sync(); err != nil {
+		return err
+	}
+	offset := w.activeSize
+	w.activeSize += int64(len(buf))
+	s := w.segments[w.activeID]
+	s.pending += len(points)
+	s.records = append(s.records, walRecord{offset: offset, pending: len(points)})
+	s.end = w.activeSize
+
+	for i := range points {
+		points[i].WALSegment = w.activeID
+		points[i].WALOffset = offset
+	}
+	return nil
+}
+
+// Release marks points as durably stored elsewhere (committed to the
+// database, or spooled to the dead-letter directory). Points without a
+// segment are ignored.
+func (w *WAL) Release(points []models.SeriesPoint) {
+	counts := make(map[recordKey]int)
+	for _, p := range points {
+		if p.WALSegment != 0 {
+			counts[recordKey{p.WALSegment, p.WALOffset}]++
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key, n := range counts {
+		w.release(key.segment, key.offset, n)
+	}
+}
+
+// Replay reads the segments left by a previous run, oldest first and from
+// their checkpoint on, and passes each record's points (already marked with
+// their segment) to fn. fn must
+// eventually Release them, typically by queueing them for the writers. A
+// torn record at the end of a segment, left by a crash mid-append, ends that
+// segment. A corrupt record is skipped up to the next record with a valid
+// checksum, and the segment is renamed to .corrupt once replayed.
+func (w *WAL) Replay(fn func([]models.SeriesPoint)) (int, error) {
+	w.mu.Lock()
+	ids := w.replay
+	w.replay = nil
+	w.mu.Unlock()
+
+	total := 0
+	for _, id := range ids {
+		n, err := w.replaySegment(id, fn)
+		total += n
+		w.mu.Lock()
+		w.release(id, -1, 1)
+		w.mu.Unlock()
+		if err != nil {
+			return total, fmt.Errorf("segment %d: %w", id, err)
+		}
+	}
+	return total, nil
+}
+
+// Stats returns the number of segment files, the points not yet released and
+// the replay losses.
+func (w *WAL) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := Stats{
+		Segments:       len(w.segments),
+		CorruptRecords: w.corruptRecords,
+		SkippedBytes:   w.skippedBytes,
+		Quarantined:    w.quarantined,
+	}
+	for _, s := range w.segments {
+		st.Pending += s.pending
+	}
+	return st
+}
+
+// Close closes the active segment. Pending points stay on disk for replay.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.active.Close()
+}
+
+func (w *WAL) replaySegment(id uint64, fn func([]models.SeriesPoint)) (int, error) {
+	data, err := os.ReadFile(w.path(id))
+	if err != nil {
+		return 0, err
+	}
+	start := readCheckpoint(w.checkpointPath(id))
+	if start > int64(len(data)) {
+		start = int64(len(data))
+	}
+	w.mu.Lock()
+	w.segments[id].checkpoint = start
+	w.segments[id].end = start
+	w.mu.Unlock()
+
+	total := 0
+	corrupt := 0
+	var skipped int64
+	for off := int(start); off < len(data); {
+		payload, n, torn := readRecord(data[off:])
+		if payload == nil {
+			next := resync(data, off+1)
+			if next < 0 && torn {
+				log.Printf("wal: segment %d: torn record at offset %d, stopping replay of this segment", id, off)
+				break
+			}
+			if next < 0 {
+				next = len(data)
+			}
+			log.Printf("wal: segment %d: corrupt record at offset %d, skipping %d bytes", id, off, next-off)
+			corrupt++
+			skipped += int64(next - off)
+			off = next
+			continue
+		}
+		recordOffset := int64(off)
+		off += n
+
+		var recs []record
+		if err := json.Unmarshal(payload, &recs); err != nil {
+			log.Printf("wal: segment %d: undecodable record skipped: %v", id, err)
+			corrupt++
+			skipped += int64(n)
+			continue
+		}
+		points := decodePoints(recs, id, recordOffset)
+
+		w.mu.Lock()
+		s := w.segments[id]
+		s.pending += len(points)
+		s.records = append(s.records, walRecord{offset: recordOffset, pending: len(points)})
+		s.end = int64(off)
+		w.mu.Unlock()
+
+		fn(points)
+		total += len(points)
+	}
+
+	if corrupt > 0 {
+		w.mu.Lock()
+		w.corruptRecords += corrupt
+		w.skippedBytes += skipped
+		w.mu.Unlock()
+		w.quarantineSegment(id)
+	}
+	return total, nil
+}
+
+// readRecord returns the payload of the record at the start of b and the
+// record's size. payload is nil when the record is invalid; torn is then true
+// if b ends before the record does, as after a crash mid-append.
+func readRecord(b []byte) (payload []byte, n int, torn bool) {
+	if len(b) < headerSize {
+		return nil, 0, true
+	}
+	size := binary.LittleEndian.Uint32(b[0:4])
+	sum := binary.LittleEndian.Uint32(b[4:8])
+	if size == 0 || size > maxRecordBytes {
+		return nil, 0, false
+	}
+	n = headerSize + int(size)
+	if n > len(b) {
+		return nil, 0, true
+	}
+	payload = b[headerSize:n]
+	if crc32.Checksum(payload, crcTable) != sum {
+		return nil, 0, false
+	}
+	return payload, n, false
+}
+
+// resync returns the offset of the first valid record at or after from, or
+// -1 when there is none.
+func resync(data []byte, from int) int {
+	for off := from; off+headerSize < len(data); off++ {
+		if payload, _, _ := readRecord(data[off:]); payload != nil {
+			return off
+		}
+	}
+	return -1
+}
+
+// quarantineSegment keeps a replayed segment with corrupt records aside for
+// inspection; release then finds the file gone and only forgets it.
+func (w *WAL) quarantineSegment(id uint64) {
+	path := w.path(id)
+	if err := os.Rename(path, path+corruptSuffix); err != nil {
+		log.Printf("wal: quarantine segment %d: %v", id, err)
+		return
+	}
+	log.Printf("wal: segment %d kept as %s", id, filepath.Base(path+corruptSuffix))
+	os.Remove(w.checkpointPath(id))
+	w.mu.Lock()
+	w.quarantined++
+	w.mu.Unlock()
+}
+
+// release must be called with w.mu held. offset is the start of the record
+// holding the points, or -1 for Replay's hold on the segment.
+func (w *WAL) release(id uint64, offset int64, n int) {
+	s, ok := w.segments[id]
+	if !ok {
+		return
+	}
+	s.pending -= n
+	if s.pending < 0 {
+		s.pending = 0
+	}
+	if offset >= 0 {
+		i := sort.Search(len(s.records), func(i int) bool { return s.records[i].offset >= offset })
+		if i < len(s.records) && s.records[i].offset == offset {
+			s.records[i].pending -= n
+		}
+	}
+	if s.sealed && s.pending == 0 {
+		w.removeSegment(id)
+		return
+	}
+	w.advanceCheckpoint(id, s)
+}
+
+// advanceCheckpoint forgets the released records at the start of the
+// segment and writes the offset of the first pending one (or the end) to its
+// checkpoint file, so a restart does not replay committed points. The file is
+// not synced: a stale checkpoint only means more points are replayed. It
+// must be called with w.mu held.
+func (w *WAL) advanceCheckpoint(id uint64, s *segment) {
+	i := 0
+	for i < len(s.records) && s.records[i].pending <= 0 {
+		i++
+	}
+	s.records = s.records[i:]
+	committed := s.end
+	if len(s.records) > 0 {
+		committed = s.records[0].offset
+	}
+	if committed <= s.checkpoint {
+		return
+	}
+	if err := writeCheckpoint(w.checkpointPath(id), committed); err != nil {
+		log.Printf("wal: checkpoint segment %d: %v", id, err)
+		return
+	}
+	s.checkpoint = committed
+}
+
+func writeCheckpoint(path string, offset int64) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readCheckpoint returns the checkpoint offset, or 0 when the file is missing
+// or unreadable.
+func readCheckpoint(path string) int64 {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// rotate must be called with w.mu held.
+func (w *WAL) rotate() error {
+	oldID := w.activeID
+	if err := w.active.Close(); err != nil {
+		return err
+	}
+	if err := w.openSegment(oldID + 1); err != nil {
+		return err
+	}
+	old := w.segments[oldID]
+	old.sealed = true
+	if old.pending == 0 {
+		w.removeSegment(oldID)
+	}
+	return nil
+}
+
+func (w *WAL) openSegment(id uint64) error {
+	f, err := os.OpenFile(w.path(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.active = f
+	w.activeID = id
+	w.activeSize = info.Size()
+	w.segments[id] = &segment{}
+	return nil
+}
+
+func (w *WAL) removeSegment(id uint64) {
+	if err := os.Remove(w.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("wal: remove segment %d: %v", id, err)
+		return
+	}
+	os.Remove(w.checkpointPath(id))
+	delete(w.segments, id)
+}
+
+func (w *WAL) path(id uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%016d%s", id, segmentSuffix))
+}
+
+func (w *WAL) checkpointPath(id uint64) string {
+	return w.path(id) + checkpointSuffix
+}
+
+func parseSegmentName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, segmentSuffix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// record is the on-disk form of models.SeriesPoint.
+type record struct {
+	Time        time.Time       `json:"t"`
+	ServerID    string          `json:"s"`
+	Measurement string          `json:"m"`
+	Field       string          `json:"f"`
+	ValueDouble *float64        `json:"d,omitempty"`
+	ValueInt    *int64          `json:"i,omitempty"`
+	Tags        json.RawMessage `json:"g"`
+	ReceivedAt  *time.Time      `json:"r,omitempty"`
+	Source      string          `json:"o,omitempty"`
+}
+
+func encodePoints(points []models.SeriesPoint) []record {
+	out := make([]record, len(points))
+	for i, p := range points {
+		tags := json.RawMessage(p.TagsJSON)
+		if len(tags) == 0 {
+			tags = json.RawMessage(`{}`)
+		}
+		out[i] = record{p.Time, p.ServerID, p.Measurement, p.Field, p.ValueDouble, p.ValueInt, tags, nil, p.Source}
+		if !p.ReceivedAt.IsZero() {
+			received := p.ReceivedAt
+			out[i].ReceivedAt = &received
+		}
+	}
+	return out
+}
+
+func decodePoints(recs []record, segmentID uint64, offset int64) []models.SeriesPoint {
+	out := make([]models.SeriesPoint, len(recs))
+	for i, r := range recs {
+		out[i] = models.SeriesPoint{
+			Time:        r.Time,
+			ServerID:    r.ServerID,
+			Measurement: r.Measurement,
+			Field:       r.Field,
+			ValueDouble: r.ValueDouble,
+			ValueInt:    r.ValueInt,
+			TagsJSON:    []byte(r.Tags),
+			WALSegment:  segmentID,
+			WALOffset:   offset,
+			Source:      r.Source,
+		}
+		if r.ReceivedAt != nil {
+			out[i].ReceivedAt = *r.ReceivedAt
+		}
+	}
+	return out
+}
@@ -0,0 +1,282 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"metrics-api/internal/models"
+)
+
+func testPoint(field string, v int64) models.SeriesPoint {
+	return models.SeriesPoint{
+		Time:        time.Unix(1700000000, 0).UTC(),
+		ServerID:    "kiosk-1",
+		Measurement: "cpu",
+		Field:       field,
+		ValueInt:    &v,
+		TagsJSON:    []byte(`{}`),
+	}
+}
+
+// writeSegment appends one record per field and returns the segment path and
+// the offset at which each record starts.
+func writeSegment(t *testing.T, dir string, fields []string) (string, []int64) {
+	t.Helper()
+	w, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for i, f := range fields {
+		offsets = append(offsets, w.activeSize)
+		if err := w.Append([]models.SeriesPoint{testPoint(f, int64(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := w.path(w.activeID)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, offsets
+}
+
+func replayFields(t *testing.T, w *WAL) []string {
+	t.Helper()
+	var fields []string
+	_, err := w.Replay(func(points []models.SeriesPoint) {
+		for _, p := range points {
+			fields = append(fields, p.Field)
+		}
+		w.Release(points)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name           string
+		damage         func(t *testing.T, path string, offsets []int64)
+		wantFields     []string
+		wantCorrupt    int
+		wantQuarantine bool
+	}{
+		{
+			name:       "clean",
+			damage:     func(*testing.T, string, []int64) {},
+			wantFields: []string{"a", "b", "c"},
+		},
+		{
+			name: "torn tail",
+			damage: func(t *testing.T, path string, _ []int64) {
+				fi, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Truncate(path, fi.Size()-3); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantFields: []string{"a", "b"},
+		},
+		{
+			name: "corrupt payload in the middle",
+			damage: func(t *testing.T, path string, offsets []int64) {
+				flipByte(t, path, offsets[1]+headerSize+2)
+			},
+			wantFields:     []string{"a", "c"},
+			wantCorrupt:    1,
+			wantQuarantine: true,
+		},
+		{
+			name: "corrupt length in the middle",
+			damage: func(t *testing.T, path string, offsets []int64) {
+				flipByte(t, path, offsets[1]+3)
+			},
+			wantFields:     []string{"a", "c"},
+			wantCorrupt:    1,
+			wantQuarantine: true,
+		},
+		{
+			name: "corrupt last record",
+			damage: func(t *testing.T, path string, offsets []int64) {
+				flipByte(t, path, offsets[2]+headerSize+2)
+			},
+			wantFields:     []string{"a", "b"},
+			wantCorrupt:    1,
+			wantQuarantine: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path, offsets := writeSegment(t, dir, []string{"a", "b", "c"})
+			tt.damage(t, path, offsets)
+
+			w, err := Open(dir, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			got := replayFields(t, w)
+			if len(got) != len(tt.wantFields) {
+				t.Fatalf("replayed %v, want %v", got, tt.wantFields)
+			}
+			for i := range got {
+				if got[i] != tt.wantFields[i] {
+					t.Fatalf("replayed %v, want %v", got, tt.wantFields)
+				}
+			}
+
+			st := w.Stats()
+			if st.CorruptRecords != tt.wantCorrupt {
+				t.Errorf("CorruptRecords = %d, want %d", st.CorruptRecords, tt.wantCorrupt)
+			}
+			if tt.wantCorrupt > 0 && st.SkippedBytes == 0 {
+				t.Errorf("SkippedBytes = 0, want > 0")
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("segment still present after replay: %v", err)
+			}
+			_, err = os.Stat(path + corruptSuffix)
+			if quarantined := err == nil; quarantined != tt.wantQuarantine {
+				t.Errorf("quarantined = %v, want %v", quarantined, tt.wantQuarantine)
+			}
+			if st.Quarantined != boolToInt(tt.wantQuarantine) {
+				t.Errorf("Quarantined = %d, want %d", st.Quarantined, boolToInt(tt.wantQuarantine))
+			}
+		})
+	}
+}
+
+func TestReplayKeepsPoint(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := testPoint("a", 7)
+	p.TagsJSON = []byte(`{"interface":"eth0"}`)
+	p.ReceivedAt = p.Time.Add(time.Second)
+	p.Source = models.SourceTelegraf
+	if err := w.Append([]models.SeriesPoint{p}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var got []models.SeriesPoint
+	if _, err := w.Replay(func(points []models.SeriesPoint) { got = append(got, points...) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("replayed %d points, want 1", len(got))
+	}
+	g := got[0]
+	if !g.Time.Equal(p.Time) || g.ServerID != p.ServerID || *g.ValueInt != 7 || string(g.TagsJSON) != string(p.TagsJSON) ||
+		!g.ReceivedAt.Equal(p.ReceivedAt) || g.Source != p.Source || g.WALSegment == 0 {
+		t.Errorf("replayed %+v, want %+v", g, p)
+	}
+}
+
+func TestOpenCountsQuarantinedSegments(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "0000000000000001.wal.corrupt"), []byte("x"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got := replayFields(t, w); len(got) != 0 {
+		t.Fatalf("replayed %v from a quarantined segment", got)
+	}
+	if st := w.Stats(); st.Quarantined != 1 {
+		t.Fatalf("Quarantined = %d, want 1", st.Quarantined)
+	}
+}
+
+func TestRestartAfterRelease(t *testing.T) {
+	tests := []struct {
+		name       string
+		release    []string
+		wantFields []string
+	}{
+		{name: "nothing released", wantFields: []string{"a", "b", "c"}},
+		{name: "all released", release: []string{"a", "b", "c"}},
+		{name: "oldest released", release: []string{"a"}, wantFields: []string{"b", "c"}},
+		{name: "middle released", release: []string{"b"}, wantFields: []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := Open(dir, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			written := make(map[string][]models.SeriesPoint)
+			for i, f := range []string{"a", "b", "c"} {
+				points := []models.SeriesPoint{testPoint(f, int64(i))}
+				if err := w.Append(points); err != nil {
+					t.Fatal(err)
+				}
+				written[f] = points
+			}
+			for _, f := range tt.release {
+				w.Release(written[f])
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			w, err = Open(dir, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			got := replayFields(t, w)
+			if len(got) != len(tt.wantFields) {
+				t.Fatalf("replayed %v, want %v", got, tt.wantFields)
+			}
+			for i := range got {
+				if got[i] != tt.wantFields[i] {
+					t.Fatalf("replayed %v, want %v", got, tt.wantFields)
+				}
+			}
+			if n := w.Stats().Segments; n != 1 {
+				t.Errorf("segments after replay = %d, want only the active one", n)
+			}
+		})
+	}
+}
+
+func flipByte(t *testing.T, path string, off int64) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[off] ^= 0xff
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
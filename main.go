@@ -8,17 +8,22 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	dbpkg "metrics-api/internal/db"
+	"metrics-api/internal/deadletter"
 	"metrics-api/internal/handlers"
+	"metrics-api/internal/ingest"
 	"metrics-api/internal/models"
 	"metrics-api/internal/repository"
 	"metrics-api/internal/routes"
+	"metrics-api/internal/wal"
 
 	_ "github.com/lib/pq"
 )
@@ -26,8 +31,13 @@ import (
 var (
 	db               *sql.DB
 	metricPointsChan chan models.SeriesPoint
+	summaryChan      chan models.CleanMetric
+	archiveChan      chan models.RawPayload
+	archiveGaps      *handlers.ArchiveGaps
 	limiter          *rateLimiter
 	metricsRepo      *repository.MetricsRepository
+	deadLetters      *deadletter.Spool
+	pointsWAL        *wal.WAL
 )
 
 const (
@@ -35,6 +45,36 @@ const (
 	defaultWriterBatchSize   = 1000
 	defaultWriterFlushSec    = 1
 	defaultWriterWorkerCount = 2
+
+	defaultSummaryBufferSize  = 2000
+	defaultSummaryBatchSize   = 200
+	defaultSummaryFlushSec    = 1
+	defaultSummaryWorkerCount = 1
+
+	defaultArchiveBufferSize = 2000
+	defaultArchiveBatchSize  = 100
+	defaultArchiveFlushSec   = 2
+
+	defaultIngestMaxBodyBytes = 32 << 20
+	defaultImportMaxBytes     = 1 << 30
+	defaultImportConcurrency  = 2
+	defaultHMACMaxSkewSec     = 300
+	defaultIntervalWidthSec   = 10
+	defaultClockSkewSec       = 300
+	defaultTagSetWindowHours  = 24
+	defaultTagSeriesPerServer = 10000
+
+	defaultDeadLetterMaxBytes      = 512 << 20
+	defaultDeadLetterRetrySec      = 30
+	defaultDeadLetterMaxBackoffSec = 900
+	defaultDeadLetterMaxAttempts   = 10
+	defaultDataDir                 = "/var/lib/metrics-api"
+	deadLetterPollInterval         = 5 * time.Second
+
+	defaultWALSegmentBytes = 64 << 20
+
+	defaultOverflowTimeoutSec = 2
+	defaultRetryAfterSec      = 10
 )
 
 func getEnv(key, fallback string) string {
@@ -176,11 +216,202 @@ func flushBatch(batch []models.SeriesPoint) {
 		return
 	}
 
-	if err := metricsRepo.SaveSeriesPoints(context.Background(), batch); err != nil {
+	err := metricsRepo.SaveSeriesPoints(context.Background(), batch)
+	if err == nil {
+		releaseWAL(batch)
+		return
+	}
+	if deadLetters == nil {
 		log.Println("batch: insert err:", err)
+		return
+	}
+
+	entry, spoolErr := deadLetters.Put(batch, err)
+	if spoolErr != nil {
+		log.Printf("batch: insert err: %v; dead-letter spool failed: %v", err, spoolErr)
+		return
+	}
+	log.Printf("batch: insert err: %v; %d points dead-lettered as %s", err, len(batch), entry.ID)
+	releaseWAL(batch)
+}
+
+func startSummaryWriters(workers int, cfg metricWriterConfig) {
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go summaryWriter(cfg)
+	}
+}
+
+func summaryWriter(cfg metricWriterConfig) {
+	if cfg.batchSize <= 0 {
+		cfg.batchSize = defaultSummaryBatchSize
+	}
+	if cfg.flushEvery <= 0 {
+		cfg.flushEvery = time.Duration(defaultSummaryFlushSec) * time.Second
+	}
+
+	ticker := time.NewTicker(cfg.flushEvery)
+	defer ticker.Stop()
+
+	buffer := make([]models.CleanMetric, 0, cfg.batchSize)
+
+	for {
+		select {
+		case m := <-summaryChan:
+			buffer = append(buffer, m)
+			if len(buffer) >= cfg.batchSize {
+				flushSummaries(buffer)
+				buffer = buffer[:0]
+			}
+		case <-ticker.C:
+			if len(buffer) > 0 {
+				flushSummaries(buffer)
+				buffer = buffer[:0]
+			}
+		}
+	}
+}
+
+func flushSummaries(batch []models.CleanMetric) {
+	if len(batch) == 0 || metricsRepo == nil {
+		return
+	}
+	err := metricsRepo.SaveMetrics(context.Background(), batch)
+	if err == nil {
+		return
+	}
+	if deadLetters == nil {
+		log.Printf("summary batch: upsert err: %v; dropping %d rows", err, len(batch))
+		return
+	}
+
+	entry, spoolErr := deadLetters.PutSummaries(batch, err)
+	if spoolErr != nil {
+		log.Printf("summary batch: upsert err: %v; dead-letter spool failed, dropping %d rows: %v", err, len(batch), spoolErr)
+		return
+	}
+	log.Printf("summary batch: upsert err: %v; %d rows dead-lettered as %s", err, len(batch), entry.ID)
+}
+
+func archiveWriter(cfg metricWriterConfig) {
+	if cfg.batchSize <= 0 {
+		cfg.batchSize = defaultArchiveBatchSize
+	}
+	if cfg.flushEvery <= 0 {
+		cfg.flushEvery = time.Duration(defaultArchiveFlushSec) * time.Second
+	}
+
+	ticker := time.NewTicker(cfg.flushEvery)
+	defer ticker.Stop()
+
+	buffer := make([]models.RawPayload, 0, cfg.batchSize)
+
+	for {
+		select {
+		case p := <-archiveChan:
+			buffer = append(buffer, p)
+			if len(buffer) >= cfg.batchSize {
+				flushArchive(buffer)
+				buffer = buffer[:0]
+			}
+		case <-ticker.C:
+			if len(buffer) > 0 {
+				flushArchive(buffer)
+				buffer = buffer[:0]
+			}
+			if err := archiveGaps.Flush(context.Background()); err != nil {
+				log.Printf("archive gaps: insert err: %v; retrying next flush", err)
+			}
+		}
 	}
 }
 
+// flushArchive inserts a batch of raw payloads. A batch that fails to insert
+// is recorded as archive gaps, so reprocess jobs keep away from its rows.
+func flushArchive(batch []models.RawPayload) {
+	if len(batch) == 0 || metricsRepo == nil {
+		return
+	}
+	if err := metricsRepo.SaveRawPayloads(context.Background(), batch); err != nil {
+		archiveGaps.Record(batch, "archive insert failed: "+err.Error())
+	}
+}
+
+// releaseWAL lets the WAL drop points that are now stored elsewhere. Points
+// that could be neither inserted nor dead-lettered stay in the WAL and are
+// replayed on the next start.
+func releaseWAL(batch []models.SeriesPoint) {
+	if pointsWAL != nil {
+		pointsWAL.Release(batch)
+	}
+}
+
+// openPointsWAL opens the write-ahead log when WAL_DIR is set.
+func openPointsWAL() *wal.WAL {
+	dir := getEnv("WAL_DIR", "")
+	if dir == "" {
+		return nil
+	}
+	w, err := wal.Open(dir, wal.Options{SegmentBytes: int64(getEnvInt("WAL_SEGMENT_BYTES", defaultWALSegmentBytes))})
+	if err != nil {
+		log.Fatal("WAL open failed:", err)
+	}
+	st := w.Stats()
+	log.Printf("wal: dir=%s segments=%d quarantined=%d", dir, st.Segments, st.Quarantined)
+	return w
+}
+
+// replayPointsWAL queues the points left in the WAL by a previous run. It
+// blocks on the queue, so it runs after the writers have started.
+func replayPointsWAL() {
+	if pointsWAL == nil {
+		return
+	}
+	n, err := pointsWAL.Replay(func(points []models.SeriesPoint) {
+		for _, p := range points {
+			metricPointsChan <- p
+		}
+	})
+	if err != nil {
+		log.Printf("wal: replay stopped after %d points: %v", n, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("wal: replayed %d points", n)
+	}
+	if st := pointsWAL.Stats(); st.CorruptRecords > 0 {
+		log.Printf("wal: skipped %d corrupt records (%d bytes) during replay", st.CorruptRecords, st.SkippedBytes)
+	}
+}
+
+// openDeadLetterSpool opens the spool for failed batches and starts retrying
+// it. The spool defaults to DATA_DIR/deadletter, which must be on a
+// persistent volume; DEAD_LETTER_DIR=off disables it. A spool that cannot be
+// opened stops startup rather than silently dropping failed batches.
+func openDeadLetterSpool() *deadletter.Spool {
+	dir := getEnv("DEAD_LETTER_DIR", filepath.Join(getEnv("DATA_DIR", defaultDataDir), "deadletter"))
+	if dir == "off" {
+		return nil
+	}
+
+	spool, err := deadletter.Open(dir, deadletter.Options{
+		MaxBytes:    int64(getEnvInt("DEAD_LETTER_MAX_BYTES", defaultDeadLetterMaxBytes)),
+		BaseDelay:   time.Duration(getEnvInt("DEAD_LETTER_RETRY_SECONDS", defaultDeadLetterRetrySec)) * time.Second,
+		MaxDelay:    time.Duration(getEnvInt("DEAD_LETTER_MAX_BACKOFF_SECONDS", defaultDeadLetterMaxBackoffSec)) * time.Second,
+		MaxAttempts: getEnvInt("DEAD_LETTER_MAX_ATTEMPTS", defaultDeadLetterMaxAttempts),
+	})
+	if err != nil {
+		log.Fatalf("dead-letter spool: %v (mount a writable persistent volume at %s, point DEAD_LETTER_DIR at one, or set DEAD_LETTER_DIR=off)", err, dir)
+	}
+
+	n, size := spool.Stats()
+	log.Printf("dead-letter spool: dir=%s batches=%d bytes=%d quarantined=%d", spool.Dir(), n, size, spool.Quarantined())
+	go spool.Run(context.Background(), metricsRepo, deadLetterPollInterval)
+	return spool
+}
+
 func runMigrations(db *sql.DB) error {
 	_, _ = db.Exec(`CREATE TABLE IF NOT EXISTS _migrations (
         filename TEXT PRIMARY KEY,
@@ -217,6 +448,37 @@ func runMigrations(db *sql.DB) error {
 	return nil
 }
 
+// loadSeriesMapping installs the mapping from SERIES_MAPPING_FILE (if set) and
+// reloads it on SIGHUP. A file that fails to parse on reload is logged and the
+// previous mapping stays active.
+func loadSeriesMapping(registry *ingest.Registry) {
+	path := getEnv("SERIES_MAPPING_FILE", "")
+	if path == "" {
+		return
+	}
+
+	m, err := ingest.LoadMapping(path)
+	if err != nil {
+		log.Fatal("series mapping load failed:", err)
+	}
+	registry.SetMapping(m)
+	log.Printf("series mapping: loaded %d measurements from %s", len(m.Measurements), path)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			m, err := ingest.LoadMapping(path)
+			if err != nil {
+				log.Println("series mapping: reload failed, keeping previous mapping:", err)
+				continue
+			}
+			registry.SetMapping(m)
+			log.Printf("series mapping: reloaded %d measurements from %s", len(m.Measurements), path)
+		}
+	}()
+}
+
 func withCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -245,12 +507,31 @@ func main() {
 	}
 
 	metricsRepo = repository.NewMetricsRepository(db)
+	deadLetters = openDeadLetterSpool()
 
 	writerBufferSize := getEnvInt("METRIC_POINTS_BUFFER", defaultWriterBufferSize)
 	if writerBufferSize <= 0 {
 		writerBufferSize = defaultWriterBufferSize
 	}
 	metricPointsChan = make(chan models.SeriesPoint, writerBufferSize)
+	pointsWAL = openPointsWAL()
+
+	directInsert := getEnv("DIRECT_INSERT", "") != ""
+	if !directInsert {
+		summaryBufferSize := getEnvInt("SERVER_METRICS_BUFFER", defaultSummaryBufferSize)
+		if summaryBufferSize <= 0 {
+			summaryBufferSize = defaultSummaryBufferSize
+		}
+		summaryChan = make(chan models.CleanMetric, summaryBufferSize)
+	}
+
+	if getEnv("RAW_ARCHIVE", "") != "off" {
+		archiveBufferSize := getEnvInt("RAW_ARCHIVE_BUFFER", defaultArchiveBufferSize)
+		if archiveBufferSize <= 0 {
+			archiveBufferSize = defaultArchiveBufferSize
+		}
+		archiveChan = make(chan models.RawPayload, archiveBufferSize)
+	}
 
 	// if err := runMigrations(db); err != nil {       // <-- ADD THIS LINE
 	// 	log.Fatal("SQL migrations failed:", err)
@@ -263,26 +544,101 @@ func main() {
 	debug := getEnv("DEBUG", "") != ""
 	logPayload := getEnv("LOG_PAYLOAD", "") != ""
 	debugServerID := getEnv("DEBUG_SERVER_ID", "")
+
+	registry := ingest.NewDefaultRegistry()
+	loadSeriesMapping(registry)
+
+	authMode := getEnv("INGEST_AUTH_MODE", handlers.AuthModeOff)
+	if !handlers.ValidAuthMode(authMode) {
+		log.Fatalf("INGEST_AUTH_MODE must be off, optional, token, hmac or any (got %q)", authMode)
+	}
+
+	overflowPolicy := getEnv("METRIC_POINTS_OVERFLOW", handlers.OverflowDrop)
+	if !handlers.ValidOverflowPolicy(overflowPolicy) {
+		log.Fatalf("METRIC_POINTS_OVERFLOW must be drop, block or reject (got %q)", overflowPolicy)
+	}
+
+	clockSkewPolicy := getEnv("INGEST_CLOCK_SKEW_POLICY", handlers.ClockSkewAccept)
+	if !handlers.ValidClockSkewPolicy(clockSkewPolicy) {
+		log.Fatalf("INGEST_CLOCK_SKEW_POLICY must be accept, clamp or reject (got %q)", clockSkewPolicy)
+	}
+
+	archiveGaps = handlers.NewArchiveGaps(metricsRepo)
 	handler := handlers.NewMetricsHandler(
 		metricsRepo,
 		metricPointsChan,
-		debug,
-		getEnv("DIRECT_INSERT", "") != "",
-		logPayload,
-		debugServerID,
+		handlers.Config{
+			Debug:              debug,
+			DirectInsert:       getEnv("DIRECT_INSERT", "") != "",
+			LogPayload:         logPayload,
+			DebugServerID:      debugServerID,
+			MaxBodyBytes:       int64(getEnvInt("INGEST_MAX_BODY_BYTES", defaultIngestMaxBodyBytes)),
+			Registry:           registry,
+			IngestAuthMode:     authMode,
+			HMACMaxSkew:        time.Duration(getEnvInt("INGEST_HMAC_MAX_SKEW_SECONDS", defaultHMACMaxSkewSec)) * time.Second,
+			DeadLetter:         deadLetters,
+			WAL:                pointsWAL,
+			OverflowPolicy:     overflowPolicy,
+			OverflowTimeout:    time.Duration(getEnvInt("METRIC_POINTS_OVERFLOW_TIMEOUT_SECONDS", defaultOverflowTimeoutSec)) * time.Second,
+			RetryAfter:         time.Duration(getEnvInt("METRIC_POINTS_RETRY_AFTER_SECONDS", defaultRetryAfterSec)) * time.Second,
+			Summaries:          summaryChan,
+			IntervalWidth:      time.Duration(getEnvInt("INGEST_INTERVAL_WIDTH_SECONDS", defaultIntervalWidthSec)) * time.Second,
+			ClockSkewPolicy:    clockSkewPolicy,
+			ClockSkewThreshold: time.Duration(getEnvInt("INGEST_CLOCK_SKEW_SECONDS", defaultClockSkewSec)) * time.Second,
+			Archive:            archiveChan,
+			ArchiveGaps:        archiveGaps,
+			ImportMaxBytes:     int64(getEnvInt("IMPORT_MAX_BYTES", defaultImportMaxBytes)),
+			ImportConcurrency:  getEnvInt("IMPORT_CONCURRENCY", defaultImportConcurrency),
+			TagSetWindow:       time.Duration(getEnvInt("TAG_SET_WINDOW_HOURS", defaultTagSetWindowHours)) * time.Hour,
+			TagSeriesPerServer: getEnvInt("TAG_SERIES_PER_SERVER", defaultTagSeriesPerServer),
+		},
 	)
 
+	// Admin endpoints are only exposed when ADMIN_TOKEN is set.
+	var adminTokens, adminTokensRotate, adminTokensRevoke, adminSecrets, adminSecretsRevoke http.HandlerFunc
+	var adminDeadLetters, adminDeadLettersRetry, adminDeadLettersPurge, adminQueue, adminReprocess, adminTags http.HandlerFunc
+	if adminToken := getEnv("ADMIN_TOKEN", ""); adminToken != "" {
+		adminAuth := handlers.AdminAuth(adminToken)
+		adminTokens = rateLimitMiddleware(adminAuth(handler.AdminTokens))
+		adminTokensRotate = rateLimitMiddleware(adminAuth(handler.AdminTokensRotate))
+		adminTokensRevoke = rateLimitMiddleware(adminAuth(handler.AdminTokensRevoke))
+		adminSecrets = rateLimitMiddleware(adminAuth(handler.AdminSecrets))
+		adminSecretsRevoke = rateLimitMiddleware(adminAuth(handler.AdminSecretsRevoke))
+		adminDeadLetters = rateLimitMiddleware(adminAuth(handler.AdminDeadLetters))
+		adminDeadLettersRetry = rateLimitMiddleware(adminAuth(handler.AdminDeadLettersRetry))
+		adminDeadLettersPurge = rateLimitMiddleware(adminAuth(handler.AdminDeadLettersPurge))
+		adminQueue = rateLimitMiddleware(adminAuth(handler.AdminQueue))
+		adminReprocess = rateLimitMiddleware(adminAuth(handler.AdminReprocess))
+		adminTags = rateLimitMiddleware(adminAuth(handler.AdminTags))
+	}
+
 	routes.Register(http.DefaultServeMux, nil, routes.Handlers{
-		Root:              rateLimitMiddleware(handler.Root),
-		Ingest:            handler.Ingest, // /api/metrics bypasses rate limiting
-		Servers:           rateLimitMiddleware(handler.Servers),
-		ServersStatus:     rateLimitMiddleware(handler.ServersStatus),
-		ServersStatusCity: rateLimitMiddleware(handler.ServersStatusCity),
-		MetricsLatest:     rateLimitMiddleware(handler.Latest),
-		MetricsHistory:    rateLimitMiddleware(handler.History),
-		SeriesList:        rateLimitMiddleware(handler.SeriesList),
-		SeriesLatest:      rateLimitMiddleware(handler.SeriesLatest),
-		SeriesQuery:       rateLimitMiddleware(handler.SeriesQuery),
+		Root:                  rateLimitMiddleware(handler.Root),
+		Ingest:                handler.IngestAuth(handler.Ingest),      // /api/metrics bypasses rate limiting
+		Import:                handler.ImportAuth(handler.Import),      // bulk backfill, bounded by IMPORT_CONCURRENCY
+		Write:                 handler.IngestAuth(handler.Write),       // line protocol ingest, also unlimited
+		RemoteWrite:           handler.IngestAuth(handler.RemoteWrite), // Prometheus remote_write, also unlimited
+		OTLPMetrics:           handler.IngestAuth(handler.OTLPMetrics), // OTLP/HTTP metrics, also unlimited
+		Servers:               rateLimitMiddleware(handler.Servers),
+		ServersStatus:         rateLimitMiddleware(handler.ServersStatus),
+		ServersStatusCity:     rateLimitMiddleware(handler.ServersStatusCity),
+		ServersClockSkew:      rateLimitMiddleware(handler.ClockSkew),
+		MetricsLatest:         rateLimitMiddleware(handler.Latest),
+		MetricsHistory:        rateLimitMiddleware(handler.History),
+		SeriesList:            rateLimitMiddleware(handler.SeriesList),
+		SeriesLatest:          rateLimitMiddleware(handler.SeriesLatest),
+		SeriesQuery:           rateLimitMiddleware(handler.SeriesQuery),
+		AdminTokens:           adminTokens,
+		AdminTokensRotate:     adminTokensRotate,
+		AdminTokensRevoke:     adminTokensRevoke,
+		AdminSecrets:          adminSecrets,
+		AdminSecretsRevoke:    adminSecretsRevoke,
+		AdminDeadLetters:      adminDeadLetters,
+		AdminDeadLettersRetry: adminDeadLettersRetry,
+		AdminDeadLettersPurge: adminDeadLettersPurge,
+		AdminQueue:            adminQueue,
+		AdminReprocess:        adminReprocess,
+		AdminTags:             adminTags,
 	})
 
 	workerCount := getEnvInt("METRIC_POINTS_WORKERS", defaultWriterWorkerCount)
@@ -305,6 +661,45 @@ func main() {
 
 	log.Printf("metric writer: workers=%d batch=%d flush=%s buffer=%d", workerCount, batchSize, writerCfg.flushEvery, cap(metricPointsChan))
 	startMetricWriters(workerCount, writerCfg)
+	go replayPointsWAL()
+
+	if summaryChan != nil {
+		summaryWorkers := getEnvInt("SERVER_METRICS_WORKERS", defaultSummaryWorkerCount)
+		if summaryWorkers <= 0 {
+			summaryWorkers = defaultSummaryWorkerCount
+		}
+		summaryBatch := getEnvInt("SERVER_METRICS_BATCH", defaultSummaryBatchSize)
+		if summaryBatch <= 0 {
+			summaryBatch = defaultSummaryBatchSize
+		}
+		summaryFlush := getEnvInt("SERVER_METRICS_FLUSH_SECONDS", defaultSummaryFlushSec)
+		if summaryFlush <= 0 {
+			summaryFlush = defaultSummaryFlushSec
+		}
+		summaryCfg := metricWriterConfig{
+			batchSize:  summaryBatch,
+			flushEvery: time.Duration(summaryFlush) * time.Second,
+		}
+		log.Printf("summary writer: workers=%d batch=%d flush=%s buffer=%d", summaryWorkers, summaryBatch, summaryCfg.flushEvery, cap(summaryChan))
+		startSummaryWriters(summaryWorkers, summaryCfg)
+	}
+
+	if archiveChan != nil {
+		archiveBatch := getEnvInt("RAW_ARCHIVE_BATCH", defaultArchiveBatchSize)
+		if archiveBatch <= 0 {
+			archiveBatch = defaultArchiveBatchSize
+		}
+		archiveFlush := getEnvInt("RAW_ARCHIVE_FLUSH_SECONDS", defaultArchiveFlushSec)
+		if archiveFlush <= 0 {
+			archiveFlush = defaultArchiveFlushSec
+		}
+		archiveCfg := metricWriterConfig{
+			batchSize:  archiveBatch,
+			flushEvery: time.Duration(archiveFlush) * time.Second,
+		}
+		log.Printf("archive writer: batch=%d flush=%s buffer=%d", archiveCfg.batchSize, archiveCfg.flushEvery, cap(archiveChan))
+		go archiveWriter(archiveCfg)
+	}
 	log.Println("Metrics API listening on :8080")
 	log.Fatal(http.ListenAndServe(":8080", withCORS(http.DefaultServeMux)))
 }
@@ -0,0 +1,14 @@
+-- Per-kiosk ingest tokens. Only the SHA-256 of the token is stored; revoked_at
+-- in the future keeps a rotated token valid for its grace period.
+
+CREATE TABLE IF NOT EXISTS ingest_tokens (
+  id           BIGSERIAL PRIMARY KEY,
+  server_id    TEXT NOT NULL,
+  token_hash   TEXT NOT NULL UNIQUE,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  revoked_at   TIMESTAMPTZ NULL,
+  last_used_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ingest_tokens_server_id
+  ON ingest_tokens (server_id);
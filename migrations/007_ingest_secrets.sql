@@ -0,0 +1,9 @@
+-- Per-kiosk HMAC signing secrets. Unlike ingest tokens the secret has to be
+-- stored as-is because the server recomputes the signature with it.
+
+CREATE TABLE IF NOT EXISTS ingest_secrets (
+  server_id  TEXT PRIMARY KEY,
+  secret     TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,67 @@
+-- Unique constraints so retried writes are idempotent.
+-- Existing duplicates are removed first; the newest physical row is kept.
+--
+-- The backfill and dedupe run one day at a time and commit after each day,
+-- so no single transaction rewrites a whole hypertable. Run this file with
+-- autocommit (plain `psql -f`, not `--single-transaction`). It can be run
+-- again if it was interrupted, or if the index build reports duplicates that
+-- were written while it ran.
+--
+-- On hypertables with compressed chunks the UPDATE/DELETE below needs
+-- TimescaleDB 2.11 or newer; on older versions decompress the chunks first.
+
+-- server_metrics: one row per (server_id, time), as the ingest upsert expects
+DO $$
+DECLARE
+    day_start TIMESTAMPTZ;
+    last_time TIMESTAMPTZ;
+BEGIN
+    SELECT date_trunc('day', min(time)), max(time) INTO day_start, last_time FROM server_metrics;
+    WHILE day_start <= last_time LOOP
+        DELETE FROM server_metrics a
+          USING server_metrics b
+          WHERE a.time >= day_start AND a.time < day_start + INTERVAL '1 day'
+            AND b.time >= day_start AND b.time < day_start + INTERVAL '1 day'
+            AND a.server_id = b.server_id
+            AND a.time = b.time
+            AND a.ctid < b.ctid;
+        COMMIT;
+        day_start := day_start + INTERVAL '1 day';
+    END LOOP;
+END$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_server_metrics_server_id_time_unique
+  ON server_metrics (server_id, time);
+
+-- metric_points: one point per series and time; a series is
+-- (server_id, measurement, field, tags), with tags hashed to keep the index small
+ALTER TABLE metric_points ADD COLUMN IF NOT EXISTS tags_hash TEXT NOT NULL DEFAULT '';
+
+DO $$
+DECLARE
+    day_start TIMESTAMPTZ;
+    last_time TIMESTAMPTZ;
+BEGIN
+    SELECT date_trunc('day', min(time)), max(time) INTO day_start, last_time FROM metric_points;
+    WHILE day_start <= last_time LOOP
+        UPDATE metric_points SET tags_hash = md5(tags::text)
+          WHERE time >= day_start AND time < day_start + INTERVAL '1 day'
+            AND tags_hash = '';
+
+        DELETE FROM metric_points a
+          USING metric_points b
+          WHERE a.time >= day_start AND a.time < day_start + INTERVAL '1 day'
+            AND b.time >= day_start AND b.time < day_start + INTERVAL '1 day'
+            AND a.server_id = b.server_id
+            AND a.measurement = b.measurement
+            AND a.field = b.field
+            AND a.tags_hash = b.tags_hash
+            AND a.time = b.time
+            AND a.ctid < b.ctid;
+        COMMIT;
+        day_start := day_start + INTERVAL '1 day';
+    END LOOP;
+END$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_metric_points_series_unique
+  ON metric_points (server_id, measurement, field, tags_hash, time);
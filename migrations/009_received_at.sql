@@ -0,0 +1,7 @@
+-- When the API received each row. Compared with "time" (the kiosk's own
+-- timestamp) this gives delivery lag and exposes kiosks with broken clocks.
+-- Rows written before this migration keep NULL.
+
+ALTER TABLE server_metrics ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ NULL;
+
+ALTER TABLE metric_points ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ NULL;
@@ -0,0 +1,30 @@
+-- Raw ingest payloads, one row per kiosk per request, kept so the current
+-- parser can be re-run over them (POST /api/admin/reprocess). payload is the
+-- gzipped TelegrafPayload JSON; min_time/max_time bound its metric timestamps.
+
+CREATE TABLE IF NOT EXISTS raw_payloads (
+    id BIGSERIAL,
+    received_at TIMESTAMPTZ NOT NULL,
+    server_id TEXT NOT NULL,
+    min_time TIMESTAMPTZ NULL,
+    max_time TIMESTAMPTZ NULL,
+    payload BYTEA NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_raw_payloads_server_id_received_at
+    ON raw_payloads (server_id, received_at);
+
+-- One chunk per day; the payloads are already gzipped, so no compression
+-- policy. Keep them 30 days, longer than the 10 days of metrics they rebuild.
+SELECT create_hypertable('raw_payloads', 'received_at', chunk_time_interval => INTERVAL '1 day', if_not_exists => TRUE);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM timescaledb_information.jobs
+        WHERE hypertable_name = 'raw_payloads'
+          AND proc_name = 'policy_retention'
+    ) THEN
+        PERFORM add_retention_policy('raw_payloads', INTERVAL '30 days');
+    END IF;
+END$$;
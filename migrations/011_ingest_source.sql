@@ -0,0 +1,18 @@
+-- The ingest path of each series point, so reprocess jobs only delete the
+-- points the raw payload archive can rebuild (telegraf, import, reprocess).
+-- Points stored before this column existed keep NULL and are never deleted.
+ALTER TABLE metric_points ADD COLUMN IF NOT EXISTS source TEXT NULL;
+
+-- Payloads that never reached raw_payloads (archive queue full, insert
+-- failed). Reprocess jobs refuse windows overlapping them.
+CREATE TABLE IF NOT EXISTS archive_gaps (
+    id BIGSERIAL PRIMARY KEY,
+    server_id TEXT NOT NULL,
+    min_time TIMESTAMPTZ NOT NULL,
+    max_time TIMESTAMPTZ NOT NULL,
+    payloads INTEGER NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_archive_gaps_server_id_min_time
+    ON archive_gaps (server_id, min_time);